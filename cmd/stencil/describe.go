@@ -22,6 +22,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
+	"go.rgst.io/stencil/internal/codegen"
 	"go.rgst.io/stencil/pkg/stencil"
 )
 
@@ -36,14 +37,18 @@ func NewDescribeCommand() *cli.Command {
 				return errors.New("expected exactly one argument, path to file")
 			}
 
-			return describeFile(c.Args().First())
+			dir := c.String("dir")
+			if dir == "" {
+				dir = "."
+			}
+			return describeFile(dir, c.Args().First())
 		},
 	}
 }
 
-// cleanPath ensures that a path is always relative to the current working directory
-// with no .., . or other path elements.
-func cleanPath(path string) (string, error) {
+// cleanPath ensures that a path is always relative to the provided
+// directory with no .., . or other path elements.
+func cleanPath(dir, path string) (string, error) {
 	// make absolute so we can handle .. and other weird path things
 	// defaults to nothing if already absolute
 	path, err := filepath.Abs(path)
@@ -52,28 +57,33 @@ func cleanPath(path string) (string, error) {
 	}
 
 	// convert absolute -> relative
-	cwd, err := os.Getwd()
+	base, err := filepath.Abs(dir)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to get current working directory")
+		return "", errors.Wrap(err, "failed to get absolute path of project")
 	}
-	path = "." + strings.TrimPrefix(path, cwd)
+	path = "." + strings.TrimPrefix(path, base)
 	return filepath.Clean(path), nil
 }
 
 // describeFile prints information about a file rendered by a template
-func describeFile(filePath string) error {
-	l, err := stencil.LoadLockfile("")
+// in the project in dir. Relative paths are relative to dir.
+func describeFile(dir, filePath string) error {
+	l, err := stencil.LoadLockfile(dir)
 	if err != nil {
 		return errors.Wrap(err, "failed to load lockfile")
 	}
 
+	if !filepath.IsAbs(filePath) {
+		filePath = filepath.Join(dir, filePath)
+	}
+
 	// check if the file exists on disk before we try to find
 	// it in the lockfile
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		return fmt.Errorf("file %q does not exist", filePath)
 	}
 
-	relativeFilePath, err := cleanPath(filePath)
+	relativeFilePath, err := cleanPath(dir, filePath)
 	if err != nil {
 		return errors.Wrap(err, "failed to clean path for searching lockfile")
 	}
@@ -81,9 +91,31 @@ func describeFile(filePath string) error {
 	for _, f := range l.Files {
 		if f.Name == relativeFilePath {
 			fmt.Printf("%s was created by module https://%s (template: %s)\n", f.Name, f.Module, f.Template)
-			return nil
+			return describeBlocks(dir, relativeFilePath)
 		}
 	}
 
 	return fmt.Errorf("file %q isn't created by stencil", filePath)
 }
+
+// describeBlocks prints the blocks of a file rendered by a template,
+// along with their location in the file.
+func describeBlocks(dir, filePath string) error {
+	blocks, err := codegen.ReadBlockInfo(codegen.NewProjectFS(dir), filePath)
+	if err != nil {
+		return errors.Wrap(err, "failed to read blocks")
+	}
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	fmt.Println("Blocks:")
+	for _, b := range blocks {
+		msg := fmt.Sprintf("  %s (lines %d-%d", b.Name, b.StartLine, b.EndLine)
+		if b.Parent != "" {
+			msg += ", nested in " + b.Parent
+		}
+		fmt.Println(msg + ")")
+	}
+	return nil
+}
@@ -60,7 +60,7 @@ func Test_cleanPath(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := cleanPath(tt.args.path)
+			got, err := cleanPath(".", tt.args.path)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("cleanPath() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -0,0 +1,104 @@
+// Copyright (C) 2024 stencil contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"go.rgst.io/stencil/internal/cmd/stencil"
+	"go.rgst.io/stencil/pkg/configuration"
+	"go.rgst.io/stencil/pkg/slogext"
+)
+
+// newExtensionsCommand returns a stencil.Command for the project in the
+// directory provided by the --dir flag.
+func newExtensionsCommand(c *cli.Context, log slogext.Logger) (*stencil.Command, error) {
+	if c.Bool("debug") {
+		log.SetLevel(slogext.DebugLevel)
+		log.Debug("Debug logging enabled")
+	}
+
+	manifest, err := configuration.NewDefaultManifestFromDir(c.String("dir"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse stencil.yaml: %w", err)
+	}
+
+	return stencil.NewCommand(log, manifest, c.String("dir"), true), nil
+}
+
+// NewExtensionsCommand returns a new urfave/cli.Command for the
+// extensions command.
+func NewExtensionsCommand(log slogext.Logger) *cli.Command {
+	return &cli.Command{
+		Name:        "extensions",
+		Description: "Commands to inspect the native extensions loaded by the current project",
+		Subcommands: []*cli.Command{
+			{
+				Name:        "list",
+				Description: "Lists all native extensions loaded by the current project",
+				UsageText:   "extensions list",
+				Action: func(c *cli.Context) error {
+					cmd, err := newExtensionsCommand(c, log)
+					if err != nil {
+						return err
+					}
+					return cmd.ListExtensions(c.Context, c.App.Writer)
+				},
+			},
+			{
+				Name:        "describe",
+				Description: "Lists all functions provided by a native extension",
+				ArgsUsage:   "<name>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "markdown",
+						Usage: "Output markdown documentation for the functions instead of a table",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return errors.New("expected exactly one argument, name of the extension")
+					}
+
+					cmd, err := newExtensionsCommand(c, log)
+					if err != nil {
+						return err
+					}
+					return cmd.DescribeExtension(c.Context, c.App.Writer, c.Args().First(), c.Bool("markdown"))
+				},
+			},
+			{
+				Name: "call",
+				Description: "Calls a native extension function and prints the response as JSON. " +
+					"Arguments that are valid JSON are decoded, otherwise they're passed as strings.",
+				ArgsUsage: "<name.function> [args...]",
+				Action: func(c *cli.Context) error {
+					if c.NArg() < 1 {
+						return errors.New("expected at least one argument, path of the function to call")
+					}
+
+					cmd, err := newExtensionsCommand(c, log)
+					if err != nil {
+						return err
+					}
+					return cmd.CallExtension(c.Context, c.App.Writer, c.Args().First(), c.Args().Tail())
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright (C) 2024 stencil contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"github.com/urfave/cli/v2"
+	"go.rgst.io/stencil/internal/cmd/stencil"
+	"go.rgst.io/stencil/pkg/slogext"
+)
+
+// NewLintCommand returns a new urfave/cli.Command for the lint
+// command.
+func NewLintCommand(log slogext.Logger) *cli.Command {
+	return &cli.Command{
+		Name:        "lint",
+		Description: "Checks the manifest and templates of the module in the current directory for problems",
+		UsageText:   "lint",
+		Action: func(c *cli.Context) error {
+			if c.Bool("debug") {
+				log.SetLevel(slogext.DebugLevel)
+				log.Debug("Debug logging enabled")
+			}
+
+			return stencil.LintModule(log, c.App.Writer, ".")
+		},
+	}
+}
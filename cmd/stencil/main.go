@@ -17,6 +17,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
 
 	"go.rgst.io/stencil/pkg/slogext"
@@ -39,6 +40,12 @@ func main() {
 	log := slogext.New()
 
 	if err := entrypoint(log); err != nil {
+		// The error is part of the report written to stdout, so keep
+		// stdout parseable
+		if errors.As(err, new(*reportedError)) {
+			log = slogext.NewWithWriter(os.Stderr)
+		}
+
 		//nolint:gocritic // Why: We're OK not canceling context in this case.
 		log.WithError(err).Error("failed to run")
 		os.Exit(1)
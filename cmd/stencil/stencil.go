@@ -18,11 +18,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 
 	"github.com/urfave/cli/v2"
 
 	"go.rgst.io/stencil/internal/cmd/stencil"
+	"go.rgst.io/stencil/internal/profile"
+	"go.rgst.io/stencil/internal/tracing"
 	"go.rgst.io/stencil/internal/version"
 	"go.rgst.io/stencil/pkg/configuration"
 	"go.rgst.io/stencil/pkg/slogext"
@@ -30,11 +35,30 @@ import (
 
 // NewStencil returns a new CLI application for stencil.
 func NewStencil(log slogext.Logger) *cli.App {
+	// shutdownTracing flushes spans, see tracing.Setup
+	shutdownTracing := func(context.Context) error { return nil }
+
 	return &cli.App{
 		Version:     version.Version,
 		Name:        "stencil",
 		Description: "a smart templating engine for project development",
+		Before: func(c *cli.Context) error {
+			if output := c.String("output"); output != outputText && output != outputJSON {
+				return fmt.Errorf("unsupported --output %q, expected %q or %q", output, outputText, outputJSON)
+			}
+
+			var err error
+			shutdownTracing, err = tracing.Setup(c.Context, &tracing.Options{File: c.String("trace")})
+			return err
+		},
+		After: func(c *cli.Context) error {
+			if err := shutdownTracing(context.Background()); err != nil {
+				log.WithError(err).Warn("Failed to export traces")
+			}
+			return nil
+		},
 		Action: func(c *cli.Context) error {
+			log := loggerForOutput(c, log)
 			log.Infof("stencil %s", c.App.Version)
 
 			// We don't accept arguments, a user is likely trying to run a
@@ -48,12 +72,36 @@ func NewStencil(log slogext.Logger) *cli.App {
 				log.Debug("Debug logging enabled")
 			}
 
-			manifest, err := configuration.NewDefaultManifest()
-			if err != nil {
-				return fmt.Errorf("failed to parse stencil.yaml: %w", err)
-			}
+			return withProfile(c, func(p *profile.Profiler) error {
+				if c.Bool("recursive") {
+					if c.String("output") == outputJSON {
+						return fmt.Errorf("--output %s can't be used with --recursive", outputJSON)
+					}
+					if c.IsSet("check-order-independence") {
+						return fmt.Errorf("--check-order-independence can't be used with --recursive")
+					}
+					return stencil.RunRecursive(c.Context, log, c.String("dir"), &stencil.RecursiveOptions{
+						DryRun:   c.Bool("dry-run"),
+						Cache:    c.Bool("cache"),
+						Seed:     seedFromFlags(c),
+						Profiler: p,
+					}, c.App.Writer)
+				}
 
-			return stencil.NewCommand(log, manifest, c.Bool("dry-run")).Run(c.Context)
+				manifest, err := configuration.NewDefaultManifestFromDir(c.String("dir"))
+				if err != nil {
+					return fmt.Errorf("failed to parse stencil.yaml: %w", err)
+				}
+
+				cmd := newCommand(c, log, manifest, p)
+				if renders := c.Int("check-order-independence"); renders > 0 {
+					if c.String("output") == outputJSON {
+						return fmt.Errorf("--output %s can't be used with --check-order-independence", outputJSON)
+					}
+					return cmd.CheckOrderIndependence(c.Context, renders, c.App.Writer)
+				}
+				return withReport(c, cmd, cmd.Run)
+			})
 		},
 		Flags: []cli.Flag{
 			&cli.BoolFlag{
@@ -66,11 +114,174 @@ func NewStencil(log slogext.Logger) *cli.App {
 				Usage:   "Enables debug logging for version resolution, template renderer, and other useful information",
 				Aliases: []string{"d"},
 			},
+			&cli.StringFlag{
+				Name:    "dir",
+				Aliases: []string{"C"},
+				Usage:   "Run as if stencil was started in the provided directory",
+				Value:   ".",
+			},
+			&cli.BoolFlag{
+				Name: "cache",
+				Usage: "Reuse the output of templates whose inputs didn't change since the last run, instead of rendering " +
+					"every template. The output is stored in <user cache dir>/stencil/render, which can be removed at any time",
+			},
+			&cli.Int64Flag{
+				Name:  "seed",
+				Usage: "Render templates in the order of the provided seed, one at a time, to reproduce a failing render",
+			},
+			&cli.IntFlag{
+				Name: "check-order-independence",
+				Usage: "Render the provided number of times, each time in a different order, and report the files " +
+					"whose contents differ instead of writing them",
+			},
+			&cli.StringFlag{
+				Name: "profile",
+				Usage: "Print how long each step of the run took, and write them to the provided file as a " +
+					"Chrome trace (viewable with https://ui.perfetto.dev)",
+			},
+			&cli.StringFlag{
+				Name: "trace",
+				Usage: "Write OpenTelemetry spans of the run to the provided file as JSON, instead of to trace.json " +
+					"in the stencil cache directory. Spans are exported via OTLP instead when " +
+					"OTEL_EXPORTER_OTLP_ENDPOINT is set",
+			},
+			&cli.StringFlag{
+				Name: "output",
+				Usage: "Format of the output, either text or json. With json, a report of the run (modules, files, " +
+					"warnings, post-run commands and lockfile changes) is written to stdout and logs to stderr",
+				Value: outputText,
+			},
+			&cli.BoolFlag{
+				Name:  "recursive",
+				Usage: "Render every project (directory with a stencil.yaml or service.yaml) below the directory, sharing fetched modules between them",
+			},
 		},
 		Commands: []*cli.Command{
 			NewDescribeCommand(),
 			NewCreateCommand(),
 			NewUpgradeCommand(log),
+			NewExtensionsCommand(log),
+			NewTestCommand(log),
+			NewLintCommand(log),
 		},
 	}
 }
+
+// Formats of the output of the CLI, see --output
+const (
+	// outputText is human-readable logs
+	outputText = "text"
+
+	// outputJSON is a machine-readable report of the run, see
+	// stencil.Report
+	outputJSON = "json"
+)
+
+// reportedError is an error that was already included in the report
+// written to stdout, see withReport
+type reportedError struct {
+	err error
+}
+
+// Error implements the error interface
+func (e *reportedError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *reportedError) Unwrap() error {
+	return e.err
+}
+
+// loggerForOutput returns the logger to use for the --output format.
+// Logs are written to stderr when stdout is reserved for the report.
+func loggerForOutput(c *cli.Context, log slogext.Logger) slogext.Logger {
+	if c.String("output") != outputJSON {
+		return log
+	}
+	return slogext.NewWithWriter(c.App.ErrWriter)
+}
+
+// withReport runs fn, which runs cmd. When --output is json, cmd records
+// a report of the run, which is written to the app's writer once fn
+// returns, even if it failed. The output of post-run commands is written
+// to stderr instead so that it doesn't end up in the report.
+func withReport(c *cli.Context, cmd *stencil.Command, fn func(ctx context.Context) error) error {
+	if c.String("output") != outputJSON {
+		return fn(c.Context)
+	}
+
+	cmd.EnableReport()
+	cmd.SetPostRunOutput(c.App.ErrWriter)
+	err := fn(c.Context)
+	if werr := cmd.Report().Write(c.App.Writer, err); werr != nil {
+		return errors.Join(err, werr)
+	}
+	if err != nil {
+		return &reportedError{err}
+	}
+	return nil
+}
+
+// newCommand returns a stencil.Command for the project with the
+// provided manifest, configured by the global flags. p is the profiler
+// to record the run with, if any, see withProfile.
+func newCommand(c *cli.Context, log slogext.Logger, manifest *configuration.Manifest,
+	p *profile.Profiler) *stencil.Command {
+	cmd := stencil.NewCommand(log, manifest, c.String("dir"), c.Bool("dry-run"))
+	cmd.SetProfiler(p)
+	if c.Bool("cache") {
+		cmd.EnableRenderCache()
+	}
+	if seed := seedFromFlags(c); seed != nil {
+		cmd.SetSeed(*seed)
+	}
+	return cmd
+}
+
+// seedFromFlags returns the seed set through --seed, or nil if it wasn't
+// set
+func seedFromFlags(c *cli.Context) *int64 {
+	if !c.IsSet("seed") {
+		return nil
+	}
+	seed := c.Int64("seed")
+	return &seed
+}
+
+// withProfile runs fn with a profiler when --profile is set, and with a
+// nil one otherwise. Once fn returns, even if it failed, the recorded
+// timings are written to the app's writer as a table and to the file
+// --profile points to as a Chrome trace.
+func withProfile(c *cli.Context, fn func(p *profile.Profiler) error) error {
+	path := c.String("profile")
+	if path == "" {
+		return fn(nil)
+	}
+
+	p := profile.New()
+	err := fn(p)
+
+	// Keep stdout for the report, see --output
+	w := c.App.Writer
+	if c.String("output") == outputJSON {
+		w = c.App.ErrWriter
+	}
+
+	fmt.Fprintln(w)
+	if werr := p.WriteTable(w); werr != nil {
+		return errors.Join(err, fmt.Errorf("failed to write profile: %w", werr))
+	}
+
+	f, werr := os.Create(path)
+	if werr != nil {
+		return errors.Join(err, fmt.Errorf("failed to create profile: %w", werr))
+	}
+	defer f.Close()
+
+	if werr := p.WriteTrace(f); werr != nil {
+		return errors.Join(err, fmt.Errorf("failed to write profile: %w", werr))
+	}
+	fmt.Fprintf(w, "\nWrote trace to %s, open it with https://ui.perfetto.dev\n", path)
+	return err
+}
@@ -0,0 +1,52 @@
+// Copyright (C) 2024 stencil contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"github.com/urfave/cli/v2"
+	"go.rgst.io/stencil/internal/cmd/stencil"
+	"go.rgst.io/stencil/pkg/slogext"
+)
+
+// NewTestCommand returns a new urfave/cli.Command for the test
+// command.
+func NewTestCommand(log slogext.Logger) *cli.Command {
+	return &cli.Command{
+		Name: "test",
+		Description: "Runs the test cases of the module in the current directory. Every directory in " +
+			stencil.ModuleTestsDir + "/ containing a stencil.yaml is a test case, which is rendered " +
+			"and compared against the files in its expected/ directory",
+		ArgsUsage: "[test case...]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "update",
+				Usage: "Replace the expected files of the test cases with the rendered files",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.Bool("debug") {
+				log.SetLevel(slogext.DebugLevel)
+				log.Debug("Debug logging enabled")
+			}
+
+			return stencil.TestModule(c.Context, log, c.App.Writer, &stencil.TestModuleOpts{
+				Dir:    ".",
+				Cases:  c.Args().Slice(),
+				Update: c.Bool("update"),
+			})
+		},
+	}
+}
@@ -18,7 +18,7 @@ import (
 	"fmt"
 
 	"github.com/urfave/cli/v2"
-	"go.rgst.io/stencil/internal/cmd/stencil"
+	"go.rgst.io/stencil/internal/profile"
 	"go.rgst.io/stencil/pkg/configuration"
 	"go.rgst.io/stencil/pkg/slogext"
 )
@@ -31,6 +31,7 @@ func NewUpgradeCommand(log slogext.Logger) *cli.Command {
 		Description: "Runs stencil with newer modules and updates stencil.lock to use them",
 		UsageText:   "upgrade",
 		Action: func(c *cli.Context) error {
+			log := loggerForOutput(c, log)
 			log.Infof("stencil %s", c.App.Version)
 
 			if c.Bool("debug") {
@@ -38,12 +39,15 @@ func NewUpgradeCommand(log slogext.Logger) *cli.Command {
 				log.Debug("Debug logging enabled")
 			}
 
-			manifest, err := configuration.NewDefaultManifest()
+			manifest, err := configuration.NewDefaultManifestFromDir(c.String("dir"))
 			if err != nil {
 				return fmt.Errorf("failed to parse stencil.yaml: %w", err)
 			}
 
-			return stencil.NewCommand(log, manifest, c.Bool("dry-run")).Upgrade(c.Context)
+			return withProfile(c, func(p *profile.Profiler) error {
+				cmd := newCommand(c, log, manifest, p)
+				return withReport(c, cmd, cmd.Upgrade)
+			})
 		},
 	}
 }
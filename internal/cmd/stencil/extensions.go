@@ -0,0 +1,206 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements the commands used for inspecting
+// the native extensions loaded by a project.
+
+package stencil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"go.rgst.io/stencil/internal/modules/nativeext"
+	"go.rgst.io/stencil/internal/modules/nativeext/apiv1"
+)
+
+// loadExtensions resolves the modules of the project, the same way
+// Run does, and registers all of their extensions with a new host. The
+// returned host must be closed by the caller.
+func (c *Command) loadExtensions(ctx context.Context) (*nativeext.Host, error) {
+	mods, err := c.resolveModules(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	h := nativeext.NewHost(c.log)
+	for _, m := range mods {
+		if err := m.RegisterExtensions(ctx, h); err != nil {
+			h.Close()
+			return nil, fmt.Errorf("failed to load extensions from module %q: %w", m.Name, err)
+		}
+	}
+
+	return h, nil
+}
+
+// ListExtensions writes a table of all native extensions loaded by the
+// project to w.
+func (c *Command) ListExtensions(ctx context.Context, w io.Writer) error {
+	h, err := c.loadExtensions(ctx)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	return listExtensions(h, w)
+}
+
+// listExtensions writes a table of all native extensions registered
+// with h to w, see ListExtensions
+func listExtensions(h *nativeext.Host, w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODULE\tVERSION\tTYPE\tPATH\tSHA256")
+	for _, ext := range h.Extensions() {
+		version := "-"
+		if ext.Version != nil {
+			version = printVersion(ext.Version)
+			if ext.Version.Virtual != "" {
+				version = ext.Version.String()
+			}
+		}
+
+		typ := "plugin"
+		if ext.WASM {
+			typ = "wasm"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", ext.Name, version, typ, ext.Path, ext.Checksum)
+	}
+	return tw.Flush()
+}
+
+// DescribeExtension writes a table of all template functions provided
+// by the native extension with the provided name to w. When markdown is
+// true, markdown documentation for the functions is written instead.
+func (c *Command) DescribeExtension(ctx context.Context, w io.Writer, name string, markdown bool) error {
+	h, err := c.loadExtensions(ctx)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	return describeExtension(h, w, name, markdown)
+}
+
+// describeExtension describes the extension with the provided name
+// registered with h, see DescribeExtension
+func describeExtension(h *nativeext.Host, w io.Writer, name string, markdown bool) error {
+	funcs, err := h.GetTemplateFunctions(name)
+	if err != nil {
+		return err
+	}
+
+	if markdown {
+		return writeExtensionMarkdown(w, name, funcs)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FUNCTION\tDESCRIPTION")
+	for _, f := range funcs {
+		desc, _, _ := strings.Cut(f.Description, "\n")
+		fmt.Fprintf(tw, "%s.%s\t%s\n", name, f.Signature(), desc)
+	}
+	return tw.Flush()
+}
+
+// writeExtensionMarkdown writes markdown documentation for the provided
+// template functions of the extension with the provided name to w.
+func writeExtensionMarkdown(w io.Writer, name string, funcs []*apiv1.TemplateFunction) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# %s\n", name)
+	for _, f := range funcs {
+		fmt.Fprintf(&sb, "\n## %s.%s\n\n", name, f.Name)
+		if f.Description != "" {
+			fmt.Fprintf(&sb, "%s\n\n", strings.TrimSpace(f.Description))
+		}
+
+		usage := []string{"extensions.Call", fmt.Sprintf("%q", name+"."+f.Name)}
+		for i := range f.Arguments {
+			usage = append(usage, "$"+f.Arguments[i].Name)
+		}
+		fmt.Fprintf(&sb, "```go\n{{ %s }}\n```\n\n", strings.Join(usage, " "))
+
+		if len(f.Arguments) != 0 {
+			sb.WriteString("| Argument | Type | Description |\n| --- | --- | --- |\n")
+			for i := range f.Arguments {
+				arg := &f.Arguments[i]
+				typ := apiv1.TypeOrAny(arg.Type)
+				if arg.Optional {
+					typ += " (optional)"
+				}
+				fmt.Fprintf(&sb, "| `%s` | `%s` | %s |\n", arg.Name, typ, arg.Description)
+			}
+			sb.WriteString("\n")
+		}
+
+		fmt.Fprintf(&sb, "Returns: `%s`\n", apiv1.TypeOrAny(f.ReturnType))
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// CallExtension calls the native extension function at fnPath (e.g.
+// github.com/rgst-io/stencil-golang.ParseGoMod) with the provided
+// arguments and writes the JSON encoded response to w. See
+// parseExtensionArg for how arguments are interpreted.
+func (c *Command) CallExtension(ctx context.Context, w io.Writer, fnPath string, args []string) error {
+	h, err := c.loadExtensions(ctx)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	return callExtension(ctx, h, w, fnPath, args)
+}
+
+// callExtension calls the function at fnPath of an extension registered
+// with h, see CallExtension
+func callExtension(ctx context.Context, h *nativeext.Host, w io.Writer, fnPath string, args []string) error {
+	caller, err := h.GetExtensionCaller(ctx)
+	if err != nil {
+		return err
+	}
+
+	callArgs := []any{fnPath}
+	for _, arg := range args {
+		callArgs = append(callArgs, parseExtensionArg(arg))
+	}
+
+	resp, err := caller.Call(callArgs...)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(resp)
+}
+
+// parseExtensionArg converts a command-line argument into a value to
+// pass to an extension function. Arguments that are valid JSON (numbers,
+// booleans, objects, arrays, quoted strings) are decoded, everything
+// else is passed as a string.
+func parseExtensionArg(arg string) any {
+	var v any
+	if err := json.Unmarshal([]byte(arg), &v); err != nil {
+		return arg
+	}
+	return v
+}
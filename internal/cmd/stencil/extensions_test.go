@@ -0,0 +1,201 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"go.rgst.io/stencil/internal/modules/nativeext"
+	"go.rgst.io/stencil/internal/modules/nativeext/apiv1"
+	"go.rgst.io/stencil/pkg/slogext"
+	"gotest.tools/v3/assert"
+)
+
+// testExtension is an extension with a typed and an untyped function,
+// both of which return the arguments they received.
+type testExtension struct{}
+
+// GetConfig implements apiv1.Implementation
+func (*testExtension) GetConfig() (*apiv1.Config, error) {
+	return &apiv1.Config{}, nil
+}
+
+// GetTemplateFunctions implements apiv1.Implementation
+func (*testExtension) GetTemplateFunctions() ([]*apiv1.TemplateFunction, error) {
+	return []*apiv1.TemplateFunction{
+		{
+			Name:        "Typed",
+			Description: "Typed returns its arguments.\nIt has more to say.",
+			Arguments: []apiv1.TemplateFunctionArgument{
+				{Name: "name", Type: apiv1.ArgumentTypeString, Description: "A name"},
+				{Name: "count", Type: apiv1.ArgumentTypeInt, Optional: true},
+			},
+			ReturnType: apiv1.ArgumentTypeList,
+		},
+		{Name: "Untyped", NumberOfArguments: 1},
+	}, nil
+}
+
+// ExecuteTemplateFunction implements apiv1.Implementation
+func (*testExtension) ExecuteTemplateFunction(t *apiv1.TemplateFunctionExec) (interface{}, error) {
+	return t.Arguments, nil
+}
+
+// newTestExtensionHost returns a host with testExtension registered as
+// "test"
+func newTestExtensionHost(t *testing.T) *nativeext.Host {
+	h := nativeext.NewHost(slogext.NewTestLogger(t))
+	t.Cleanup(func() { h.Close() })
+	h.RegisterInprocExtension("test", &testExtension{})
+	return h
+}
+
+func TestParseExtensionArg(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want any
+	}{
+		{arg: "1", want: 1.0},
+		{arg: "true", want: true},
+		{arg: `"quoted"`, want: "quoted"},
+		{arg: `[1,"a"]`, want: []any{1.0, "a"}},
+		{arg: `{"a":1}`, want: map[string]any{"a": 1.0}},
+		{arg: "hello", want: "hello"},
+		{arg: `{"a":`, want: `{"a":`},
+		{arg: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			assert.DeepEqual(t, parseExtensionArg(tt.arg), tt.want)
+		})
+	}
+}
+
+func TestCallExtension(t *testing.T) {
+	tests := []struct {
+		name    string
+		fnPath  string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "typed arguments",
+			fnPath: "test.Typed",
+			args:   []string{"hello", "2"},
+			want:   "[\n  \"hello\",\n  2\n]\n",
+		},
+		{
+			name:   "optional arguments",
+			fnPath: "test.Typed",
+			args:   []string{"hello"},
+			want:   "[\n  \"hello\"\n]\n",
+		},
+		{
+			name:   "untyped arguments",
+			fnPath: "test.Untyped",
+			args:   []string{`{"a":[1,true]}`},
+			want:   "[\n  {\n    \"a\": [\n      1,\n      true\n    ]\n  }\n]\n",
+		},
+		{
+			name:    "invalid argument",
+			fnPath:  "test.Typed",
+			args:    []string{"hello", "two"},
+			wantErr: `argument 2 ("count")`,
+		},
+		{
+			name:    "too many arguments",
+			fnPath:  "test.Untyped",
+			args:    []string{"a", "b"},
+			wantErr: "too many arguments",
+		},
+		{
+			name:    "unknown function",
+			fnPath:  "test.Missing",
+			wantErr: "extension 'test' doesn't provide function 'Missing'",
+		},
+		{
+			name:    "unknown extension",
+			fnPath:  "missing.Typed",
+			wantErr: "unknown extension 'missing'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := callExtension(context.Background(), newTestExtensionHost(t), &buf, tt.fnPath, tt.args)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, buf.String(), tt.want)
+		})
+	}
+}
+
+func TestListExtensions(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NilError(t, listExtensions(newTestExtensionHost(t), &buf))
+	assert.Equal(t, buf.String(), "MODULE  VERSION  TYPE    PATH  SHA256\ntest    -        plugin        \n")
+}
+
+func TestDescribeExtension(t *testing.T) {
+	tests := []struct {
+		name     string
+		ext      string
+		markdown bool
+		want     string
+		wantErr  string
+	}{
+		{
+			name: "table",
+			ext:  "test",
+			want: "FUNCTION                                  DESCRIPTION\n" +
+				"test.Typed(name string, count? int) list  Typed returns its arguments.\n" +
+				"test.Untyped(arg1 any) any                \n",
+		},
+		{
+			name:     "markdown",
+			ext:      "test",
+			markdown: true,
+			want: "# test\n\n## test.Typed\n\nTyped returns its arguments.\nIt has more to say.\n\n" +
+				"```go\n{{ extensions.Call \"test.Typed\" $name $count }}\n```\n\n" +
+				"| Argument | Type | Description |\n| --- | --- | --- |\n" +
+				"| `name` | `string` | A name |\n| `count` | `int (optional)` |  |\n\n" +
+				"Returns: `list`\n\n## test.Untyped\n\n" +
+				"```go\n{{ extensions.Call \"test.Untyped\" }}\n```\n\nReturns: `any`\n",
+		},
+		{
+			name:    "unknown extension",
+			ext:     "missing",
+			wantErr: "unknown extension 'missing'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := describeExtension(newTestExtensionHost(t), &buf, tt.ext, tt.markdown)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, buf.String(), tt.want)
+		})
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements the "stencil lint" command, which
+// statically checks a module.
+
+package stencil
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"go.rgst.io/stencil/internal/codegen"
+	"go.rgst.io/stencil/pkg/configuration"
+	"go.rgst.io/stencil/pkg/slogext"
+	"go.rgst.io/stencil/schemas"
+	"gopkg.in/yaml.v3"
+)
+
+// manifestSchemaURL is the URL the manifest schema is registered as
+const manifestSchemaURL = "https://go.rgst.io/stencil/schemas/manifest.jsonschema.json"
+
+// LintModule statically checks the module at dir and writes every
+// problem found to w. The manifest is validated against its schema and
+// its arguments are checked for consistency, and every template is
+// parsed and checked for calls to unknown functions and invalid block
+// markers. An error is returned if any problem was found.
+func LintModule(log slogext.Logger, w io.Writer, dir string) error {
+	b, err := os.ReadFile(filepath.Join(dir, "manifest.yaml"))
+	if err != nil {
+		return fmt.Errorf("failed to read module manifest, is this a module?: %w", err)
+	}
+
+	problems := lintManifest(b)
+
+	// Templates are still checked when the manifest is invalid, just
+	// without any custom block comment styles.
+	var mf configuration.TemplateRepositoryManifest
+	_ = yaml.Unmarshal(b, &mf) //nolint:errcheck // Why: reported by lintManifest
+
+	tplProblems, err := lintTemplates(log, dir, mf.BlockCommentStyles)
+	if err != nil {
+		return err
+	}
+	problems = append(problems, tplProblems...)
+
+	for _, p := range problems {
+		fmt.Fprintln(w, p)
+	}
+
+	if len(problems) != 0 {
+		return fmt.Errorf("found %d problem(s)", len(problems))
+	}
+
+	fmt.Fprintln(w, "ok, no problems found")
+	return nil
+}
+
+// lintManifest returns all problems found in the provided module
+// manifest.
+func lintManifest(b []byte) []string {
+	var raw any
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return []string{fmt.Sprintf("manifest.yaml: failed to parse: %v", err)}
+	}
+
+	problems := validateManifestSchema(raw)
+
+	var mf configuration.TemplateRepositoryManifest
+	if err := yaml.Unmarshal(b, &mf); err != nil {
+		// The schema validation reports why the manifest can't be decoded.
+		return problems
+	}
+
+	names := make([]string, 0, len(mf.Arguments))
+	for name := range mf.Arguments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		arg := mf.Arguments[name]
+		prefix := fmt.Sprintf("manifest.yaml: argument %q", name)
+
+		if arg.Required && arg.Default != nil {
+			problems = append(problems, prefix+": required and default can't both be set")
+		}
+
+		if err := codegen.ValidateArgumentDefault(name, &arg); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: default doesn't match schema: %s", prefix, schemaError(err)))
+		}
+
+		if arg.From != "" && !slices.ContainsFunc(mf.Modules, func(m *configuration.TemplateRepository) bool {
+			return m.Name == arg.From
+		}) {
+			problems = append(problems, fmt.Sprintf("%s: from references module %q, which isn't a dependency", prefix, arg.From))
+		}
+	}
+
+	return problems
+}
+
+// validateManifestSchema validates the provided decoded manifest against
+// the manifest schema.
+func validateManifestSchema(raw any) []string {
+	jsc := jsonschema.NewCompiler()
+	if err := jsc.AddResource(manifestSchemaURL, bytes.NewReader(schemas.Manifest)); err != nil {
+		return []string{fmt.Sprintf("manifest.yaml: failed to load schema: %v", err)}
+	}
+
+	schema, err := jsc.Compile(manifestSchemaURL)
+	if err != nil {
+		return []string{fmt.Sprintf("manifest.yaml: failed to compile schema: %v", err)}
+	}
+
+	// Round trip through JSON so that values have the types the schema
+	// validator expects.
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return []string{fmt.Sprintf("manifest.yaml: failed to convert to JSON: %v", err)}
+	}
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return []string{fmt.Sprintf("manifest.yaml: failed to convert to JSON: %v", err)}
+	}
+
+	if err := schema.Validate(v); err != nil {
+		return []string{"manifest.yaml: doesn't match schema: " + schemaError(err)}
+	}
+	return nil
+}
+
+// schemaError returns a single line description of a schema validation
+// error.
+func schemaError(err error) string {
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return err.Error()
+	}
+
+	var msgs []string
+	for _, e := range verr.BasicOutput().Errors {
+		if e.Error == "" || strings.HasPrefix(e.Error, "doesn't validate with") {
+			continue
+		}
+		loc := e.InstanceLocation
+		if loc == "" {
+			loc = "/"
+		}
+		msgs = append(msgs, loc+": "+e.Error)
+	}
+	if len(msgs) == 0 {
+		return verr.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// trailingLocation matches errors that end with the location they
+// occurred at, e.g. "invalid Block, at templates/a.tpl:3"
+var trailingLocation = regexp.MustCompile(`^(.*), (?:at|started at) \S+:(\d+)$`)
+
+// lintTemplates returns all problems found in the templates of the
+// module at dir.
+func lintTemplates(log slogext.Logger, dir string,
+	commentStyles map[string][]*configuration.BlockCommentStyle) ([]string, error) {
+	var problems []string
+	err := filepath.WalkDir(filepath.Join(dir, "templates"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".tpl" {
+			return err
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		for _, err := range codegen.LintTemplate(rel, b, commentStyles, log) {
+			msg := err.Error()
+			if m := trailingLocation.FindStringSubmatch(msg); m != nil {
+				// Move the location of block errors to the front, to
+				// match other problems. The template is checked as the
+				// file it renders, so the path is replaced as well.
+				msg = rel + ":" + m[2] + ": " + m[1]
+			} else if !strings.HasPrefix(msg, "template: ") && !strings.HasPrefix(msg, rel) {
+				msg = rel + ": " + msg
+			}
+			problems = append(problems, msg)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		// Modules without templates, e.g. native extensions
+		return nil, nil
+	}
+	return problems, err
+}
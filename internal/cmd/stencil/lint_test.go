@@ -0,0 +1,50 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"go.rgst.io/stencil/pkg/slogext"
+	"gotest.tools/v3/assert"
+)
+
+func TestLintReportsProblems(t *testing.T) {
+	var buf bytes.Buffer
+	err := LintModule(slogext.NewTestLogger(t), &buf, "testdata/lint")
+	assert.ErrorContains(t, err, "found 7 problem(s)")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, len(lines), 7, buf.String())
+	assert.Assert(t, strings.HasPrefix(lines[0], "manifest.yaml: doesn't match schema: "), lines[0])
+	assert.Assert(t, strings.Contains(lines[0], "additionalProperties 'unknownKey' not allowed"), lines[0])
+	assert.DeepEqual(t, lines[1:], []string{
+		`manifest.yaml: argument "badDefault": default doesn't match schema: /: expected integer, but got string`,
+		`manifest.yaml: argument "both": required and default can't both be set`,
+		`manifest.yaml: argument "fromMissing": from references module "github.com/rgst-io/stencil-missing", which isn't a dependency`,
+		`templates/blocks.tpl:3: <<Stencil::EndBlock>> should be <</Stencil::Block>>`,
+		`template: templates/undefined.tpl:1: function "notAFunction" not defined`,
+		`templates/unknown-function.tpl:3:7: file.DoesNotExist is not a function`,
+	})
+}
+
+func TestLintPassesValidModule(t *testing.T) {
+	var buf bytes.Buffer
+	err := LintModule(slogext.NewTestLogger(t), &buf, "testdata/module")
+	assert.NilError(t, err, buf.String())
+	assert.Equal(t, buf.String(), "ok, no problems found\n")
+}
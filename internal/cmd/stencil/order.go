@@ -0,0 +1,173 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements checking that the output of a
+// project doesn't depend on the order templates are rendered in.
+
+package stencil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"go.rgst.io/stencil/internal/modules"
+)
+
+// orderRender is the output of rendering a project with a seed
+type orderRender struct {
+	// seed is the seed the templates were rendered in the order of
+	seed int64
+
+	// files are the rendered files, by path. Deleted and skipped files
+	// are described instead of their contents.
+	files map[string]string
+
+	// err is the error rendering failed with, if it did
+	err error
+}
+
+// CheckOrderIndependence renders the project renders times, each time
+// in a different order, and reports the files whose contents differ
+// between them to w. The seed set through SetSeed, or a random one, is
+// used for the first render and incremented for each other render.
+// Files aren't written, and an error is returned if any file differs.
+func (c *Command) CheckOrderIndependence(ctx context.Context, renders int, w io.Writer) error {
+	if renders < 2 {
+		return fmt.Errorf("at least 2 renders are needed to compare them, got %d", renders)
+	}
+
+	c.log.Info("Fetching dependencies")
+	mods, err := c.resolveModules(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	seed := time.Now().UnixNano()
+	if c.seed != nil {
+		seed = *c.seed
+	}
+
+	results := make([]*orderRender, 0, renders)
+	for i := range renders {
+		c.log.Infof("Rendering templates (%d/%d, seed %d)", i+1, renders, seed+int64(i))
+		results = append(results, c.renderWithSeed(ctx, mods, seed+int64(i)))
+	}
+
+	diffs := diffOrderRenders(results)
+	if len(diffs) == 0 && results[0].err != nil {
+		// Every render failed the same way, so there's nothing to compare
+		return fmt.Errorf("render failed with seed %d: %w", results[0].seed, results[0].err)
+	}
+	if len(diffs) == 0 {
+		fmt.Fprintf(w, "Rendered %d times in different orders, the output was the same every time\n", renders)
+		return nil
+	}
+
+	fmt.Fprintf(w, "Rendered %d times in different orders, the output differed:\n  %s\n",
+		renders, strings.Join(diffs, "\n  "))
+	return fmt.Errorf("the output of the project depends on the order templates are rendered in")
+}
+
+// renderWithSeed renders the project with the given modules, in the
+// order of the provided seed, without writing any files
+func (c *Command) renderWithSeed(ctx context.Context, mods []*modules.Module, seed int64) *orderRender {
+	st := c.newStencil(mods)
+	defer st.Close()
+	st.SetSeed(seed)
+
+	r := &orderRender{seed: seed, files: make(map[string]string)}
+	if r.err = st.RegisterExtensions(ctx); r.err != nil {
+		return r
+	}
+
+	tpls, err := st.Render(ctx, c.log)
+	if err != nil {
+		r.err = err
+		return r
+	}
+
+	// Later templates overwrite the files of earlier ones, like when the
+	// files are written
+	for _, t := range tpls {
+		for _, f := range t.Files {
+			switch {
+			case f.Deleted:
+				r.files[f.Name()] = "(deleted)"
+			case f.Skipped:
+				r.files[f.Name()] = "(skipped: " + f.SkippedReason + ")"
+			case f.SymlinkTarget() != "":
+				r.files[f.Name()] = "(symlink to " + f.SymlinkTarget() + ")"
+			default:
+				r.files[f.Name()] = f.String()
+			}
+		}
+	}
+	return r
+}
+
+// diffOrderRenders describes how the provided renders differ from the
+// first one, sorted by file
+func diffOrderRenders(results []*orderRender) []string {
+	first := results[0]
+
+	var diffs []string
+	for _, r := range results[1:] {
+		switch {
+		case (r.err == nil) != (first.err == nil):
+			failed, ok := r, first
+			if first.err != nil {
+				failed, ok = first, r
+			}
+			diffs = append(diffs, fmt.Sprintf("rendering failed with seed %d but not with seed %d: %v",
+				failed.seed, ok.seed, failed.err))
+		case r.err != nil && first.err != nil && r.err.Error() != first.err.Error():
+			diffs = append(diffs, fmt.Sprintf("rendering failed differently with seed %d (%v) and seed %d (%v)",
+				first.seed, first.err, r.seed, r.err))
+		}
+	}
+
+	paths := make(map[string]bool)
+	for _, r := range results {
+		for path := range r.files {
+			paths[path] = true
+		}
+	}
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	for _, path := range sorted {
+		for _, r := range results[1:] {
+			// Only compare renders that succeeded, failures are reported
+			// above
+			if r.err != nil || first.err != nil {
+				continue
+			}
+
+			want, wantOK := first.files[path]
+			got, gotOK := r.files[path]
+			if wantOK != gotOK || want != got {
+				diffs = append(diffs, fmt.Sprintf("%s: differs between seed %d and seed %d", path, first.seed, r.seed))
+				break
+			}
+		}
+	}
+	return diffs
+}
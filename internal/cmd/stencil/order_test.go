@@ -0,0 +1,104 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.rgst.io/stencil/pkg/configuration"
+	"go.rgst.io/stencil/pkg/slogext"
+	"gotest.tools/v3/assert"
+)
+
+// newProjectCommandForTest returns a Command for the project in dir,
+// see writeProject
+func newProjectCommandForTest(t *testing.T, dir string) *Command {
+	manifest, err := configuration.NewManifest(filepath.Join(dir, "stencil.yaml"))
+	assert.NilError(t, err)
+	return NewCommand(slogext.NewTestLogger(t), manifest, dir, true)
+}
+
+func TestCheckOrderIndependence(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	dir := t.TempDir()
+	writeProject(t, dir, "test")
+
+	c := newProjectCommandForTest(t, dir)
+	c.SetSeed(1)
+
+	var buf bytes.Buffer
+	assert.NilError(t, c.CheckOrderIndependence(context.Background(), 3, &buf))
+	assert.Equal(t, buf.String(), "Rendered 3 times in different orders, the output was the same every time\n")
+
+	_, err := os.Stat(filepath.Join(dir, "hello.txt"))
+	assert.Assert(t, os.IsNotExist(err), "expected no files to be written")
+}
+
+func TestCheckOrderIndependenceReportsDifferences(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	// Both templates write the same file, so which one wins depends on
+	// the order they're rendered in
+	mod := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(mod, "templates"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(mod, "manifest.yaml"),
+		[]byte("name: github.com/rgst-io/stencil-order\n"), 0o644))
+	for _, name := range []string{"a", "b"} {
+		assert.NilError(t, os.WriteFile(filepath.Join(mod, "templates", name+".tpl"),
+			[]byte(`{{- file.SetPath "out.txt" }}`+name), 0o644))
+	}
+
+	dir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "stencil.yaml"), []byte(
+		"name: test\nmodules:\n  - name: github.com/rgst-io/stencil-order\n"+
+			"replacements:\n  github.com/rgst-io/stencil-order: file://"+mod+"\n"), 0o644))
+
+	c := newProjectCommandForTest(t, dir)
+	c.SetSeed(1)
+
+	var buf bytes.Buffer
+	err := c.CheckOrderIndependence(context.Background(), 8, &buf)
+	assert.ErrorContains(t, err, "depends on the order templates are rendered in")
+	assert.Assert(t, bytes.Contains(buf.Bytes(), []byte("out.txt: differs between seed 1 and seed ")), buf.String())
+}
+
+func TestCheckOrderIndependenceFailsWhenEveryRenderFails(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	mod := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(mod, "templates"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(mod, "manifest.yaml"),
+		[]byte("name: github.com/rgst-io/stencil-order\n"), 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(mod, "templates", "fail.tpl"),
+		[]byte(`{{ fail "broken" }}`), 0o644))
+
+	dir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "stencil.yaml"), []byte(
+		"name: test\nmodules:\n  - name: github.com/rgst-io/stencil-order\n"+
+			"replacements:\n  github.com/rgst-io/stencil-order: file://"+mod+"\n"), 0o644))
+
+	c := newProjectCommandForTest(t, dir)
+	c.SetSeed(1)
+
+	var buf bytes.Buffer
+	err := c.CheckOrderIndependence(context.Background(), 3, &buf)
+	assert.ErrorContains(t, err, "render failed with seed 1")
+	assert.ErrorContains(t, err, "broken")
+	assert.Equal(t, buf.String(), "")
+}
@@ -0,0 +1,190 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements rendering every project below a
+// directory.
+
+package stencil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.rgst.io/stencil/internal/modules"
+	"go.rgst.io/stencil/internal/profile"
+	"go.rgst.io/stencil/pkg/configuration"
+	"go.rgst.io/stencil/pkg/slogext"
+)
+
+// ProjectManifestNames are the names of the manifest files that denote
+// a project when searching for projects, in the order they're preferred
+// in, see configuration.NewDefaultManifestFromDir
+var ProjectManifestNames = []string{"stencil.yaml", "service.yaml"}
+
+// summaryActions are the actions shown in the summary of a project, in
+// the order they're shown in
+var summaryActions = []string{"Created", "Updated", "Deleted", "Skipped"}
+
+// FindProjects returns the directories below root, including root
+// itself, that contain a project manifest (see ProjectManifestNames),
+// sorted by path. Hidden directories (e.g., .git) and the test cases of
+// modules (see ModuleTestsDir) aren't searched.
+func FindProjects(root string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		// Test cases contain a stencil.yaml, but only render as part of
+		// their module
+		if path != root && d.Name() == ModuleTestsDir {
+			if _, err := os.Stat(filepath.Join(filepath.Dir(path), "manifest.yaml")); err == nil {
+				return filepath.SkipDir
+			}
+		}
+
+		if projectManifest(path) != "" {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for projects in %q: %w", root, err)
+	}
+
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// projectManifest returns the name of the manifest of the project in
+// dir, or an empty string if dir doesn't contain one
+func projectManifest(dir string) string {
+	for _, name := range ProjectManifestNames {
+		if inf, err := os.Stat(filepath.Join(dir, name)); err == nil && !inf.IsDir() {
+			return name
+		}
+	}
+	return ""
+}
+
+// RecursiveOptions are options for rendering every project below a
+// directory, see RunRecursive
+type RecursiveOptions struct {
+	// DryRun denotes if files should not be written to disk
+	DryRun bool
+
+	// Cache enables the render cache of the projects, see
+	// Command.EnableRenderCache
+	Cache bool
+
+	// Seed is the seed of the order templates are rendered in, see
+	// Command.SetSeed. A random one is used when nil.
+	Seed *int64
+
+	// Profiler records how long the steps of rendering each project
+	// take, see Command.SetProfiler
+	Profiler *profile.Profiler
+}
+
+// RunRecursive renders every project below root, see FindProjects. The
+// projects share a module cache, so each module is only resolved and
+// fetched once. A summary of each project is written to w once all of
+// them were rendered, and an error is returned if any of them failed.
+func RunRecursive(ctx context.Context, log slogext.Logger, root string, opts *RecursiveOptions, w io.Writer) error {
+	dirs, err := FindProjects(root)
+	if err != nil {
+		return err
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("no projects found in %q (searched for %s)", root, strings.Join(ProjectManifestNames, ", "))
+	}
+
+	cache := modules.NewCache()
+	summaries := make([]string, 0, len(dirs))
+	var failed int
+	for _, dir := range dirs {
+		name, err := filepath.Rel(root, dir)
+		if err != nil {
+			name = dir
+		}
+
+		plog := log.With("project", name)
+		plog.Infof("Rendering project %s", name)
+
+		c, err := newProjectCommand(plog, dir, opts.DryRun)
+		if err == nil {
+			c.cache = cache
+			if opts.Cache {
+				c.EnableRenderCache()
+			}
+			if opts.Seed != nil {
+				c.SetSeed(*opts.Seed)
+			}
+			c.SetProfiler(opts.Profiler)
+			err = c.Run(ctx)
+		}
+		if err != nil {
+			plog.WithError(err).Error("Failed to render project")
+			summaries = append(summaries, fmt.Sprintf("  %s: failed: %v", name, err))
+			failed++
+			continue
+		}
+		summaries = append(summaries, fmt.Sprintf("  %s: %s", name, c.summary()))
+	}
+
+	fmt.Fprintf(w, "Rendered %d project(s):\n%s\n", len(dirs), strings.Join(summaries, "\n"))
+	if failed > 0 {
+		return fmt.Errorf("%d of %d project(s) failed to render", failed, len(dirs))
+	}
+	return nil
+}
+
+// newProjectCommand returns a Command for the project in dir
+func newProjectCommand(log slogext.Logger, dir string, dryRun bool) (*Command, error) {
+	name := projectManifest(dir)
+	manifest, err := configuration.NewManifest(filepath.Join(dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return NewCommand(log, manifest, dir, dryRun), nil
+}
+
+// summary returns a one line summary of what the last run of c did to
+// the files of the project
+func (c *Command) summary() string {
+	var parts []string
+	for _, action := range summaryActions {
+		if n := c.actions[action]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, strings.ToLower(action)))
+		}
+	}
+	if len(parts) == 0 {
+		return "no files"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,154 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"go.rgst.io/stencil/pkg/slogext"
+	"gotest.tools/v3/assert"
+)
+
+// writeProject writes a project named name into dir that uses the test
+// module through a replacement relative to dir
+func writeProject(t *testing.T, dir, name string) {
+	mod, err := filepath.Abs("testdata/module")
+	assert.NilError(t, err)
+	assert.NilError(t, os.MkdirAll(dir, 0o755))
+	rel, err := filepath.Rel(dir, mod)
+	assert.NilError(t, err)
+
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "stencil.yaml"), []byte(fmt.Sprintf(
+		"name: %s\narguments:\n  greeting: Hello\nmodules:\n  - name: github.com/rgst-io/stencil-test\n"+
+			"replacements:\n  github.com/rgst-io/stencil-test: file://%s\n", name, rel,
+	)), 0o644))
+}
+
+func TestFindProjects(t *testing.T) {
+	root := t.TempDir()
+	writeProject(t, root, "root")
+	writeProject(t, filepath.Join(root, "services", "b"), "b")
+	writeProject(t, filepath.Join(root, "a"), "a")
+	writeProject(t, filepath.Join(root, ".git", "c"), "c")
+
+	// Projects using the legacy manifest name are found
+	assert.NilError(t, os.MkdirAll(filepath.Join(root, "legacy"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(root, "legacy", "service.yaml"), []byte("name: legacy\n"), 0o644))
+
+	// The test cases of modules aren't projects, but a tests directory
+	// outside of a module can contain them
+	assert.NilError(t, os.WriteFile(filepath.Join(root, "manifest.yaml"), []byte("name: module\n"), 0o644))
+	writeProject(t, filepath.Join(root, "tests", "basic"), "basic")
+	writeProject(t, filepath.Join(root, "a", "tests", "d"), "d")
+
+	dirs, err := FindProjects(root)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, dirs, []string{
+		root,
+		filepath.Join(root, "a"),
+		filepath.Join(root, "a", "tests", "d"),
+		filepath.Join(root, "legacy"),
+		filepath.Join(root, "services", "b"),
+	})
+}
+
+func TestRunRecursive(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	root := t.TempDir()
+	writeProject(t, filepath.Join(root, "a"), "a")
+	writeProject(t, filepath.Join(root, "services", "b"), "b")
+
+	var buf bytes.Buffer
+	err := RunRecursive(context.Background(), slogext.NewTestLogger(t), root, &RecursiveOptions{}, &buf)
+	assert.NilError(t, err, buf.String())
+	assert.Equal(t, buf.String(), "Rendered 2 project(s):\n  a: 1 created\n  services/b: 1 created\n")
+
+	for dir, name := range map[string]string{"a": "a", "services/b": "b"} {
+		b, err := os.ReadFile(filepath.Join(root, dir, "hello.txt"))
+		assert.NilError(t, err)
+		assert.Equal(t, string(b), "Hello, "+name+"!\n")
+
+		_, err = os.Stat(filepath.Join(root, dir, "stencil.lock"))
+		assert.NilError(t, err, "expected lockfile to be written")
+	}
+}
+
+func TestRunRecursiveReportsFailedProjects(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	root := t.TempDir()
+	writeProject(t, filepath.Join(root, "a"), "a")
+	assert.NilError(t, os.MkdirAll(filepath.Join(root, "b"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(root, "b", "stencil.yaml"), []byte("name: Not Valid\n"), 0o644))
+
+	var buf bytes.Buffer
+	err := RunRecursive(context.Background(), slogext.NewTestLogger(t), root, &RecursiveOptions{DryRun: true}, &buf)
+	assert.ErrorContains(t, err, "1 of 2 project(s) failed to render")
+	assert.Assert(t, bytes.Contains(buf.Bytes(), []byte("  a: 1 created\n")), buf.String())
+	assert.Assert(t, bytes.Contains(buf.Bytes(), []byte("  b: failed: failed to parse stencil.yaml")), buf.String())
+
+	_, err = os.Stat(filepath.Join(root, "a", "hello.txt"))
+	assert.Assert(t, os.IsNotExist(err), "expected dry-run to not write files")
+}
+
+func TestRunReadsGitFromProjectDir(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	mod := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(mod, "templates"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(mod, "manifest.yaml"),
+		[]byte("name: github.com/rgst-io/stencil-git\n"), 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(mod, "templates", "ref.txt.tpl"),
+		[]byte(`{{ .Git.Ref }}`), 0o644))
+
+	// The project is in a subdirectory of a repository that isn't the
+	// current directory's (i.e. stencil -C)
+	root := t.TempDir()
+	r, err := gogit.PlainInit(root, false)
+	assert.NilError(t, err)
+	wrk, err := r.Worktree()
+	assert.NilError(t, err)
+	_, err = wrk.Commit("initial commit", &gogit.CommitOptions{
+		AllowEmptyCommits: true,
+		Author:            &object.Signature{Name: "Stencil", Email: "email@example.com", When: time.Now()},
+	})
+	assert.NilError(t, err)
+	assert.NilError(t, wrk.Checkout(&gogit.CheckoutOptions{
+		Create: true,
+		Branch: plumbing.NewBranchReferenceName("project-branch"),
+	}))
+
+	dir := filepath.Join(root, "project")
+	assert.NilError(t, os.MkdirAll(dir, 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "stencil.yaml"), []byte(
+		"name: test\nmodules:\n  - name: github.com/rgst-io/stencil-git\n"+
+			"replacements:\n  github.com/rgst-io/stencil-git: file://"+mod+"\n"), 0o644))
+
+	c, err := newProjectCommand(slogext.NewTestLogger(t), dir, false)
+	assert.NilError(t, err)
+	assert.NilError(t, c.Run(context.Background()))
+
+	b, err := os.ReadFile(filepath.Join(dir, "ref.txt"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), "refs/heads/project-branch")
+}
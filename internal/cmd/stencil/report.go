@@ -0,0 +1,391 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements the machine-readable report of a
+// run of the stencil command.
+
+package stencil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"go.rgst.io/stencil/internal/codegen"
+	"go.rgst.io/stencil/internal/modules"
+	"go.rgst.io/stencil/internal/modules/resolver"
+	"go.rgst.io/stencil/pkg/stencil"
+)
+
+// Report is a machine-readable report of what a run of the stencil
+// command did, see Command.EnableReport. It's meant to be consumed by
+// tools, e.g. bots summarizing an upgrade on a pull request.
+type Report struct {
+	// Project is the name of the project that was rendered
+	Project string `json:"project"`
+
+	// DryRun is true if no files were written
+	DryRun bool `json:"dryRun"`
+
+	// Modules are the modules that were used, and their versions
+	Modules []*ReportModule `json:"modules"`
+
+	// Files are the files rendered by templates, and what was done to
+	// them, sorted by name
+	Files []*ReportFile `json:"files"`
+
+	// Warnings are the warnings created while rendering files
+	Warnings []*ReportWarning `json:"warnings"`
+
+	// PostRun are the results of the post-run commands that were run
+	PostRun []*ReportPostRun `json:"postRun"`
+
+	// Lockfile is how the lockfile changed, it's nil if the run didn't
+	// get far enough to generate one
+	Lockfile *ReportLockfileDiff `json:"lockfile"`
+
+	// Error is the error the run failed with, if it failed
+	Error string `json:"error,omitempty"`
+}
+
+// ReportVersion is the version of a module
+type ReportVersion struct {
+	// Commit is the commit of the version
+	Commit string `json:"commit,omitempty"`
+
+	// Tag is the tag of the version, if it's a tag
+	Tag string `json:"tag,omitempty"`
+
+	// Branch is the branch of the version, if it's a branch
+	Branch string `json:"branch,omitempty"`
+
+	// Virtual is set instead when the module is local or replaced
+	Virtual string `json:"virtual,omitempty"`
+}
+
+// ReportModule is a module used by a run, see Report
+type ReportModule struct {
+	// Name is the import path of the module
+	Name string `json:"name"`
+
+	// URL is the URL the module was fetched from
+	URL string `json:"url"`
+
+	// Version is the version of the module that was used
+	Version *ReportVersion `json:"version"`
+}
+
+// ReportFile is a file rendered by a template, see Report
+type ReportFile struct {
+	// Name is the path of the file, relative to the project
+	Name string `json:"name"`
+
+	// Action is what was done to the file: created, updated, deleted or
+	// skipped
+	Action string `json:"action"`
+
+	// Reason is why the file was skipped, if it was
+	Reason string `json:"reason,omitempty"`
+
+	// Template is the path of the template that rendered the file,
+	// relative to its module
+	Template string `json:"template"`
+
+	// Module is the import path of the module of the template
+	Module string `json:"module"`
+
+	// Symlink is the target of the file, if it's a symlink
+	Symlink string `json:"symlink,omitempty"`
+}
+
+// ReportWarning is a warning created while rendering a file, see Report
+type ReportWarning struct {
+	// File is the path of the file the warning is about
+	File string `json:"file"`
+
+	// Message is the warning
+	Message string `json:"message"`
+}
+
+// ReportPostRun is the result of a post-run command, see Report
+type ReportPostRun struct {
+	// Module is the import path of the module the command belongs to
+	Module string `json:"module"`
+
+	// Name is the name of the command
+	Name string `json:"name"`
+
+	// Command is the command that was run
+	Command string `json:"command"`
+
+	// DurationMs is how long the command ran for, in milliseconds
+	DurationMs float64 `json:"durationMs"`
+
+	// ExitCode is the exit code of the command, or -1 if it couldn't be
+	// started
+	ExitCode int `json:"exitCode"`
+
+	// Error is the error the command failed with, if any
+	Error string `json:"error,omitempty"`
+}
+
+// ReportLockfileDiff is how the lockfile changed during a run, see
+// Report. Only entries that changed are included.
+type ReportLockfileDiff struct {
+	// Modules are the modules that were added, removed or whose
+	// version changed
+	Modules []*ReportModuleChange `json:"modules"`
+
+	// Files are the files that were added, removed or whose template,
+	// module, mode or symlink target changed
+	Files []*ReportFileChange `json:"files"`
+}
+
+// Changes to lockfile entries, see ReportLockfileDiff
+const (
+	// ChangeAdded is an entry that's only in the new lockfile
+	ChangeAdded = "added"
+
+	// ChangeRemoved is an entry that's only in the old lockfile
+	ChangeRemoved = "removed"
+
+	// ChangeChanged is an entry that's in both lockfiles, but differs
+	ChangeChanged = "changed"
+)
+
+// ReportModuleChange is a change to a module in the lockfile
+type ReportModuleChange struct {
+	// Name is the import path of the module
+	Name string `json:"name"`
+
+	// Change is either added, removed or changed
+	Change string `json:"change"`
+
+	// From is the version of the module in the old lockfile
+	From *ReportVersion `json:"from,omitempty"`
+
+	// To is the version of the module in the new lockfile
+	To *ReportVersion `json:"to,omitempty"`
+}
+
+// ReportFileChange is a change to a file in the lockfile
+type ReportFileChange struct {
+	// Name is the path of the file
+	Name string `json:"name"`
+
+	// Change is either added, removed or changed
+	Change string `json:"change"`
+
+	// From is the entry of the file in the old lockfile
+	From *ReportFileEntry `json:"from,omitempty"`
+
+	// To is the entry of the file in the new lockfile
+	To *ReportFileEntry `json:"to,omitempty"`
+}
+
+// ReportFileEntry is the entry of a file in a lockfile
+type ReportFileEntry struct {
+	// Template is the template that generated the file
+	Template string `json:"template"`
+
+	// Module is the module of the template
+	Module string `json:"module"`
+
+	// Mode are the permissions of the file, in octal, empty for symlinks
+	Mode string `json:"mode,omitempty"`
+
+	// Symlink is the target of the file, if it's a symlink
+	Symlink string `json:"symlink,omitempty"`
+}
+
+// newReportFileEntry converts f into a ReportFileEntry
+func newReportFileEntry(f *stencil.LockfileFileEntry) *ReportFileEntry {
+	e := &ReportFileEntry{Template: f.Template, Module: f.Module, Mode: f.Mode, Symlink: f.Symlink}
+	if e.Mode == "" && e.Symlink == "" {
+		e.Mode = stencil.LockfileDefaultFileMode
+	}
+	return e
+}
+
+// newReportVersion converts v into a ReportVersion
+func newReportVersion(v *resolver.Version) *ReportVersion {
+	if v == nil {
+		return nil
+	}
+	return &ReportVersion{Commit: v.Commit, Tag: v.Tag, Branch: v.Branch, Virtual: v.Virtual}
+}
+
+// setModules records the modules used by the run
+func (r *Report) setModules(mods []*modules.Module) {
+	if r == nil {
+		return
+	}
+
+	r.Modules = make([]*ReportModule, 0, len(mods))
+	for _, m := range mods {
+		r.Modules = append(r.Modules, &ReportModule{Name: m.Name, URL: m.URI, Version: newReportVersion(m.Version)})
+	}
+}
+
+// addFile records what action was done to f, which was rendered by tpl
+func (r *Report) addFile(tpl *codegen.Template, f *codegen.File, action string) {
+	if r == nil {
+		return
+	}
+
+	rf := &ReportFile{
+		Name:     f.Name(),
+		Action:   strings.ToLower(action),
+		Template: tpl.Path,
+		Module:   tpl.Module.Name,
+		Symlink:  f.SymlinkTarget(),
+	}
+	if f.Skipped {
+		rf.Reason = f.SkippedReason
+	}
+	r.Files = append(r.Files, rf)
+
+	for _, w := range f.Warnings {
+		r.Warnings = append(r.Warnings, &ReportWarning{File: f.Name(), Message: w})
+	}
+}
+
+// setPostRun records the results of the post-run commands
+func (r *Report) setPostRun(results []*codegen.PostRunResult) {
+	if r == nil {
+		return
+	}
+
+	for _, res := range results {
+		rp := &ReportPostRun{
+			Module:     res.Module,
+			Name:       res.Name,
+			Command:    res.Command,
+			DurationMs: float64(res.Duration.Microseconds()) / 1000,
+			ExitCode:   res.ExitCode,
+		}
+		if res.Err != nil {
+			rp.Error = res.Err.Error()
+		}
+		r.PostRun = append(r.PostRun, rp)
+	}
+}
+
+// setLockfile records how the lockfile changed from old, which is nil if
+// there was none, to new
+func (r *Report) setLockfile(old, new *stencil.Lockfile) {
+	if r == nil {
+		return
+	}
+	r.Lockfile = diffLockfiles(old, new)
+}
+
+// Write writes the report to w as JSON. err is the error the run failed
+// with, if any.
+func (r *Report) Write(w io.Writer, err error) error {
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	// Always write lists, even if empty, so that they're easy to consume
+	r.Modules = nonNil(r.Modules)
+	r.Files = nonNil(r.Files)
+	r.Warnings = nonNil(r.Warnings)
+	r.PostRun = nonNil(r.PostRun)
+	sort.SliceStable(r.Files, func(i, j int) bool {
+		return r.Files[i].Name < r.Files[j].Name
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// nonNil returns s, or an empty slice if s is nil
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+// diffLockfiles returns the entries of new that were added, removed or
+// changed compared to old, which is nil if there was no lockfile
+func diffLockfiles(old, new *stencil.Lockfile) *ReportLockfileDiff {
+	if old == nil {
+		old = &stencil.Lockfile{}
+	}
+	diff := &ReportLockfileDiff{Modules: []*ReportModuleChange{}, Files: []*ReportFileChange{}}
+
+	oldModules := make(map[string]*stencil.LockfileModuleEntry, len(old.Modules))
+	for _, m := range old.Modules {
+		oldModules[m.Name] = m
+	}
+	for _, m := range new.Modules {
+		om, ok := oldModules[m.Name]
+		delete(oldModules, m.Name)
+		switch {
+		case !ok:
+			diff.Modules = append(diff.Modules, &ReportModuleChange{
+				Name: m.Name, Change: ChangeAdded, To: newReportVersion(m.Version),
+			})
+		case !om.Version.Equal(m.Version):
+			diff.Modules = append(diff.Modules, &ReportModuleChange{
+				Name: m.Name, Change: ChangeChanged, From: newReportVersion(om.Version), To: newReportVersion(m.Version),
+			})
+		}
+	}
+	for _, om := range oldModules {
+		diff.Modules = append(diff.Modules, &ReportModuleChange{
+			Name: om.Name, Change: ChangeRemoved, From: newReportVersion(om.Version),
+		})
+	}
+
+	oldFiles := make(map[string]*stencil.LockfileFileEntry, len(old.Files))
+	for _, f := range old.Files {
+		oldFiles[f.Name] = f
+	}
+	for _, f := range new.Files {
+		of, ok := oldFiles[f.Name]
+		delete(oldFiles, f.Name)
+		switch {
+		case !ok:
+			diff.Files = append(diff.Files, &ReportFileChange{
+				Name: f.Name, Change: ChangeAdded, To: newReportFileEntry(f),
+			})
+		case *of != *f:
+			diff.Files = append(diff.Files, &ReportFileChange{
+				Name: f.Name, Change: ChangeChanged, From: newReportFileEntry(of), To: newReportFileEntry(f),
+			})
+		}
+	}
+	for _, of := range oldFiles {
+		diff.Files = append(diff.Files, &ReportFileChange{
+			Name: of.Name, Change: ChangeRemoved, From: newReportFileEntry(of),
+		})
+	}
+
+	sort.Slice(diff.Modules, func(i, j int) bool {
+		return diff.Modules[i].Name < diff.Modules[j].Name
+	})
+	sort.Slice(diff.Files, func(i, j int) bool {
+		return diff.Files[i].Name < diff.Files[j].Name
+	})
+	return diff
+}
@@ -0,0 +1,147 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.rgst.io/stencil/internal/modules/resolver"
+	"go.rgst.io/stencil/pkg/configuration"
+	"go.rgst.io/stencil/pkg/slogext"
+	"go.rgst.io/stencil/pkg/stencil"
+	"gotest.tools/v3/assert"
+)
+
+// runWithReport runs stencil on the project in dir and returns its
+// report, decoded from JSON
+func runWithReport(t *testing.T, dir string) *Report {
+	manifest, err := configuration.NewManifest(filepath.Join(dir, "stencil.yaml"))
+	assert.NilError(t, err)
+	c := NewCommand(slogext.NewTestLogger(t), manifest, dir, false)
+	c.EnableReport()
+	c.SetPostRunOutput(io.Discard)
+
+	runErr := c.Run(context.Background())
+	var buf bytes.Buffer
+	assert.NilError(t, c.Report().Write(&buf, runErr))
+
+	var r Report
+	assert.NilError(t, json.Unmarshal(buf.Bytes(), &r), buf.String())
+	return &r
+}
+
+func TestRunReport(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	mod := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(mod, "templates"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(mod, "manifest.yaml"), []byte(
+		"name: github.com/rgst-io/stencil-report\npostRunCommand:\n  - name: fail\n    command: exit 3\n"), 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(mod, "templates", "hello.txt.tpl"), []byte("hello"), 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(mod, "templates", "skip.txt.tpl"),
+		[]byte(`{{ file.Skip "not needed" }}`), 0o644))
+
+	dir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "stencil.yaml"), []byte(
+		"name: test\nmodules:\n  - name: github.com/rgst-io/stencil-report\n"+
+			"replacements:\n  github.com/rgst-io/stencil-report: file://"+mod+"\n"), 0o644))
+
+	r := runWithReport(t, dir)
+	assert.Equal(t, r.Project, "test")
+	assert.Assert(t, strings.Contains(r.Error, "failed to run post run command"), r.Error)
+	assert.DeepEqual(t, r.Modules, []*ReportModule{{
+		Name:    "github.com/rgst-io/stencil-report",
+		URL:     "file://" + mod,
+		Version: &ReportVersion{Virtual: "local"},
+	}})
+	assert.DeepEqual(t, r.Files, []*ReportFile{
+		{Name: "hello.txt", Action: "created", Template: "hello.txt.tpl", Module: "github.com/rgst-io/stencil-report"},
+		{
+			Name: "skip.txt", Action: "skipped", Reason: "not needed",
+			Template: "skip.txt.tpl", Module: "github.com/rgst-io/stencil-report",
+		},
+	})
+	assert.Equal(t, len(r.PostRun), 1)
+	assert.Equal(t, r.PostRun[0].Name, "fail")
+	assert.Equal(t, r.PostRun[0].ExitCode, 3)
+	assert.Assert(t, r.PostRun[0].Error != "")
+	assert.DeepEqual(t, r.Lockfile, &ReportLockfileDiff{
+		Modules: []*ReportModuleChange{{
+			Name: "github.com/rgst-io/stencil-report", Change: ChangeAdded, To: &ReportVersion{Virtual: "local"},
+		}},
+		Files: []*ReportFileChange{{
+			Name: "hello.txt", Change: ChangeAdded, To: &ReportFileEntry{
+				Template: "hello.txt.tpl", Module: "github.com/rgst-io/stencil-report", Mode: "0644",
+			},
+		}},
+	})
+
+	// Nothing changed, so the lockfile didn't either
+	r = runWithReport(t, dir)
+	assert.Equal(t, r.Files[0].Action, "updated")
+	assert.DeepEqual(t, r.Lockfile, &ReportLockfileDiff{Modules: []*ReportModuleChange{}, Files: []*ReportFileChange{}})
+}
+
+func TestDiffLockfiles(t *testing.T) {
+	v1 := &resolver.Version{Tag: "v1.0.0", Commit: "a"}
+	v2 := &resolver.Version{Tag: "v2.0.0", Commit: "b"}
+	old := &stencil.Lockfile{
+		Modules: []*stencil.LockfileModuleEntry{{Name: "a", Version: v1}, {Name: "b", Version: v1}},
+		Files: []*stencil.LockfileFileEntry{
+			{Name: "kept", Template: "kept.tpl", Module: "a"},
+			{Name: "link", Template: "link.tpl", Module: "a"},
+			{Name: "mode", Template: "mode.tpl", Module: "a"},
+			{Name: "removed", Template: "removed.tpl", Module: "b"},
+		},
+	}
+	new := &stencil.Lockfile{
+		Modules: []*stencil.LockfileModuleEntry{{Name: "a", Version: v2}, {Name: "c", Version: v1}},
+		Files: []*stencil.LockfileFileEntry{
+			{Name: "added", Template: "added.tpl", Module: "c"},
+			{Name: "kept", Template: "kept.tpl", Module: "a"},
+			{Name: "link", Template: "link.tpl", Module: "a", Symlink: "kept"},
+			{Name: "mode", Template: "mode.tpl", Module: "a", Mode: "0755"},
+		},
+	}
+
+	assert.DeepEqual(t, diffLockfiles(old, new), &ReportLockfileDiff{
+		Modules: []*ReportModuleChange{
+			{Name: "a", Change: ChangeChanged, From: newReportVersion(v1), To: newReportVersion(v2)},
+			{Name: "b", Change: ChangeRemoved, From: newReportVersion(v1)},
+			{Name: "c", Change: ChangeAdded, To: newReportVersion(v1)},
+		},
+		Files: []*ReportFileChange{
+			{Name: "added", Change: ChangeAdded, To: &ReportFileEntry{Template: "added.tpl", Module: "c", Mode: "0644"}},
+			{
+				Name: "link", Change: ChangeChanged,
+				From: &ReportFileEntry{Template: "link.tpl", Module: "a", Mode: "0644"},
+				To:   &ReportFileEntry{Template: "link.tpl", Module: "a", Symlink: "kept"},
+			},
+			{
+				Name: "mode", Change: ChangeChanged,
+				From: &ReportFileEntry{Template: "mode.tpl", Module: "a", Mode: "0644"},
+				To:   &ReportFileEntry{Template: "mode.tpl", Module: "a", Mode: "0755"},
+			},
+			{Name: "removed", Change: ChangeRemoved, From: &ReportFileEntry{Template: "removed.tpl", Module: "b", Mode: "0644"}},
+		},
+	})
+}
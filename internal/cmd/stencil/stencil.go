@@ -9,14 +9,22 @@ package stencil
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"go.opentelemetry.io/otel/attribute"
 	"go.rgst.io/stencil/internal/codegen"
 	"go.rgst.io/stencil/internal/modules"
 	"go.rgst.io/stencil/internal/modules/resolver"
+	"go.rgst.io/stencil/internal/profile"
+	"go.rgst.io/stencil/internal/tracing"
 	"go.rgst.io/stencil/pkg/configuration"
 	"go.rgst.io/stencil/pkg/slogext"
 	"go.rgst.io/stencil/pkg/stencil"
@@ -38,6 +46,40 @@ type Command struct {
 	// log is the logger used for logging output
 	log slogext.Logger
 
+	// dir is the directory of the project
+	dir string
+
+	// fs is the filesystem of the project, all files of the project are
+	// read from and written to it
+	fs billy.Filesystem
+
+	// cache is the module cache shared with other projects, if any
+	cache *modules.Cache
+
+	// renderCachePath is the path of the render cache of the project, see
+	// codegen.RenderCache. Empty when the render cache is disabled, see
+	// EnableRenderCache.
+	renderCachePath string
+
+	// seed is the seed of the order templates are rendered in, a random
+	// one is used when nil
+	seed *int64
+
+	// profiler records how long the steps of the run take, if set
+	profiler *profile.Profiler
+
+	// actions counts the files by what was done to them (e.g.,
+	// "Created") during the last run
+	actions map[string]int
+
+	// report is the machine-readable report of the run, if enabled, see
+	// EnableReport
+	report *Report
+
+	// postRunOut is where the output of post-run commands is written to,
+	// stdout when nil
+	postRunOut io.Writer
+
 	// dryRun denotes if we should write files to disk or not
 	dryRun bool
 }
@@ -55,9 +97,15 @@ func printVersion(v *resolver.Version) string {
 	return v.Commit
 }
 
-// NewCommand creates a new stencil command
-func NewCommand(log slogext.Logger, s *configuration.Manifest, dryRun bool) *Command {
-	l, err := stencil.LoadLockfile("")
+// NewCommand creates a new stencil command that renders the project in
+// the provided directory, or the current directory if dir is empty
+func NewCommand(log slogext.Logger, s *configuration.Manifest, dir string, dryRun bool) *Command {
+	if dir == "" {
+		dir = "."
+	}
+
+	fs := codegen.NewProjectFS(dir)
+	l, err := stencil.LoadLockfileFS(fs)
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		log.WithError(err).Warn("failed to load lockfile")
 	}
@@ -66,8 +114,70 @@ func NewCommand(log slogext.Logger, s *configuration.Manifest, dryRun bool) *Com
 		lock:     l,
 		manifest: s,
 		log:      log,
+		dir:      dir,
+		fs:       fs,
 		dryRun:   dryRun,
+		actions:  make(map[string]int),
+	}
+}
+
+// renderCachePath returns the path of the render cache of the project
+// in dir, which is stored in the user's cache directory so that it
+// doesn't end up in the project. Returns an empty string, disabling the
+// render cache, if there's no cache directory.
+func renderCachePath(log slogext.Logger, dir string) string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		log.WithError(err).Debug("No cache directory, disabling render cache")
+		return ""
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		log.WithError(err).Debug("Failed to get absolute project directory, disabling render cache")
+		return ""
 	}
+
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(cacheDir, "stencil", "render", hex.EncodeToString(sum[:8])+".json")
+}
+
+// SetSeed sets the seed of the random order templates are rendered in,
+// see codegen.Stencil.SetSeed
+func (c *Command) SetSeed(seed int64) {
+	c.seed = &seed
+}
+
+// SetProfiler sets the profiler that records how long fetching
+// modules, rendering and running post-run commands takes
+func (c *Command) SetProfiler(p *profile.Profiler) {
+	c.profiler = p
+}
+
+// EnableRenderCache makes the command reuse the output of templates
+// whose inputs didn't change since the last run, instead of rendering
+// every template. The output is stored in the user's cache directory,
+// see renderCachePath, and replaced on every run.
+func (c *Command) EnableRenderCache() {
+	c.renderCachePath = renderCachePath(c.log, c.dir)
+}
+
+// EnableReport makes the command record a machine-readable report of
+// what the run did, see Report
+func (c *Command) EnableReport() {
+	c.report = &Report{Project: c.manifest.Name, DryRun: c.dryRun}
+}
+
+// Report returns the report of the run, or nil if it isn't enabled, see
+// EnableReport
+func (c *Command) Report() *Report {
+	return c.report
+}
+
+// SetPostRunOutput sets where the output of post-run commands is written
+// to, by default stdout
+func (c *Command) SetPostRunOutput(w io.Writer) {
+	c.postRunOut = w
 }
 
 // useModulesFromLockfile returns a list of modules from the lockfile
@@ -78,6 +188,8 @@ func (c *Command) useModulesFromLockfile(ctx context.Context) ([]*modules.Module
 		m, err := modules.New(ctx, me.URL, modules.NewModuleOpts{
 			ImportPath: me.Name,
 			Version:    me.Version,
+			Dir:        c.dir,
+			Cache:      c.cache,
 		})
 		if err != nil {
 			return nil, fmt.Errorf("failed to create module: %w", err)
@@ -93,7 +205,11 @@ func (c *Command) useModulesFromLockfile(ctx context.Context) ([]*modules.Module
 // If a lockfile is present, it will use the modules from the lockfile
 // instead of resolving them. If ignoreLockfile is true, it will ignore
 // the lockfile and resolve the modules anyways.
-func (c *Command) resolveModules(ctx context.Context, ignoreLockfile bool) ([]*modules.Module, error) {
+func (c *Command) resolveModules(ctx context.Context, ignoreLockfile bool) (_ []*modules.Module, err error) {
+	defer c.profiler.Start("modules", "FetchModules")()
+	ctx, span := tracing.Start(ctx, "stencil.ResolveModules", attribute.Bool("stencil.ignore_lockfile", ignoreLockfile))
+	defer func() { tracing.End(span, err) }()
+
 	if c.lock != nil && !ignoreLockfile {
 		return c.useModulesFromLockfile(ctx)
 	}
@@ -103,13 +219,18 @@ func (c *Command) resolveModules(ctx context.Context, ignoreLockfile bool) ([]*m
 	return modules.FetchModules(ctx, &modules.ModuleResolveOptions{
 		Manifest: c.manifest,
 		Log:      c.log,
+		Dir:      c.dir,
+		Cache:    c.cache,
 	})
 }
 
 // Upgrade checks for upgrades to the modules in the project and
 // upgrades them if necessary. If no lockfile is present, it will
 // log a message and return without doing anything.
-func (c *Command) Upgrade(ctx context.Context) error {
+func (c *Command) Upgrade(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "stencil.Upgrade", tracing.ProjectKey.String(c.manifest.Name))
+	defer func() { tracing.End(span, err) }()
+
 	if c.lock == nil {
 		c.log.Info("No lockfile found, run 'stencil' to fetch dependencies first")
 		return nil
@@ -120,6 +241,7 @@ func (c *Command) Upgrade(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	c.report.setModules(mods)
 
 	// Convert the lockfile modules to an easy importPath -> version
 	// lookup.
@@ -156,12 +278,16 @@ func (c *Command) Upgrade(ctx context.Context) error {
 // after that GenerateFiles is called to actually walk the filesystem and render
 // the templates. This step also does minimal post-processing of the dependencies
 // manifests
-func (c *Command) Run(ctx context.Context) error {
+func (c *Command) Run(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "stencil.Run", tracing.ProjectKey.String(c.manifest.Name))
+	defer func() { tracing.End(span, err) }()
+
 	c.log.Info("Fetching dependencies")
 	mods, err := c.resolveModules(ctx, false)
 	if err != nil {
 		return err
 	}
+	c.report.setModules(mods)
 
 	for _, m := range mods {
 		c.log.Infof(" -> %s %s", m.Name, printVersion(m.Version))
@@ -170,11 +296,36 @@ func (c *Command) Run(ctx context.Context) error {
 	return c.runWithModules(ctx, mods)
 }
 
+// newStencil returns a renderer for the project with the given modules,
+// it must be closed once done with
+func (c *Command) newStencil(mods []*modules.Module) *codegen.Stencil {
+	st := codegen.NewStencil(c.manifest, mods, c.log)
+	st.SetProjectFS(c.fs)
+	st.SetLockfile(c.lock)
+	if c.seed != nil {
+		st.SetSeed(*c.seed)
+	}
+	st.SetProfiler(c.profiler)
+	if c.postRunOut != nil {
+		st.SetPostRunOutput(c.postRunOut)
+	}
+	return st
+}
+
 // runWithModules runs the stencil command with the given modules
 func (c *Command) runWithModules(ctx context.Context, mods []*modules.Module) error {
-	st := codegen.NewStencil(c.manifest, mods, c.log)
+	st := c.newStencil(mods)
 	defer st.Close()
 
+	var renderCache *codegen.RenderCache
+	if c.renderCachePath != "" {
+		var err error
+		if renderCache, err = codegen.LoadRenderCache(c.renderCachePath); err != nil {
+			c.log.WithError(err).Warn("Failed to load render cache, rendering every template")
+		}
+		st.SetRenderCache(renderCache)
+	}
+
 	c.log.Info("Loading native extensions")
 	if err := st.RegisterExtensions(ctx); err != nil {
 		return err
@@ -183,6 +334,9 @@ func (c *Command) runWithModules(ctx context.Context, mods []*modules.Module) er
 	c.log.Info("Rendering templates")
 	tpls, err := st.Render(ctx, c.log)
 	if err != nil {
+		// Failures can depend on the order templates were rendered in
+		c.log.Infof("Templates were rendered in a random order (seed %d), use --seed %[1]d to render them in the same order",
+			st.Seed())
 		return err
 	}
 
@@ -190,43 +344,56 @@ func (c *Command) runWithModules(ctx context.Context, mods []*modules.Module) er
 		return err
 	}
 
+	// Only save the render cache once the files it describes were written
+	if renderCache != nil && !c.dryRun {
+		if err := renderCache.Save(); err != nil {
+			c.log.WithError(err).Warn("Failed to save render cache")
+		}
+	}
+
 	// Can't dry run post run yet
 	if c.dryRun {
 		c.log.Info("Skipping post-run commands, dry-run")
 		return nil
 	}
 
-	return st.PostRun(ctx, c.log)
+	err = st.PostRun(ctx, c.log)
+	c.report.setPostRun(st.PostRunResults())
+	return err
 }
 
 // writeFile writes a codegen.File to disk based on its current state
-func (c *Command) writeFile(f *codegen.File) error {
+func (c *Command) writeFile(tpl *codegen.Template, f *codegen.File) error {
 	action := "Created"
 	if f.Deleted {
 		action = "Deleted"
 
 		if !c.dryRun {
-			os.Remove(f.Name())
+			if err := util.RemoveAll(c.fs, f.Name()); err != nil {
+				return fmt.Errorf("failed to remove %q: %w", f.Name(), err)
+			}
 		}
 	} else if f.Skipped {
 		action = "Skipped"
-	} else if _, err := os.Stat(f.Name()); err == nil {
+	} else if _, err := c.fs.Lstat(f.Name()); err == nil {
 		action = "Updated"
 	}
 
 	if action == "Created" || action == "Updated" {
 		if !c.dryRun {
-			if err := os.MkdirAll(filepath.Dir(f.Name()), 0o755); err != nil {
-				return fmt.Errorf("failed to create directory %q: %w", filepath.Dir(f.Name()), err)
-			}
-
-			if err := os.WriteFile(f.Name(), f.Bytes(), f.Mode()); err != nil {
-				return fmt.Errorf("failed to write file %q: %w", f.Name(), err)
+			if err := codegen.WriteFile(c.fs, f.Name(), f.Bytes(), f.Mode(), f.SymlinkTarget()); err != nil {
+				return err
 			}
 		}
 	}
 
+	c.actions[action]++
+	c.report.addFile(tpl, f, action)
+
 	msg := fmt.Sprintf("  -> %s %s", action, f.Name())
+	if target := f.SymlinkTarget(); target != "" {
+		msg += " -> " + target
+	}
 	if c.dryRun {
 		msg += " (dry-run)"
 	}
@@ -237,27 +404,60 @@ func (c *Command) writeFile(f *codegen.File) error {
 		// For skipped files, we only log at debug level
 		c.log.Debug(msg, "reason", f.SkippedReason)
 	}
+
+	for _, w := range f.Warnings {
+		c.log.Warnf("     %s", w)
+	}
+
+	if !f.Skipped && !f.Deleted {
+		return c.preserveOrphanedBlocks(f)
+	}
 	return nil
 }
 
+// preserveOrphanedBlocks appends the contents of the orphaned blocks of
+// the provided file to its sidecar file, when the project's orphaned
+// blocks policy is to preserve them.
+func (c *Command) preserveOrphanedBlocks(f *codegen.File) error {
+	if c.dryRun || c.manifest.OrphanedBlocks != configuration.OrphanedBlocksPreserve {
+		return nil
+	}
+
+	sidecar, contents, err := f.OrphanedBlocksSidecarFile(c.fs)
+	if err != nil || sidecar == "" {
+		return err
+	}
+	return codegen.WriteFile(c.fs, sidecar, contents, 0o644, "")
+}
+
 // writeFiles writes the files to disk
 func (c *Command) writeFiles(st *codegen.Stencil, tpls []*codegen.Template) error {
 	c.log.Infof("Writing template(s) to disk")
-	for _, tpl := range tpls {
-		for i := range tpl.Files {
-			if err := c.writeFile(tpl.Files[i]); err != nil {
-				return err
+
+	// Remove deleted files first, so that removing a directory doesn't
+	// remove the files written to it
+	for _, deleted := range []bool{true, false} {
+		for _, tpl := range tpls {
+			for _, f := range tpl.Files {
+				if f.Deleted != deleted {
+					continue
+				}
+				if err := c.writeFile(tpl, f); err != nil {
+					return err
+				}
 			}
 		}
 	}
 
-	// Don't generate a lockfile in dry-run mode
+	l := st.GenerateLockfile(tpls)
+	c.report.setLockfile(c.lock, l)
+
+	// Don't write the lockfile in dry-run mode
 	if c.dryRun {
 		return nil
 	}
 
-	l := st.GenerateLockfile(tpls)
-	f, err := os.Create(stencil.LockfileName)
+	f, err := c.fs.Create(stencil.LockfileName)
 	if err != nil {
 		return fmt.Errorf("failed to create lockfile: %w", err)
 	}
@@ -0,0 +1,490 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements the "stencil test" command, which
+// runs the test cases of a module.
+
+package stencil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"go.rgst.io/stencil/internal/codegen"
+	"go.rgst.io/stencil/pkg/configuration"
+	"go.rgst.io/stencil/pkg/slogext"
+	"gopkg.in/yaml.v3"
+)
+
+// This block contains the layout of module test cases.
+const (
+	// ModuleTestsDir is the directory, relative to the root of a module,
+	// that contains the test cases of the module. Every directory inside
+	// of it is a test case.
+	ModuleTestsDir = "tests"
+
+	// testCaseManifest is the project manifest of a test case
+	testCaseManifest = "stencil.yaml"
+
+	// testCaseExpectedDir is the directory, relative to a test case,
+	// containing the files that the test case is expected to render.
+	testCaseExpectedDir = "expected"
+)
+
+// TestModuleOpts contains options for TestModule
+type TestModuleOpts struct {
+	// Dir is the root of the module to test
+	Dir string
+
+	// Cases is a list of test case names to run, when empty all test
+	// cases are ran.
+	Cases []string
+
+	// Update replaces the expected files of every test case with the
+	// files that were rendered, instead of comparing them.
+	Update bool
+}
+
+// testCaseResult is the result of running a test case
+type testCaseResult struct {
+	// name is the name of the test case
+	name string
+
+	// diffs are the differences between the expected and rendered files
+	diffs []string
+}
+
+// testFile is a file rendered by, or expected from, a test case
+type testFile struct {
+	// contents are the contents of a regular file
+	contents string
+
+	// symlink is the target of the file, if it's a symlink
+	symlink string
+}
+
+// TestModule runs the test cases of a module (see ModuleTestsDir) and
+// writes the results to w. A test case is a directory containing a
+// stencil.yaml, which is used as the manifest of a project that the
+// module is rendered in, and an expected directory containing the files
+// that should be rendered. Test cases are rendered against the local
+// module and its resolved dependencies. An error is returned if any test
+// case failed.
+func TestModule(ctx context.Context, log slogext.Logger, w io.Writer, opts *TestModuleOpts) error {
+	root, err := filepath.Abs(opts.Dir)
+	if err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(filepath.Join(root, "manifest.yaml"))
+	if err != nil {
+		return fmt.Errorf("failed to read module manifest, is this a module?: %w", err)
+	}
+
+	var mf configuration.TemplateRepositoryManifest
+	if err := yaml.Unmarshal(b, &mf); err != nil {
+		return fmt.Errorf("failed to parse module manifest: %w", err)
+	}
+
+	cases, err := findTestCases(root, opts.Cases)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, name := range cases {
+		res, err := runTestCase(ctx, log, mf.Name, root, name, opts.Update)
+		if err != nil {
+			res = &testCaseResult{name: name, diffs: []string{err.Error()}}
+		}
+
+		if len(res.diffs) == 0 {
+			fmt.Fprintf(w, "PASS %s\n", name)
+			continue
+		}
+
+		failed++
+		fmt.Fprintf(w, "FAIL %s\n", name)
+		for _, d := range res.diffs {
+			fmt.Fprintf(w, "    %s\n", strings.ReplaceAll(strings.TrimRight(d, "\n"), "\n", "\n    "))
+		}
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("%d of %d test case(s) failed", failed, len(cases))
+	}
+
+	fmt.Fprintf(w, "ok, %d test case(s) passed\n", len(cases))
+	return nil
+}
+
+// findTestCases returns the names of the test cases of the module at
+// root. If names is not empty, only those test cases are returned.
+func findTestCases(root string, names []string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(root, ModuleTestsDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read test cases: %w", err)
+	}
+
+	cases := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(root, ModuleTestsDir, e.Name(), testCaseManifest)); err != nil {
+			continue
+		}
+		if len(names) != 0 && !slices.Contains(names, e.Name()) {
+			continue
+		}
+		cases = append(cases, e.Name())
+	}
+
+	for _, name := range names {
+		if !slices.Contains(cases, name) {
+			return nil, fmt.Errorf("unknown test case %q", name)
+		}
+	}
+
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("no test cases found in %s", ModuleTestsDir)
+	}
+
+	return cases, nil
+}
+
+// runTestCase renders the test case with the provided name and compares
+// the rendered files against the expected files. The test case is
+// rendered in an empty in-memory project, so that files of the module
+// are not used as existing files of the project.
+func runTestCase(ctx context.Context, log slogext.Logger, module, root, name string,
+	update bool) (*testCaseResult, error) {
+	caseDir := filepath.Join(root, ModuleTestsDir, name)
+	manifest, err := configuration.NewManifest(filepath.Join(caseDir, testCaseManifest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", testCaseManifest, err)
+	}
+
+	// Always render the local version of the module being tested.
+	if manifest.Replacements == nil {
+		manifest.Replacements = make(map[string]string)
+	}
+	manifest.Replacements[module] = "file://" + root
+	if !slices.ContainsFunc(manifest.Modules, func(m *configuration.TemplateRepository) bool { return m.Name == module }) {
+		manifest.Modules = append(manifest.Modules, &configuration.TemplateRepository{Name: module})
+	}
+
+	files, err := renderProject(ctx, log, manifest, memfs.New())
+	if err != nil {
+		return nil, err
+	}
+
+	expectedDir := filepath.Join(caseDir, testCaseExpectedDir)
+	if update {
+		return &testCaseResult{name: name}, writeExpectedFiles(expectedDir, files)
+	}
+
+	expected, err := readExpectedFiles(expectedDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &testCaseResult{name: name, diffs: diffFiles(expected, files)}, nil
+}
+
+// renderProject renders the project described by manifest in fs,
+// returning the rendered files by their path.
+func renderProject(ctx context.Context, log slogext.Logger, manifest *configuration.Manifest,
+	fs billy.Filesystem) (map[string]testFile, error) {
+	c := &Command{manifest: manifest, log: log, fs: fs, dryRun: true}
+	mods, err := c.resolveModules(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	st := codegen.NewStencil(manifest, mods, log)
+	defer st.Close()
+	st.SetProjectFS(fs)
+
+	if err := st.RegisterExtensions(ctx); err != nil {
+		return nil, err
+	}
+
+	tpls, err := st.Render(ctx, log)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]testFile)
+	for _, tpl := range tpls {
+		for _, f := range tpl.Files {
+			if f.Deleted || f.Skipped {
+				continue
+			}
+			files[filepath.ToSlash(f.Name())] = testFile{contents: f.String(), symlink: f.SymlinkTarget()}
+		}
+	}
+	return files, nil
+}
+
+// readExpectedFiles returns all files in dir by their path relative to
+// dir. Symlinks are read as symlinks, not as the file they point to.
+func readExpectedFiles(dir string) (map[string]testFile, error) {
+	files := make(map[string]testFile)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			files[filepath.ToSlash(rel)] = testFile{symlink: target}
+			return nil
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = testFile{contents: string(b)}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		// No expected files, so nothing should be rendered.
+		return files, nil
+	}
+	return files, err
+}
+
+// writeExpectedFiles replaces the contents of dir with the provided
+// files.
+func writeExpectedFiles(dir string, files map[string]testFile) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	for path, f := range files {
+		fpath := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(fpath), 0o755); err != nil {
+			return err
+		}
+
+		if f.symlink != "" {
+			if err := os.Symlink(f.symlink, fpath); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.WriteFile(fpath, []byte(f.contents), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffFiles returns a human readable description of every difference
+// between the expected and rendered files, sorted by path.
+func diffFiles(expected, rendered map[string]testFile) []string {
+	paths := make([]string, 0, len(expected)+len(rendered))
+	for path := range expected {
+		paths = append(paths, path)
+	}
+	for path := range rendered {
+		if _, ok := expected[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var diffs []string
+	for _, path := range paths {
+		want, hasWant := expected[path]
+		got, hasGot := rendered[path]
+		switch {
+		case !hasGot:
+			diffs = append(diffs, fmt.Sprintf("%s: expected file was not rendered", path))
+		case !hasWant:
+			diffs = append(diffs, fmt.Sprintf("%s: unexpected file was rendered", path))
+		case want.symlink != got.symlink:
+			diffs = append(diffs, fmt.Sprintf("%s: expected %s, rendered %s", path, want.describe(), got.describe()))
+		case want.contents != got.contents:
+			diffs = append(diffs, fmt.Sprintf("%s: contents differ (-expected +rendered):\n%s", path,
+				diffLines(want.contents, got.contents)))
+		}
+	}
+	return diffs
+}
+
+// describe returns a short description of the kind of file f is
+func (f testFile) describe() string {
+	if f.symlink != "" {
+		return fmt.Sprintf("a symlink to %q", f.symlink)
+	}
+	return "a regular file"
+}
+
+// maxDiffCells is the largest LCS table, in cells, that diffLines will
+// allocate. Files that differ by more than this are reported as having
+// been entirely replaced instead.
+const maxDiffCells = 1 << 20
+
+// diffContext is the number of unchanged lines diffLines shows around
+// changed lines, like a unified diff
+const diffContext = 3
+
+// diffLine is a line of a diff, op is '-' for lines only in the first
+// file, '+' for lines only in the second one, and ' ' for lines in both
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// diffLines returns a line based diff of a and b, where lines only in a
+// are prefixed with "-", lines only in b with "+", and lines in both
+// with a space. Like a unified diff, only the changed lines and up to
+// diffContext lines around them are included, in hunks that start with
+// the lines of a and b they cover.
+func diffLines(a, b string) string {
+	al, bl := strings.Split(a, "\n"), strings.Split(b, "\n")
+
+	var lines []diffLine
+
+	// Lines shared at the start and end of both files don't need to be
+	// part of the LCS table.
+	prefix := 0
+	for prefix < len(al) && prefix < len(bl) && al[prefix] == bl[prefix] {
+		lines = append(lines, diffLine{' ', al[prefix]})
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(al)-prefix && suffix < len(bl)-prefix &&
+		al[len(al)-1-suffix] == bl[len(bl)-1-suffix] {
+		suffix++
+	}
+	common := al[len(al)-suffix:]
+	al, bl = al[prefix:len(al)-suffix], bl[prefix:len(bl)-suffix]
+
+	if (len(al)+1)*(len(bl)+1) > maxDiffCells {
+		for _, l := range al {
+			lines = append(lines, diffLine{'-', l})
+		}
+		for _, l := range bl {
+			lines = append(lines, diffLine{'+', l})
+		}
+	} else {
+		lines = append(lines, diffLCS(al, bl)...)
+	}
+
+	for _, l := range common {
+		lines = append(lines, diffLine{' ', l})
+	}
+	return formatHunks(lines)
+}
+
+// diffLCS returns a diff of al and bl, see diffLines, using their
+// longest common subsequence.
+func diffLCS(al, bl []string) []diffLine {
+	// lcs[i][j] is the length of the longest common subsequence of
+	// al[i:] and bl[j:]
+	lcs := make([][]int, len(al)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bl)+1)
+	}
+	for i := len(al) - 1; i >= 0; i-- {
+		for j := len(bl) - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(al) || j < len(bl) {
+		switch {
+		case i < len(al) && j < len(bl) && al[i] == bl[j]:
+			lines = append(lines, diffLine{' ', al[i]})
+			i++
+			j++
+		case j < len(bl) && (i == len(al) || lcs[i][j+1] >= lcs[i+1][j]):
+			lines = append(lines, diffLine{'+', bl[j]})
+			j++
+		default:
+			lines = append(lines, diffLine{'-', al[i]})
+			i++
+		}
+	}
+	return lines
+}
+
+// formatHunks formats the changed lines of a diff, and up to
+// diffContext lines around them, as the hunks of a unified diff. Changes
+// whose context would overlap are part of the same hunk.
+func formatHunks(lines []diffLine) string {
+	// apos[i] and bpos[i] are the lines, starting at 1, of the files that
+	// lines[i] is at
+	apos, bpos := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	apos[0], bpos[0] = 1, 1
+	for i, l := range lines {
+		apos[i+1], bpos[i+1] = apos[i], bpos[i]
+		if l.op != '+' {
+			apos[i+1]++
+		}
+		if l.op != '-' {
+			bpos[i+1]++
+		}
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(lines); i++ {
+		if lines[i].op == ' ' {
+			continue
+		}
+
+		start, last := max(i-diffContext, 0), i
+		for j := i; j < len(lines) && j <= last+2*diffContext+1; j++ {
+			if lines[j].op != ' ' {
+				last = j
+			}
+		}
+		end := min(last+diffContext+1, len(lines))
+
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", apos[start], apos[end]-apos[start], bpos[start], bpos[end]-bpos[start])
+		for _, l := range lines[start:end] {
+			sb.WriteString(string(l.op) + " " + l.text + "\n")
+		}
+		i = end - 1
+	}
+	return sb.String()
+}
@@ -0,0 +1,157 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.rgst.io/stencil/pkg/slogext"
+	"gotest.tools/v3/assert"
+)
+
+func TestModuleTestCasePasses(t *testing.T) {
+	var buf bytes.Buffer
+	err := TestModule(context.Background(), slogext.NewTestLogger(t), &buf, &TestModuleOpts{
+		Dir:   "testdata/module",
+		Cases: []string{"basic"},
+	})
+	assert.NilError(t, err, buf.String())
+	assert.Equal(t, buf.String(), "PASS basic\nok, 1 test case(s) passed\n")
+}
+
+func TestModuleTestCaseReportsDiffs(t *testing.T) {
+	var buf bytes.Buffer
+	err := TestModule(context.Background(), slogext.NewTestLogger(t), &buf, &TestModuleOpts{
+		Dir:   "testdata/module",
+		Cases: []string{"mismatch"},
+	})
+	assert.ErrorContains(t, err, "1 of 1 test case(s) failed")
+	assert.Assert(t, bytes.Contains(buf.Bytes(), []byte("FAIL mismatch\n")), buf.String())
+	assert.Assert(t, bytes.Contains(buf.Bytes(), []byte("extra.txt: expected file was not rendered")), buf.String())
+	assert.Assert(t, bytes.Contains(buf.Bytes(), []byte("- Hello, mismatch!\n")), buf.String())
+	assert.Assert(t, bytes.Contains(buf.Bytes(), []byte("+ Goodbye, mismatch!\n")), buf.String())
+}
+
+func TestModuleTestCaseCanBeUpdated(t *testing.T) {
+	// Copy the module so that the expected files can be updated
+	dir := t.TempDir()
+	for _, f := range []string{"manifest.yaml", "templates/hello.txt.tpl", "tests/mismatch/stencil.yaml"} {
+		b, err := os.ReadFile(filepath.Join("testdata/module", f))
+		assert.NilError(t, err)
+		assert.NilError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, f)), 0o755))
+		assert.NilError(t, os.WriteFile(filepath.Join(dir, f), b, 0o644))
+	}
+
+	log := slogext.NewTestLogger(t)
+	var buf bytes.Buffer
+	err := TestModule(context.Background(), log, &buf, &TestModuleOpts{Dir: dir, Update: true})
+	assert.NilError(t, err, buf.String())
+
+	b, err := os.ReadFile(filepath.Join(dir, "tests/mismatch/expected/hello.txt"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), "Goodbye, mismatch!\n")
+
+	err = TestModule(context.Background(), log, &buf, &TestModuleOpts{Dir: dir})
+	assert.NilError(t, err, buf.String())
+}
+
+func TestModuleTestCaseMustExist(t *testing.T) {
+	err := TestModule(context.Background(), slogext.NewTestLogger(t), &bytes.Buffer{}, &TestModuleOpts{
+		Dir:   "testdata/module",
+		Cases: []string{"unknown"},
+	})
+	assert.ErrorContains(t, err, `unknown test case "unknown"`)
+}
+
+func TestDiffFilesComparesSymlinks(t *testing.T) {
+	diffs := diffFiles(map[string]testFile{
+		"link":  {symlink: "target"},
+		"file":  {contents: "target"},
+		"same":  {symlink: "target"},
+		"other": {symlink: "target"},
+	}, map[string]testFile{
+		"link":  {contents: "target"},
+		"file":  {symlink: "target"},
+		"same":  {symlink: "target"},
+		"other": {symlink: "elsewhere"},
+	})
+	assert.DeepEqual(t, diffs, []string{
+		`file: expected a regular file, rendered a symlink to "target"`,
+		`link: expected a symlink to "target", rendered a regular file`,
+		`other: expected a symlink to "target", rendered a symlink to "elsewhere"`,
+	})
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "changed line",
+			a:    "a\nb\nc",
+			b:    "a\nB\nc",
+			want: "@@ -1,3 +1,3 @@\n  a\n+ B\n- b\n  c\n",
+		},
+		{
+			name: "added and removed lines",
+			a:    "a\nb\nc\nd",
+			b:    "b\nc\ne\nd",
+			want: "@@ -1,4 +1,4 @@\n- a\n  b\n  c\n+ e\n  d\n",
+		},
+		{
+			name: "identical",
+			a:    "a\nb",
+			b:    "a\nb",
+			want: "",
+		},
+		{
+			name: "context is trimmed",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16",
+			b:    "1\n2\n3\n4\nfive\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16",
+			want: "@@ -2,7 +2,7 @@\n  2\n  3\n  4\n+ five\n- 5\n  6\n  7\n  8\n",
+		},
+		{
+			name: "changes far apart are separate hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12",
+			b:    "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve",
+			want: "@@ -1,4 +1,4 @@\n+ one\n- 1\n  2\n  3\n  4\n@@ -9,4 +9,4 @@\n  9\n  10\n  11\n+ twelve\n- 12\n",
+		},
+		{
+			name: "changes with overlapping context are one hunk",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8",
+			b:    "one\n2\n3\n4\n5\n6\n7\neight",
+			want: "@@ -1,8 +1,8 @@\n+ one\n- 1\n  2\n  3\n  4\n  5\n  6\n  7\n+ eight\n- 8\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, diffLines(tt.a, tt.b), tt.want)
+		})
+	}
+}
+
+func TestDiffLinesFallsBackForLargeFiles(t *testing.T) {
+	a := strings.Repeat("a\n", 2000) + "x"
+	b := "y\n" + strings.Repeat("b\n", 2000)
+	diff := diffLines(a, b)
+	assert.Equal(t, diff, "@@ -1,2001 +1,2002 @@\n"+strings.Repeat("- a\n", 2000)+"- x\n+ y\n"+strings.Repeat("+ b\n", 2000)+"+ \n")
+}
@@ -0,0 +1,3 @@
+## <<Stencil::Block(a)>>
+{{ file.Block "a" }}
+## <<Stencil::EndBlock>>
@@ -0,0 +1 @@
+{{ notAFunction }}
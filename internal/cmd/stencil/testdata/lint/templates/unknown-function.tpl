@@ -0,0 +1,5 @@
+{{ stencil.Arg "ok" }}
+{{- if true }}
+{{ file.DoesNotExist }}
+{{- end }}
+{{ extensions.Call "a.b" }}
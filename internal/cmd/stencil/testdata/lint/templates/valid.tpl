@@ -0,0 +1,4 @@
+## <<Stencil::Block(a)>>
+{{ file.Block "a" }}
+## <</Stencil::Block>>
+{{ stencil.ApplyTemplate "x" }}
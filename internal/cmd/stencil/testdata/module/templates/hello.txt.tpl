@@ -0,0 +1 @@
+{{ stencil.Arg "greeting" }}, {{ .Config.Name }}!
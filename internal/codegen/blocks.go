@@ -7,11 +7,17 @@ package codegen
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
 
+	"github.com/go-git/go-billy/v5"
 	"github.com/pkg/errors"
+	"go.rgst.io/stencil/pkg/configuration"
 )
 
 // endStatement is a constant for the end of a statement
@@ -21,25 +27,256 @@ const endStatement = "EndBlock"
 // For unit testing of this regex and explanation, see https://regex101.com/r/nFgOz0/1
 var blockPattern = regexp.MustCompile(`^\s*(///|###|<!---)\s*([a-zA-Z ]+)\(([a-zA-Z0-9 ]+)\)`)
 
+// v2BlockPrefixes are the comment prefixes that v2 block markers can be
+// written with, covering:
+//   - // (Go, JS, C), /* (CSS, C) and {{/* or {{- /* (Go templates)
+//   - -- (SQL, Lua) and <!-- (HTML, Markdown)
+//   - # (Python, Ruby, shell, YAML), ; (Lisp, INI) and % (Erlang, LaTeX)
+//
+// Anything after the marker, e.g. the end of a comment, is ignored.
+const v2BlockPrefixes = `//|--|<!--|#+|;+|%+|/\*|\{\{-?\s?/\*`
+
+// v2BlockMarker is the regex, without the comment prefix, of a v2 block
+// marker
+const v2BlockMarker = `\s{0,1}<<(/?)Stencil::([a-zA-Z ]+)(\([a-zA-Z0-9 ,=_-]+\))?>>`
+
 // v2BlockPattern is the new regex for parsing blocks
-// For unit testing of this regex and explanation, see https://regex101.com/r/EHkH5O/1
-var v2BlockPattern = regexp.MustCompile(`^\s*(//|##|--|<!--)\s{0,1}<<(/?)Stencil::([a-zA-Z ]+)(\([a-zA-Z0-9 ]+\))?>>`)
+var v2BlockPattern = regexp.MustCompile(`^\s*(` + v2BlockPrefixes + `)` + v2BlockMarker)
+
+// blockPatterns returns the regexes used for parsing v2 blocks in the file
+// at filePath. The built-in comment styles are always supported, the
+// styles in custom that apply to filePath (by extension or name) are
+// supported in addition to them.
+func blockPatterns(filePath string, custom map[string][]*configuration.BlockCommentStyle) ([]*regexp.Regexp, error) {
+	patterns := []*regexp.Regexp{v2BlockPattern}
+
+	styles := custom[filepath.Base(filePath)]
+	if ext := filepath.Ext(filePath); ext != "" {
+		styles = append(slices.Clip(styles), custom[ext]...)
+	}
+
+	for _, style := range styles {
+		if style == nil || style.Prefix == "" {
+			return nil, fmt.Errorf("invalid block comment style for %q, prefix must be set", filePath)
+		}
+
+		expr := `^\s*(` + regexp.QuoteMeta(style.Prefix) + `)` + v2BlockMarker
+		if style.Suffix != "" {
+			expr += `\s*` + regexp.QuoteMeta(style.Suffix) + `\s*$`
+		}
+		patterns = append(patterns, regexp.MustCompile(expr))
+	}
+
+	return patterns, nil
+}
+
+// This block contains the values of the "default" argument of a block,
+// which controls what file.Block returns when a block already exists.
+const (
+	// blockDefaultKeep keeps the existing contents of the block, this is
+	// the default.
+	blockDefaultKeep = "keep"
+
+	// blockDefaultReset replaces the existing contents of the block with
+	// the template's default when the version of the module that renders
+	// the file has changed since the last run.
+	blockDefaultReset = "reset"
+
+	// blockDefaultAppend appends the template's default to the existing
+	// contents of the block, unless they already contain it.
+	blockDefaultAppend = "append"
+)
+
+// blockArgs are the arguments that a block supports and their valid
+// values
+var blockArgs = map[string][]string{
+	"default": {blockDefaultKeep, blockDefaultReset, blockDefaultAppend},
+}
+
+// block is a block read from an existing file
+type block struct {
+	// name is the name of the block
+	name string
+
+	// contents are the lines inside of the block, including the markers
+	// of any blocks nested inside of it, with their original line
+	// endings. The line ending of the last line is not included.
+	contents []byte
+
+	// eol is the line ending of the last line added to contents, it's
+	// written before the next line is added.
+	eol string
+
+	// lines is the number of lines in contents
+	lines int
+
+	// args are the arguments of the block, e.g. default=keep
+	args map[string]string
+
+	// parent is the block that this block is nested in, if any
+	parent *block
+
+	// startLine is the line, starting at 1, of the first marker that
+	// opened the block.
+	startLine int
+
+	// endLine is the line, starting at 1, of the last marker that closed
+	// the block.
+	endLine int
+}
+
+// Contents returns the contents of the block
+func (b *block) Contents() string {
+	return string(b.contents)
+}
+
+// addLine adds a line, without its line ending, to the contents of the
+// block. eol is the line ending of the line.
+func (b *block) addLine(line, eol string) {
+	if b.lines != 0 {
+		b.contents = append(b.contents, b.eol...)
+	}
+	b.contents = append(b.contents, line...)
+	b.eol = eol
+	b.lines++
+}
+
+// Default returns the value of the "default" argument of the block
+func (b *block) Default() string {
+	if d, ok := b.args["default"]; ok {
+		return d
+	}
+	return blockDefaultKeep
+}
+
+// parseBlockArgs parses the arguments of a block marker, e.g.
+// "name, default=keep", into the name of the block and its arguments.
+func parseBlockArgs(s string) (string, map[string]string, error) {
+	parts := strings.Split(s, ",")
+	name := strings.TrimSpace(parts[0])
+	if name == "" || strings.ContainsAny(name, " =") {
+		return "", nil, fmt.Errorf("invalid block name %q", name)
+	}
+
+	args := make(map[string]string)
+	for _, part := range parts[1:] {
+		k, v, ok := strings.Cut(part, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" || v == "" {
+			return "", nil, fmt.Errorf("invalid block argument %q, expected key=value", strings.TrimSpace(part))
+		}
+
+		valid, ok := blockArgs[k]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown block argument %q", k)
+		}
+		if !slices.Contains(valid, v) {
+			return "", nil, fmt.Errorf("invalid value %q for block argument %q, expected one of: %s",
+				v, k, strings.Join(valid, ", "))
+		}
+		if _, ok := args[k]; ok {
+			return "", nil, fmt.Errorf("duplicate block argument %q", k)
+		}
+		args[k] = v
+	}
 
-// parseBlocks reads the blocks from an existing file
-func parseBlocks(filePath string) (map[string]string, error) {
-	blocks := make(map[string]string)
-	f, err := os.Open(filePath)
+	return name, args, nil
+}
+
+// BlockInfo describes a block in an existing file
+type BlockInfo struct {
+	// Name is the name of the block
+	Name string
+
+	// Parent is the name of the block that this block is nested in, if
+	// any.
+	Parent string
+
+	// StartLine is the line, starting at 1, of the marker that opens the
+	// block.
+	StartLine int
+
+	// EndLine is the line, starting at 1, of the marker that closes the
+	// block.
+	EndLine int
+}
+
+// ReadBlockInfo returns information about the blocks in the file at
+// filePath in fs, sorted by the line they start at. Only the built-in
+// comment styles are supported.
+func ReadBlockInfo(fs billy.Filesystem, filePath string) ([]BlockInfo, error) {
+	blocks, err := parseBlocks(fs, filePath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]BlockInfo, 0, len(blocks))
+	for _, b := range blocks {
+		info := BlockInfo{Name: b.name, StartLine: b.startLine, EndLine: b.endLine}
+		if b.parent != nil {
+			info.Parent = b.parent.name
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].StartLine < infos[j].StartLine
+	})
+	return infos, nil
+}
+
+// parseBlocks reads the blocks from an existing file in fs, see
+// blockPatterns for how custom comment styles are used.
+func parseBlocks(fs billy.Filesystem, filePath string,
+	custom map[string][]*configuration.BlockCommentStyle) (map[string]*block, error) {
+	// Symlinks don't contain blocks, the files they point to do, and
+	// directories can't contain blocks at all.
+	if fi, err := fs.Lstat(filePath); err == nil && (fi.Mode()&os.ModeSymlink != 0 || fi.IsDir()) {
+		return make(map[string]*block), nil
+	}
+
+	f, err := fs.Open(filePath)
 	if errors.Is(err, os.ErrNotExist) {
-		return make(map[string]string), nil
+		return make(map[string]*block), nil
 	} else if err != nil {
 		return nil, errors.Wrapf(err, "failed to read blocks from file %q", filePath)
 	}
 	defer f.Close()
 
-	var curBlockName string
-	scanner := bufio.NewScanner(f)
-	for i := 0; scanner.Scan(); i++ {
-		line := scanner.Text()
+	return parseBlocksFromReader(f, filePath, custom)
+}
+
+// parseBlocksFromReader reads the blocks from r, filePath is used to
+// determine the custom comment styles that apply and in errors.
+//
+// Blocks may be nested, in which case the contents of the outer block
+// include the markers and contents of the inner block. Lines may be of
+// any length and keep their original line endings.
+//
+//nolint:funlen,gocyclo // Why: this is a state machine over the lines of r.
+func parseBlocksFromReader(r io.Reader, filePath string,
+	custom map[string][]*configuration.BlockCommentStyle) (map[string]*block, error) {
+	patterns, err := blockPatterns(filePath, custom)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := make(map[string]*block)
+
+	// open is the stack of blocks that the current line is inside of,
+	// the innermost block is last.
+	var open []*block
+	br := bufio.NewReader(r)
+	for lineNum := 1; ; lineNum++ {
+		raw, readErr := br.ReadString('\n')
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return nil, errors.Wrapf(readErr, "failed to read blocks from file %q", filePath)
+		}
+		if raw == "" && readErr != nil {
+			break
+		}
+		line, eol := splitEOL(raw)
+		at := fmt.Sprintf("%s:%d", filePath, lineNum)
+
 		matches := blockPattern.FindStringSubmatch(line)
 		if len(matches) == 0 {
 			// 0: full match
@@ -47,28 +284,35 @@ func parseBlocks(filePath string) (map[string]string, error) {
 			// 2: / if end of block
 			// 3: block name
 			// 4: block args, if present
-			v2Matches := v2BlockPattern.FindStringSubmatch(line)
+			var v2Matches []string
+			for _, p := range patterns {
+				if v2Matches = p.FindStringSubmatch(line); v2Matches != nil {
+					break
+				}
+			}
 			if len(v2Matches) == 5 {
 				cmd := v2Matches[3]
 				if v2Matches[2] == "/" {
 					if cmd == endStatement {
-						return nil, fmt.Errorf("line %d: Stencil::EndBlock with a <</, should use <</Stencil::Block>> instead", i+1)
+						return nil, fmt.Errorf("Stencil::EndBlock with a <</, should use <</Stencil::Block>> instead, at %s", at)
 					}
 
 					// If there is a /, it's a closing tag and we should
 					// translate it to a closing block command
 					cmd = endStatement
 					if v2Matches[4] != "" {
-						return nil, fmt.Errorf("line %d: expected no arguments to <</Stencil::Block>>", i+1)
+						return nil, fmt.Errorf("expected no arguments to <</Stencil::Block>>, at %s", at)
 					}
 
-					v2Matches[4] = fmt.Sprintf("(%s)", curBlockName)
+					if len(open) != 0 {
+						v2Matches[4] = fmt.Sprintf("(%s)", open[len(open)-1].name)
+					}
 				} else if cmd == endStatement {
 					// If it's not a closing tag, but the command is EndBlock,
 					// we should error. This is because we don't want to
 					// allow users to use the old EndBlock command
 					// without a closing tag
-					return nil, errors.Errorf("line %d: <<Stencil::EndBlock>> should be <</Stencil::Block>>", i+1)
+					return nil, fmt.Errorf("<<Stencil::EndBlock>> should be <</Stencil::Block>>, at %s", at)
 				}
 
 				// fake the old matches format so we can reuse the same code
@@ -80,63 +324,85 @@ func parseBlocks(filePath string) (map[string]string, error) {
 				}
 			}
 		}
-		isCommand := false
 
 		// 1: Comment (###|///)
 		// 2: Command
 		// 3: Argument to the command
 		if len(matches) == 4 {
-			cmd := matches[2]
-			isCommand = true
-
-			switch cmd {
+			switch matches[2] {
 			case "Block":
-				blockName := matches[3]
-				if curBlockName != "" {
-					return nil, fmt.Errorf("invalid Block when already inside of a block, at %s:%d", filePath, i+1)
+				blockName, args, err := parseBlockArgs(matches[3])
+				if err != nil {
+					return nil, fmt.Errorf("%w, at %s", err, at)
 				}
-				curBlockName = blockName
-			case endStatement:
-				blockName := matches[3]
 
-				if curBlockName == "" {
-					return nil, fmt.Errorf("invalid EndBlock when not inside of a block, at %s:%d", filePath, i+1)
+				for _, b := range open {
+					if b.name == blockName {
+						return nil, fmt.Errorf("invalid Block, block %q is already open, at %s", blockName, at)
+					}
+				}
+
+				// The marker is part of the contents of the blocks it's
+				// nested in.
+				addLine(open, line, eol)
+
+				b, ok := blocks[blockName]
+				if !ok {
+					b = &block{name: blockName, args: args, startLine: lineNum}
+					if len(open) != 0 {
+						b.parent = open[len(open)-1]
+					}
+					blocks[blockName] = b
+				}
+				open = append(open, b)
+				continue
+			case endStatement:
+				if len(open) == 0 {
+					return nil, fmt.Errorf("invalid EndBlock when not inside of a block, at %s", at)
 				}
 
-				if blockName != curBlockName {
+				cur := open[len(open)-1]
+				if blockName := matches[3]; blockName != cur.name {
 					return nil, fmt.Errorf(
-						"invalid EndBlock, found EndBlock with name %q while inside of block with name %q, at %s:%d",
-						blockName, curBlockName, filePath, i+1,
+						"invalid EndBlock, found EndBlock with name %q while inside of block with name %q, at %s",
+						blockName, cur.name, at,
 					)
 				}
 
-				curBlockName = ""
-			default:
-				isCommand = false
+				cur.endLine = lineNum
+				open = open[:len(open)-1]
+				addLine(open, line, eol)
+				continue
 			}
 		}
 
-		// we skip lines that had a recognized command in them, or that
-		// aren't in a block
-		if isCommand || curBlockName == "" {
-			continue
-		}
-
-		// add the line we processed to the current block we're in
-		// and account for having an existing curVal or not. If we
-		// don't then we assign curVal to start with the line we
-		// just found.
-		curVal, ok := blocks[curBlockName]
-		if ok {
-			blocks[curBlockName] = curVal + "\n" + line
-		} else {
-			blocks[curBlockName] = line
-		}
+		addLine(open, line, eol)
 	}
 
-	if curBlockName != "" {
-		return nil, fmt.Errorf("found dangling Block (%s) in %s", curBlockName, filePath)
+	if len(open) != 0 {
+		b := open[len(open)-1]
+		return nil, fmt.Errorf("found dangling Block (%s), started at %s:%d", b.name, filePath, b.startLine)
 	}
 
 	return blocks, nil
 }
+
+// splitEOL splits a line read from a file into its contents and its line
+// ending, which is either "\r\n", "\n" or empty for the last line of a
+// file that doesn't end with a newline.
+func splitEOL(raw string) (line, eol string) {
+	switch {
+	case strings.HasSuffix(raw, "\r\n"):
+		return raw[:len(raw)-2], "\r\n"
+	case strings.HasSuffix(raw, "\n"):
+		return raw[:len(raw)-1], "\n"
+	}
+	return raw, ""
+}
+
+// addLine adds a line to the contents of all of the provided blocks
+func addLine(blocks []*block, line, eol string) {
+	for _, b := range blocks {
+		b.addLine(line, eol)
+	}
+}
@@ -5,53 +5,201 @@
 package codegen
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/go-git/go-billy/v5/osfs"
+	"go.rgst.io/stencil/pkg/configuration"
 	"gotest.tools/v3/assert"
 )
 
 func TestParseBlocks(t *testing.T) {
-	blocks, err := parseBlocks("testdata/blocks-test.txt")
+	blocks, err := parseBlocks(osfs.New("."), "testdata/blocks-test.txt", nil)
 	assert.NilError(t, err, "expected parseBlocks() not to fail")
-	assert.Equal(t, blocks["helloWorld"], "Hello, world!", "expected parseBlocks() to parse basic block")
-	assert.Equal(t, blocks["e2e"], "content", "expected parseBlocks() to parse e2e block")
+	assert.Equal(t, blocks["helloWorld"].Contents(), "Hello, world!", "expected parseBlocks() to parse basic block")
+	assert.Equal(t, blocks["e2e"].Contents(), "content", "expected parseBlocks() to parse e2e block")
 }
 
 func TestDanglingBlock(t *testing.T) {
-	_, err := parseBlocks("testdata/danglingblock-test.txt")
-	assert.Error(t, err, "found dangling Block (dangles) in testdata/danglingblock-test.txt", "expected parseBlocks() to fail")
+	_, err := parseBlocks(osfs.New("."), "testdata/danglingblock-test.txt", nil)
+	assert.Error(t, err, "found dangling Block (dangles), started at testdata/danglingblock-test.txt:5", "expected parseBlocks() to fail")
 }
 
 func TestDanglingEndBlock(t *testing.T) {
-	_, err := parseBlocks("testdata/danglingendblock-test.txt")
+	_, err := parseBlocks(osfs.New("."), "testdata/danglingendblock-test.txt", nil)
 	assert.Error(t, err,
 		"invalid EndBlock when not inside of a block, at testdata/danglingendblock-test.txt:8",
 		"expected parseBlocks() to fail")
 }
 
 func TestBlockInsideBlock(t *testing.T) {
-	_, err := parseBlocks("testdata/blockinsideblock-test.txt")
+	_, err := parseBlocks(osfs.New("."), "testdata/blockinsideblock-test.txt", nil)
 	assert.Error(t, err,
-		"invalid Block when already inside of a block, at testdata/blockinsideblock-test.txt:3",
+		"invalid EndBlock, found EndBlock with name \"helloWorld\" while inside of block with name \"boompls\", at testdata/blockinsideblock-test.txt:6", //nolint:lll
 		"expected parseBlocks() to fail")
 }
 
+func TestNestedBlocks(t *testing.T) {
+	blocks, err := parseBlocks(osfs.New("."), "testdata/nestedblocks-test.txt", nil)
+	assert.NilError(t, err, "expected parseBlocks() not to fail")
+	assert.DeepEqual(t, blockContents(blocks), map[string]string{
+		"outer": "before\n  // <<Stencil::Block(inner)>>\n  inside\n  // <</Stencil::Block>>\nafter",
+		"inner": "  inside",
+	})
+}
+
+func TestReopenedBlock(t *testing.T) {
+	_, err := parseBlocks(osfs.New("."), "testdata/reopenedblock-test.txt", nil)
+	assert.Error(t, err,
+		"invalid Block, block \"outer\" is already open, at testdata/reopenedblock-test.txt:2",
+		"expected parseBlocks() to fail")
+}
+
+func TestParseBlockArgs(t *testing.T) {
+	blocks, err := parseBlocks(osfs.New("."), "testdata/blockargs-test.txt", nil)
+	assert.NilError(t, err, "expected parseBlocks() not to fail")
+	assert.DeepEqual(t, blockContents(blocks), map[string]string{
+		"noArgs":  "a",
+		"keep":    "b",
+		"reset":   "c",
+		"append":  "d",
+		"spacing": "e",
+	})
+	assert.Equal(t, blocks["noArgs"].Default(), blockDefaultKeep)
+	assert.Equal(t, blocks["keep"].Default(), blockDefaultKeep)
+	assert.Equal(t, blocks["reset"].Default(), blockDefaultReset)
+	assert.Equal(t, blocks["append"].Default(), blockDefaultAppend)
+	assert.Equal(t, blocks["spacing"].Default(), blockDefaultReset)
+}
+
+func TestParseBlockArgsErrors(t *testing.T) {
+	tests := []struct {
+		args string
+		err  string
+	}{
+		{"name, default=sometimes", `invalid value "sometimes" for block argument "default", expected one of: keep, reset, append`},
+		{"name, unknown=keep", `unknown block argument "unknown"`},
+		{"name, default", `invalid block argument "default", expected key=value`},
+		{"name, default=keep, default=reset", `duplicate block argument "default"`},
+		{", default=keep", `invalid block name ""`},
+	}
+	for _, tt := range tests {
+		_, _, err := parseBlockArgs(tt.args)
+		assert.Error(t, err, tt.err, tt.args)
+	}
+}
+
 func TestWrongEndBlock(t *testing.T) {
-	_, err := parseBlocks("testdata/wrongendblock-test.txt")
+	_, err := parseBlocks(osfs.New("."), "testdata/wrongendblock-test.txt", nil)
 	assert.Error(t, err,
 		"invalid EndBlock, found EndBlock with name \"wrongend\" while inside of block with name \"helloWorld\", at testdata/wrongendblock-test.txt:3", //nolint:lll
 		"expected parseBlocks() to fail")
 }
 
 func TestParseV2Blocks(t *testing.T) {
-	blocks, err := parseBlocks("testdata/v2blocks-test.txt")
+	blocks, err := parseBlocks(osfs.New("."), "testdata/v2blocks-test.txt", nil)
 	assert.NilError(t, err, "expected parseBlocks() not to fail")
-	assert.Equal(t, blocks["helloWorld"], "Hello, world!", "expected parseBlocks() to parse basic block")
+	assert.Equal(t, blocks["helloWorld"].Contents(), "Hello, world!", "expected parseBlocks() to parse basic block")
 }
 
 func TestV2BlocksErrors(t *testing.T) {
-	_, err := parseBlocks("testdata/v2blocks-invalid.txt")
+	_, err := parseBlocks(osfs.New("."), "testdata/v2blocks-invalid.txt", nil)
 	if err == nil {
 		t.Fatal("expected parseBlocks() to fail")
 	}
 }
+
+func TestParseBlocksCommentStyles(t *testing.T) {
+	blocks, err := parseBlocks(osfs.New("."), "testdata/commentstyles-test.txt", nil)
+	assert.NilError(t, err, "expected parseBlocks() not to fail")
+	assert.DeepEqual(t, blockContents(blocks), map[string]string{
+		"hash":              "hash",
+		"semicolon":         "semicolon",
+		"percent":           "percent",
+		"cStyle":            "cStyle",
+		"goTemplate":        "goTemplate",
+		"goTemplateTrimmed": "goTemplateTrimmed",
+		"html":              "html",
+	})
+}
+
+func TestParseBlocksCustomCommentStyles(t *testing.T) {
+	styles := map[string][]*configuration.BlockCommentStyle{
+		".bat":       {{Prefix: "REM"}},
+		"script.bat": {{Prefix: "(*", Suffix: "*)"}},
+		".sh":        {{Prefix: "never"}},
+	}
+
+	blocks, err := parseBlocks(osfs.New("."), "testdata/customstyles/script.bat", styles)
+	assert.NilError(t, err, "expected parseBlocks() not to fail")
+	assert.DeepEqual(t, blockContents(blocks), map[string]string{
+		"commands": "echo hello",
+		"pascal":   "pascal",
+	})
+
+	// Without the custom styles, the markers are just content.
+	blocks, err = parseBlocks(osfs.New("."), "testdata/customstyles/script.bat", nil)
+	assert.NilError(t, err, "expected parseBlocks() not to fail")
+	assert.Equal(t, len(blocks), 0)
+}
+
+func TestParseBlocksCustomCommentStyleSuffix(t *testing.T) {
+	styles := map[string][]*configuration.BlockCommentStyle{
+		".bat": {{Prefix: "(*", Suffix: "**)"}},
+	}
+
+	blocks, err := parseBlocks(osfs.New("."), "testdata/customstyles/script.bat", styles)
+	assert.NilError(t, err, "expected parseBlocks() not to fail")
+	assert.Equal(t, len(blocks), 0, "expected markers without the suffix to be ignored")
+}
+
+func TestParseBlocksInvalidCustomCommentStyle(t *testing.T) {
+	_, err := parseBlocks(osfs.New("."), "testdata/customstyles/script.bat", map[string][]*configuration.BlockCommentStyle{
+		".bat": {{Suffix: "*)"}},
+	})
+	assert.Error(t, err, `invalid block comment style for "testdata/customstyles/script.bat", prefix must be set`)
+}
+
+// blockContents returns the contents of the provided blocks by name
+func blockContents(blocks map[string]*block) map[string]string {
+	contents := make(map[string]string, len(blocks))
+	for name, b := range blocks {
+		contents[name] = b.Contents()
+	}
+	return contents
+}
+
+func TestParseBlocksLongLines(t *testing.T) {
+	long := strings.Repeat("a", 1024*1024)
+	blocks, err := parseBlocksFromReader(strings.NewReader(
+		"## <<Stencil::Block(long)>>\n"+long+"\n## <</Stencil::Block>>\n"+long), "long.txt", nil)
+	assert.NilError(t, err, "expected parseBlocksFromReader() not to fail")
+	assert.Equal(t, blocks["long"].Contents(), long)
+}
+
+func TestParseBlocksPreservesLineEndings(t *testing.T) {
+	blocks, err := parseBlocksFromReader(strings.NewReader(
+		"## <<Stencil::Block(crlf)>>\r\na\r\n\r\nb\r\n## <</Stencil::Block>>\r\n"+
+			"## <<Stencil::Block(mixed)>>\na\r\nb\n## <</Stencil::Block>>"), "eol.txt", nil)
+	assert.NilError(t, err, "expected parseBlocksFromReader() not to fail")
+	assert.Equal(t, blocks["crlf"].Contents(), "a\r\n\r\nb")
+	assert.Equal(t, blocks["mixed"].Contents(), "a\r\nb")
+}
+
+func TestParseBlocksPositions(t *testing.T) {
+	blocks, err := parseBlocks(osfs.New("."), "testdata/nestedblocks-test.txt", nil)
+	assert.NilError(t, err, "expected parseBlocks() not to fail")
+	assert.Equal(t, blocks["outer"].startLine, 1)
+	assert.Equal(t, blocks["outer"].endLine, 7)
+	assert.Equal(t, blocks["inner"].startLine, 3)
+	assert.Equal(t, blocks["inner"].endLine, 5)
+}
+
+func TestReadBlockInfo(t *testing.T) {
+	infos, err := ReadBlockInfo(osfs.New("."), "testdata/nestedblocks-test.txt")
+	assert.NilError(t, err, "expected ReadBlockInfo() not to fail")
+	assert.DeepEqual(t, infos, []BlockInfo{
+		{Name: "outer", StartLine: 1, EndLine: 7},
+		{Name: "inner", Parent: "outer", StartLine: 3, EndLine: 5},
+	})
+}
@@ -0,0 +1,630 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements the render cache, which reuses the
+// output of templates whose inputs didn't change since they were last
+// rendered.
+
+package codegen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template/parse"
+	"time"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/go-git/go-billy/v5"
+	"github.com/mitchellh/hashstructure/v2"
+	"go.rgst.io/stencil/internal/modules"
+	"go.rgst.io/stencil/internal/version"
+)
+
+// renderCacheVersion is the version of the format of the render cache,
+// caches of other versions are discarded.
+const renderCacheVersion = 2
+
+// nondeterministicFuncs are the template functions whose output can
+// change between runs without any of the tracked inputs of a template
+// changing. Modules that use them are never cached, which also keeps
+// the keys and secrets they generate out of the cache. These are the
+// functions sprig doesn't consider hermetic, and the ones it does but
+// that use randomness or the current time.
+var nondeterministicFuncs = func() map[string]bool {
+	funcs := map[string]bool{
+		"ago":                      true,
+		"bcrypt":                   true,
+		"encryptAES":               true,
+		"genCA":                    true,
+		"genCAWithKey":             true,
+		"genPrivateKey":            true,
+		"genSelfSignedCert":        true,
+		"genSelfSignedCertWithKey": true,
+		"genSignedCert":            true,
+		"genSignedCertWithKey":     true,
+		"htpasswd":                 true,
+		"randInt":                  true,
+		"shuffle":                  true,
+	}
+
+	hermetic := sprig.HermeticTxtFuncMap()
+	for name := range sprig.TxtFuncMap() {
+		if _, ok := hermetic[name]; !ok {
+			funcs[name] = true
+		}
+	}
+	return funcs
+}()
+
+// RenderCache stores the output of rendered templates along with the
+// inputs they read, so that templates whose inputs didn't change can be
+// skipped the next time the project is rendered. Its zero value isn't
+// usable, see LoadRenderCache.
+type RenderCache struct {
+	// path is the path the cache is stored at
+	path string
+
+	// mu protects prev and next
+	mu sync.Mutex
+
+	// prev are the entries loaded from path, by template
+	prev map[string]*renderCacheEntry
+
+	// next are the entries of the current render, by template, which
+	// replace prev when saved. Templates are rendered once per pass, the
+	// entry of the last pass is kept.
+	next map[string]*renderCacheEntry
+}
+
+// renderCacheData is the format of the file a RenderCache is stored in
+type renderCacheData struct {
+	// Version is the version of the format, see renderCacheVersion
+	Version int `json:"version"`
+
+	// Entries are the entries of the cache, by template
+	Entries map[string]*renderCacheEntry `json:"entries"`
+}
+
+// renderCacheEntry is the cached render of a single template
+type renderCacheEntry struct {
+	// Key is the render key of the template, see Stencil.renderKey
+	Key string `json:"key"`
+
+	// Deps are the hashes of the inputs the template read, by input, see
+	// renderDeps
+	Deps map[string]string `json:"deps,omitempty"`
+
+	// WroteSharedData denotes that the template wrote module hooks or
+	// globals. Such templates are never skipped, since the data isn't
+	// cached.
+	WroteSharedData bool `json:"wroteSharedData,omitempty"`
+
+	// Files are the files rendered by the template
+	Files []*renderCacheFile `json:"files,omitempty"`
+}
+
+// renderCacheFile is a file rendered by a cached template
+type renderCacheFile struct {
+	Name           string            `json:"name"`
+	Contents       []byte            `json:"contents,omitempty"`
+	Mode           os.FileMode       `json:"mode"`
+	ModTime        time.Time         `json:"modTime"`
+	Symlink        string            `json:"symlink,omitempty"`
+	Deleted        bool              `json:"deleted,omitempty"`
+	Skipped        bool              `json:"skipped,omitempty"`
+	SkippedReason  string            `json:"skippedReason,omitempty"`
+	Warnings       []string          `json:"warnings,omitempty"`
+	UsedBlocks     []string          `json:"usedBlocks,omitempty"`
+	MigratedBlocks map[string]string `json:"migratedBlocks,omitempty"`
+}
+
+// LoadRenderCache loads the render cache stored at path. An empty cache
+// is returned if there's none, or if it was written by another version
+// of stencil.
+func LoadRenderCache(path string) (*RenderCache, error) {
+	c := &RenderCache{
+		path: path,
+		prev: make(map[string]*renderCacheEntry),
+		next: make(map[string]*renderCacheEntry),
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read render cache: %w", err)
+	}
+
+	var f renderCacheData
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("failed to decode render cache: %w", err)
+	}
+	if f.Version == renderCacheVersion && f.Entries != nil {
+		c.prev = f.Entries
+	}
+	return c, nil
+}
+
+// Save writes the entries of the last render to the path the cache was
+// loaded from. Entries of templates that weren't rendered are dropped.
+func (c *RenderCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := json.Marshal(&renderCacheData{Version: renderCacheVersion, Entries: c.next})
+	if err != nil {
+		return fmt.Errorf("failed to encode render cache: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create render cache directory: %w", err)
+	}
+
+	// Write to a temporary file first, so that the cache is never left
+	// half written.
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write render cache: %w", err)
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// get returns the cached render of the template at path, or nil if
+// there is none
+func (c *RenderCache) get(path string) *renderCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.prev[path]
+}
+
+// put stores the render of the template at path
+func (c *RenderCache) put(path string, e *renderCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.next[path] = e
+}
+
+// renderDeps are the inputs read by a template while it's rendered,
+// which decide if it has to be rendered again. Inputs are identified
+// by their kind and name (e.g., "arg:name", see Stencil.depHash).
+type renderDeps struct {
+	// hashes are the hashes of the inputs, by input
+	hashes map[string]string
+
+	// uncacheable is the reason the render of the template can't be
+	// cached, if it can't
+	uncacheable string
+
+	// wroteSharedData denotes that the template wrote module hooks or
+	// globals
+	wroteSharedData bool
+}
+
+// add records that the input dep, with the provided hash, was read
+func (d *renderDeps) add(dep, hash string) {
+	if d == nil {
+		return
+	}
+	if hash == "" {
+		d.markUncacheable("unable to hash " + dep)
+		return
+	}
+	d.hashes[dep] = hash
+}
+
+// addValue records that the input dep, with the provided value, was
+// read
+func (d *renderDeps) addValue(dep string, v any) {
+	if d == nil {
+		return
+	}
+	d.add(dep, hashValue(v))
+}
+
+// markUncacheable records that the template can't be cached for the
+// provided reason
+func (d *renderDeps) markUncacheable(reason string) {
+	if d == nil || d.uncacheable != "" {
+		return
+	}
+	d.uncacheable = reason
+}
+
+// markWroteSharedData records that the template wrote module hooks or
+// globals
+func (d *renderDeps) markWroteSharedData() {
+	if d == nil {
+		return
+	}
+	d.wroteSharedData = true
+}
+
+// hashValue returns the hash of v, or an empty string if it can't be
+// hashed
+func hashValue(v any) string {
+	h, err := hashstructure.Hash(v, hashstructure.FormatV2, nil)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%016x", h)
+}
+
+// hashBytes returns the hash of b
+func hashBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashBlocks returns the hash of the contents of blocks
+func hashBlocks(blocks map[string]*block) string {
+	names := make([]string, 0, len(blocks))
+	for name := range blocks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%q=%q\n", name, blocks[name].Contents())
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// moduleCacheInfo is information about a module used to compute the
+// render keys of its templates
+type moduleCacheInfo struct {
+	// hash is the hash of the version, manifest and templates of the
+	// module
+	hash string
+
+	// usesGit denotes that templates of the module read .Git, which
+	// changes with every commit of the project
+	usesGit bool
+
+	// uncacheable is the reason templates of the module can't be cached,
+	// if they can't
+	uncacheable string
+}
+
+// newModuleCacheInfo returns the moduleCacheInfo of m, whose templates
+// are tpls and must have been parsed already
+func newModuleCacheInfo(m *modules.Module, tpls []*Template) (*moduleCacheInfo, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", m.Name, m.URI, m.Version.String())
+	if m.Version.Commit != "" {
+		fmt.Fprintf(h, "commit %s\n", m.Version.Commit)
+	}
+	fmt.Fprintf(h, "manifest %s\n", hashValue(m.Manifest))
+
+	sorted := make([]*Template, 0, len(tpls))
+	for _, t := range tpls {
+		if t.Module == m {
+			sorted = append(sorted, t)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+	for _, t := range sorted {
+		fmt.Fprintf(h, "template %q %o %t %s\n", t.Path, t.mode, t.Static, hashBytes(t.Contents))
+	}
+
+	info := &moduleCacheInfo{hash: hex.EncodeToString(h.Sum(nil))}
+
+	tpl, err := m.CloneTemplate()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tpl.Templates() {
+		if t.Tree != nil {
+			info.scan(t.Tree.Root)
+		}
+	}
+	return info, nil
+}
+
+// scan walks the parse tree of a template of the module, looking for
+// uses of .Git and of nondeterministic functions.
+func (info *moduleCacheInfo) scan(node parse.Node) {
+	if node == nil || info.uncacheable != "" {
+		return
+	}
+
+	hasGit := func(idents []string) bool {
+		for _, ident := range idents {
+			if ident == "Git" {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			info.scan(c)
+		}
+	case *parse.ActionNode:
+		info.scan(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Cmds {
+			info.scan(c)
+		}
+	case *parse.CommandNode:
+		for _, c := range n.Args {
+			info.scan(c)
+		}
+	case *parse.IdentifierNode:
+		if nondeterministicFuncs[n.Ident] {
+			info.uncacheable = fmt.Sprintf("uses the nondeterministic function %q", n.Ident)
+		}
+	case *parse.FieldNode:
+		info.usesGit = info.usesGit || hasGit(n.Ident)
+	case *parse.VariableNode:
+		info.usesGit = info.usesGit || hasGit(n.Ident)
+	case *parse.ChainNode:
+		info.usesGit = info.usesGit || hasGit(n.Field)
+		info.scan(n.Node)
+	case *parse.IfNode:
+		info.scanBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		info.scanBranch(&n.BranchNode)
+	case *parse.WithNode:
+		info.scanBranch(&n.BranchNode)
+	case *parse.TemplateNode:
+		info.scan(n.Pipe)
+	}
+}
+
+// scanBranch scans the pipeline and lists of a branch node, see scan
+func (info *moduleCacheInfo) scanBranch(n *parse.BranchNode) {
+	info.scan(n.Pipe)
+	info.scan(n.List)
+	info.scan(n.ElseList)
+}
+
+// prepareRenderCache computes the information needed to compute render
+// keys for the provided, parsed, templates. Caching is disabled for
+// this render when there is no cache, or when it can't be used.
+func (s *Stencil) prepareRenderCache(tpls []*Template, vals *Values) {
+	if s.renderCache == nil {
+		return
+	}
+
+	s.moduleCacheInfo = make(map[*modules.Module]*moduleCacheInfo, len(s.modules))
+	for _, m := range s.modules {
+		info, err := newModuleCacheInfo(m, tpls)
+		if err != nil {
+			s.log.WithError(err).Warn("Failed to prepare render cache, rendering every template")
+			s.moduleCacheInfo = nil
+			return
+		}
+		s.moduleCacheInfo[m] = info
+	}
+
+	noGit := vals.Copy()
+	noGit.Git = git{}
+	s.valsHash = [2]string{hashValue(noGit), hashValue(vals)}
+	if s.valsHash[0] == "" || s.valsHash[1] == "" {
+		s.log.Warn("Failed to hash template values, rendering every template")
+		s.moduleCacheInfo = nil
+	}
+}
+
+// renderKey returns the render key of t in the current pass, which is
+// the hash of everything that decides its output: its module, the
+// values passed to it, the directory replacements of its module and the
+// current hash of each of the provided inputs it read.
+func (s *Stencil) renderKey(t *Template, deps map[string]string) string {
+	info := s.moduleCacheInfo[t.Module]
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%d %s\n", renderCacheVersion, version.Version)
+	fmt.Fprintf(h, "module %s\ntemplate %q\n", info.hash, t.Path)
+	fmt.Fprintf(h, "changed %t\n", s.moduleVersionChanged(t.Module))
+	if info.usesGit {
+		fmt.Fprintf(h, "values %s\n", s.valsHash[1])
+	} else {
+		fmt.Fprintf(h, "values %s\n", s.valsHash[0])
+	}
+	fmt.Fprintf(h, "dirReplacements %s\n", hashValue(t.Module.DirReplacements()))
+
+	names := make([]string, 0, len(deps))
+	for name := range deps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "%q=%s\n", name, deps[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// currentDeps returns the current hashes of the provided inputs of t
+func (s *Stencil) currentDeps(t *Template, deps map[string]string) map[string]string {
+	cur := make(map[string]string, len(deps))
+	for dep := range deps {
+		cur[dep] = s.depHash(t, dep)
+	}
+	return cur
+}
+
+// depHash returns the current hash of the input dep of t, or an empty
+// string if it can't be determined. Inputs are:
+//
+//   - arg:<path>: the value of an argument, see TplStencil.Arg
+//   - hook:<key>: the data of a module hook, see TplStencil.GetModuleHook
+//   - global:<key>: the value of a global, see TplStencil.GetGlobal
+//   - file:<path>: the contents of a file in the project
+//   - blocks:<path>: the blocks of a file in the project
+func (s *Stencil) depHash(t *Template, dep string) string {
+	kind, name, _ := strings.Cut(dep, ":")
+	switch kind {
+	case "arg":
+		v, err := (&TplStencil{s, t, s.log}).Arg(name)
+		if err != nil {
+			return ""
+		}
+		return hashValue(v)
+	case "hook":
+		return hashValue(s.moduleHookValues(name))
+	case "global":
+		v, _ := s.globalValue(name)
+		return hashValue(v)
+	case "file":
+		return hashFile(s.fs, name)
+	case "blocks":
+		blocks, err := parseBlocks(s.fs, name, t.blockCommentStyles())
+		if err != nil {
+			return ""
+		}
+		return hashBlocks(blocks)
+	}
+	return ""
+}
+
+// hashFile returns the hash of the contents of the file at path in fs,
+// which is "missing" if it doesn't exist.
+func hashFile(fs billy.Filesystem, path string) string {
+	f, err := fs.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "missing"
+	}
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// renderCached renders t in the current pass, unless the output of its
+// previous render can be reused because none of its inputs changed.
+// Returns true if the previous render was reused.
+func (s *Stencil) renderCached(t *Template, vals *Values) (bool, error) {
+	if s.moduleCacheInfo == nil || t.Static || s.moduleCacheInfo[t.Module].uncacheable != "" {
+		return false, t.Render(s, vals)
+	}
+
+	path := t.ImportPath()
+	if prev := s.renderCache.get(path); prev != nil && s.canReuse(t, prev) {
+		files, err := s.restoreFiles(t, prev.Files)
+		if err != nil {
+			return false, err
+		}
+		t.Files = files
+		s.renderCache.put(path, prev)
+		return true, nil
+	}
+
+	t.deps = &renderDeps{hashes: make(map[string]string)}
+	defer func() { t.deps = nil }()
+	if err := t.Render(s, vals); err != nil {
+		return false, err
+	}
+	if t.deps.uncacheable != "" {
+		t.log.Debug("Not caching render of template", "template", path, "reason", t.deps.uncacheable)
+		return false, nil
+	}
+
+	s.renderCache.put(path, &renderCacheEntry{
+		Key:             s.renderKey(t, t.deps.hashes),
+		Deps:            t.deps.hashes,
+		WroteSharedData: t.deps.wroteSharedData,
+		Files:           cacheFiles(t.Files),
+	})
+	return false, nil
+}
+
+// canReuse returns true if the cached render prev of t can be used
+// instead of rendering t in the current pass
+func (s *Stencil) canReuse(t *Template, prev *renderCacheEntry) bool {
+	// The data written to module hooks and globals isn't cached
+	if prev.WroteSharedData {
+		return false
+	}
+	return s.renderKey(t, s.currentDeps(t, prev.Deps)) == prev.Key
+}
+
+// cacheFiles converts the files rendered by a template into their
+// cached form
+func cacheFiles(files []*File) []*renderCacheFile {
+	cfs := make([]*renderCacheFile, 0, len(files))
+	for _, f := range files {
+		cf := &renderCacheFile{
+			Name:           f.path,
+			Contents:       f.contents,
+			Mode:           f.mode,
+			ModTime:        f.modTime,
+			Symlink:        f.symlinkTarget,
+			Deleted:        f.Deleted,
+			Skipped:        f.Skipped,
+			SkippedReason:  f.SkippedReason,
+			Warnings:       append([]string(nil), f.Warnings...),
+			MigratedBlocks: f.migratedBlocks,
+		}
+		for name := range f.usedBlocks {
+			cf.UsedBlocks = append(cf.UsedBlocks, name)
+		}
+		sort.Strings(cf.UsedBlocks)
+		cfs = append(cfs, cf)
+	}
+	return cfs
+}
+
+// restoreFiles returns the files of t from their cached form
+func (s *Stencil) restoreFiles(t *Template, cfs []*renderCacheFile) ([]*File, error) {
+	files := make([]*File, 0, len(cfs))
+	for _, cf := range cfs {
+		f, err := NewFile(s.fs, cf.Name, cf.Mode, cf.ModTime, t.blockCommentStyles())
+		if err != nil {
+			return nil, err
+		}
+		f.contents = cf.Contents
+		f.symlinkTarget = cf.Symlink
+		f.Deleted = cf.Deleted
+		f.Skipped = cf.Skipped
+		f.SkippedReason = cf.SkippedReason
+		f.Warnings = append([]string(nil), cf.Warnings...)
+		f.migratedBlocks = cf.MigratedBlocks
+		if len(cf.UsedBlocks) > 0 {
+			f.usedBlocks = make(map[string]bool, len(cf.UsedBlocks))
+			for _, name := range cf.UsedBlocks {
+				f.usedBlocks[name] = true
+			}
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
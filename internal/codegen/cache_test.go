@@ -0,0 +1,180 @@
+package codegen
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"go.rgst.io/stencil/internal/modules"
+	"go.rgst.io/stencil/internal/modules/modulestest"
+	"go.rgst.io/stencil/pkg/configuration"
+	"go.rgst.io/stencil/pkg/slogext"
+	"gotest.tools/v3/assert"
+)
+
+// renderWithCache renders the module in fs into project with the
+// provided arguments, using the render cache at cachePath. Returns the
+// rendered files and the number of templates whose output was reused.
+func renderWithCache(t *testing.T, fs, project billy.Filesystem, args map[string]any,
+	cachePath string) (map[string]string, int) {
+	ctx := context.Background()
+	log := slogext.NewTestLogger(t)
+
+	tp, err := modulestest.NewWithFS(ctx, "testing", fs)
+	assert.NilError(t, err, "failed to NewWithFS")
+	st := NewStencil(&configuration.Manifest{Name: "test", Arguments: args}, []*modules.Module{tp}, log)
+	st.SetProjectFS(project)
+
+	c, err := LoadRenderCache(cachePath)
+	assert.NilError(t, err, "failed to load render cache")
+	st.SetRenderCache(c)
+
+	tpls, err := st.Render(ctx, log)
+	assert.NilError(t, err, "expected Render() to not fail")
+	assert.NilError(t, c.Save(), "failed to save render cache")
+
+	files := make(map[string]string)
+	for _, tpl := range tpls {
+		for _, f := range tpl.Files {
+			files[f.Name()] = f.String()
+		}
+	}
+	return files, st.reused
+}
+
+func TestRenderCacheReusesUnchangedTemplates(t *testing.T) {
+	fs := memfs.New()
+	assert.NilError(t, util.WriteFile(fs, "manifest.yaml", []byte(
+		"name: testing\narguments:\n  greeting:\n    schema:\n      type: string\n"), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/arg.txt.tpl", []byte(
+		`{{ stencil.Arg "greeting" }}`), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/read.txt.tpl", []byte(
+		`{{ stencil.ReadFile "in.txt" }}`), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/block.txt.tpl", []byte(
+		"## <<Stencil::Block(custom)>>\n{{ file.Block \"custom\" }}\n## <</Stencil::Block>>\n"), 0o644))
+
+	project := memfs.New()
+	assert.NilError(t, util.WriteFile(project, "in.txt", []byte("one"), 0o644))
+	cachePath := filepath.Join(t.TempDir(), "render.json")
+	args := map[string]any{"greeting": "hello"}
+
+	want, reused := renderWithCache(t, fs, project, args, cachePath)
+	assert.Equal(t, reused, 0)
+
+	got, reused := renderWithCache(t, fs, project, args, cachePath)
+	assert.Equal(t, reused, 3)
+	assert.DeepEqual(t, got, want)
+
+	args["greeting"] = "bye"
+	got, reused = renderWithCache(t, fs, project, args, cachePath)
+	assert.Equal(t, reused, 2)
+	assert.Equal(t, got["arg.txt"], "bye")
+
+	assert.NilError(t, util.WriteFile(project, "in.txt", []byte("two"), 0o644))
+	got, reused = renderWithCache(t, fs, project, args, cachePath)
+	assert.Equal(t, reused, 2)
+	assert.Equal(t, got["read.txt"], "two")
+
+	assert.NilError(t, util.WriteFile(project, "block.txt", []byte(
+		"## <<Stencil::Block(custom)>>\nkept\n## <</Stencil::Block>>\n"), 0o644))
+	got, reused = renderWithCache(t, fs, project, args, cachePath)
+	assert.Equal(t, reused, 2)
+	assert.Equal(t, got["block.txt"], "## <<Stencil::Block(custom)>>\nkept\n## <</Stencil::Block>>\n")
+}
+
+func TestRenderCacheTracksModuleHooks(t *testing.T) {
+	fs := memfs.New()
+	assert.NilError(t, util.WriteFile(fs, "manifest.yaml", []byte(
+		"name: testing\narguments:\n  name:\n    schema:\n      type: string\n"), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/add.txt.tpl", []byte(
+		`{{- stencil.AddToModuleHook "testing" "names" (list (stencil.Arg "name")) }}`), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/hooks.txt.tpl", []byte(
+		`{{- range stencil.GetModuleHook "names" }}{{ . }}{{ end }}`), 0o644))
+
+	project := memfs.New()
+	cachePath := filepath.Join(t.TempDir(), "render.json")
+
+	_, reused := renderWithCache(t, fs, project, map[string]any{"name": "a"}, cachePath)
+	assert.Equal(t, reused, 0)
+
+	got, reused := renderWithCache(t, fs, project, map[string]any{"name": "b"}, cachePath)
+	assert.Equal(t, reused, 0, "expected the module hook to be re-rendered")
+	assert.Equal(t, got["hooks.txt"], "b")
+
+	// Templates that write module hooks are always rendered, since what
+	// they write isn't cached
+	got, reused = renderWithCache(t, fs, project, map[string]any{"name": "b"}, cachePath)
+	assert.Equal(t, reused, 1)
+	assert.Equal(t, got["hooks.txt"], "b")
+}
+
+func TestRenderCacheSkipsNondeterministicModules(t *testing.T) {
+	fs := memfs.New()
+	assert.NilError(t, util.WriteFile(fs, "manifest.yaml", []byte("name: testing"), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/now.txt.tpl", []byte(`{{ now | date "2006" }}`), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/static.txt.tpl", []byte(`static`), 0o644))
+
+	project := memfs.New()
+	cachePath := filepath.Join(t.TempDir(), "render.json")
+
+	renderWithCache(t, fs, project, map[string]any{}, cachePath)
+	_, reused := renderWithCache(t, fs, project, map[string]any{}, cachePath)
+	assert.Equal(t, reused, 0)
+}
+
+func TestRenderCacheNeverStoresGeneratedSecrets(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+	}{
+		{name: "private key", template: `{{ genPrivateKey "ecdsa" }}`},
+		{name: "certificate", template: `{{ (genCA "ca" 365).Cert }}`},
+		{name: "password hash", template: `{{ bcrypt "secret" }}`},
+		{name: "date", template: `{{ date "2006" now }}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := memfs.New()
+			assert.NilError(t, util.WriteFile(fs, "manifest.yaml", []byte("name: testing"), 0o644))
+			assert.NilError(t, util.WriteFile(fs, "templates/secret.txt.tpl", []byte(tt.template), 0o644))
+
+			project := memfs.New()
+			cachePath := filepath.Join(t.TempDir(), "render.json")
+
+			first, _ := renderWithCache(t, fs, project, map[string]any{}, cachePath)
+			_, reused := renderWithCache(t, fs, project, map[string]any{}, cachePath)
+			assert.Equal(t, reused, 0)
+
+			// The output is stored JSON encoded
+			out, err := json.Marshal(first["secret.txt"])
+			assert.NilError(t, err)
+			b, err := os.ReadFile(cachePath)
+			assert.NilError(t, err)
+			assert.Assert(t, !strings.Contains(string(b), strings.Trim(string(out), `"`)), "expected output to not be cached")
+		})
+	}
+}
+
+func TestLoadRenderCacheDiscardsOtherVersions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "render.json")
+
+	c, err := LoadRenderCache(path)
+	assert.NilError(t, err, "expected a missing cache to be empty")
+	c.put("testing/a.tpl", &renderCacheEntry{Key: "key"})
+	assert.NilError(t, c.Save())
+
+	c, err = LoadRenderCache(path)
+	assert.NilError(t, err)
+	assert.Equal(t, c.get("testing/a.tpl").Key, "key")
+
+	assert.NilError(t, os.WriteFile(path, []byte(`{"version":1,"entries":{"testing/a.tpl":{}}}`), 0o644))
+	c, err = LoadRenderCache(path)
+	assert.NilError(t, err)
+	assert.Assert(t, c.get("testing/a.tpl") == nil, "expected entries of other versions to be discarded")
+}
@@ -6,8 +6,18 @@
 package codegen
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"go.rgst.io/stencil/pkg/configuration"
 )
 
 // _ ensures that we implement the os.FileInfo interface
@@ -19,7 +29,23 @@ type File struct {
 	// comments that encompass data that is persisted across runs of stencil. These
 	// are then exposed via the Block method to be re-injected at template runtime.
 	// This enables users to persist their changes in certain areas.
-	blocks map[string]string
+	blocks map[string]*block
+
+	// fs is the filesystem of the project the file is rendered in, the
+	// existing file is read from it
+	fs billy.Filesystem
+
+	// commentStyles are the custom comment styles blocks can be written
+	// in, see configuration.TemplateRepositoryManifest.BlockCommentStyles
+	commentStyles map[string][]*configuration.BlockCommentStyle
+
+	// usedBlocks are the names of the blocks that were read while
+	// rendering this file, used to find orphaned blocks.
+	usedBlocks map[string]bool
+
+	// migratedBlocks maps the new name of a block to its old name, see
+	// MigrateBlock.
+	migratedBlocks map[string]string
 
 	// contents is the contents of the file rendered for this template
 	contents []byte
@@ -27,6 +53,10 @@ type File struct {
 	// path is the full path to the file
 	path string
 
+	// symlinkTarget is the target of the symlink this file is, if it's
+	// empty this file is a regular file.
+	symlinkTarget string
+
 	// mode is the mode of the file
 	mode os.FileMode
 
@@ -53,21 +83,157 @@ type File struct {
 	Warnings []string
 }
 
-// NewFile creates a new file, an existing file at the given path is
-// parsed to read blocks from, if it exists. An error is returned if
-// the file is unable to be read for a reason other than not existing.
-func NewFile(path string, mode os.FileMode, modTime time.Time) (*File, error) {
-	blocks, err := parseBlocks(path)
+// NewFile creates a new file, an existing file at the given path in fs,
+// the filesystem of the project, is parsed to read blocks from, if it
+// exists. Blocks may be written in the built-in comment styles or the
+// provided custom comment styles, which may be nil. An error is returned
+// if the file is unable to be read for a reason other than not existing.
+func NewFile(fs billy.Filesystem, path string, mode os.FileMode, modTime time.Time,
+	commentStyles map[string][]*configuration.BlockCommentStyle) (*File, error) {
+	blocks, err := parseBlocks(fs, path, commentStyles)
 	if err != nil {
 		return nil, err
 	}
 
-	return &File{path: path, mode: mode, modTime: modTime, blocks: blocks}, nil
+	return &File{fs: fs, path: path, mode: mode, modTime: modTime, blocks: blocks, commentStyles: commentStyles}, nil
 }
 
 // Block returns the contents of a given block.
 func (f *File) Block(name string) string {
-	return f.blocks[name]
+	if b, ok := f.block(name); ok {
+		return b.Contents()
+	}
+	return ""
+}
+
+// block returns the block with the provided name, taking migrated
+// blocks into account, and marks it as used.
+func (f *File) block(name string) (*block, bool) {
+	if f.usedBlocks == nil {
+		f.usedBlocks = make(map[string]bool)
+	}
+	f.usedBlocks[name] = true
+
+	if b, ok := f.blocks[name]; ok {
+		return b, true
+	}
+
+	if old, ok := f.migratedBlocks[name]; ok {
+		f.usedBlocks[old] = true
+		b, ok := f.blocks[old]
+		return b, ok
+	}
+
+	return nil, false
+}
+
+// MigrateBlock renames the block oldName to newName, so that reading
+// newName returns the contents of oldName when the file doesn't contain
+// a block named newName yet. This prevents the contents of a block from
+// being orphaned when a template renames it.
+func (f *File) MigrateBlock(oldName, newName string) error {
+	if oldName == newName {
+		return fmt.Errorf("unable to migrate block %q to itself", oldName)
+	}
+	if f.usedBlocks[newName] {
+		return fmt.Errorf("block %q must be migrated before it's read", newName)
+	}
+
+	if f.migratedBlocks == nil {
+		f.migratedBlocks = make(map[string]string)
+	}
+	f.migratedBlocks[newName] = oldName
+	return nil
+}
+
+// OrphanedBlocks returns the names of the blocks in the existing file
+// that weren't read while rendering it and have contents, sorted by
+// name. Only top-level blocks are returned, since the contents of nested
+// blocks are part of the block they're nested in: if that block was
+// read, so were they, and if it's orphaned, it contains them. A block is
+// considered read when any block nested in it was, since the template
+// moved the contents it cares about into that block.
+func (f *File) OrphanedBlocks() []string {
+	used := make(map[string]bool, len(f.usedBlocks))
+	for name := range f.usedBlocks {
+		for b := f.blocks[name]; b != nil; b = b.parent {
+			used[b.name] = true
+		}
+	}
+
+	var names []string
+	for name, b := range f.blocks {
+		if b.parent != nil || used[name] || strings.TrimSpace(b.Contents()) == "" {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OrphanedBlocksSidecar returns the contents of the sidecar file that
+// preserves the contents of the provided orphaned blocks, see
+// OrphanedBlocks. The blocks are written with block markers so that
+// they can be read with stencil.ReadBlocks.
+func (f *File) OrphanedBlocksSidecar(names []string) []byte {
+	var buf bytes.Buffer
+	for _, name := range names {
+		b, ok := f.blocks[name]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&buf, "## <<Stencil::Block(%s)>>\n%s\n## <</Stencil::Block>>\n", name, b.Contents())
+	}
+	return buf.Bytes()
+}
+
+// OrphanedBlocksSidecarFile returns the path and the new contents of the
+// sidecar file that preserves the contents of the orphaned blocks of f,
+// which are appended to the existing sidecar file in fs. An empty path
+// is returned when f has no orphaned blocks.
+func (f *File) OrphanedBlocksSidecarFile(fs billy.Filesystem) (string, []byte, error) {
+	names := f.OrphanedBlocks()
+	if len(names) == 0 {
+		return "", nil, nil
+	}
+
+	sidecar := f.Name() + OrphanedBlocksSuffix
+	existing, err := util.ReadFile(fs, sidecar)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", nil, fmt.Errorf("failed to read %q: %w", sidecar, err)
+	}
+	return sidecar, append(existing, f.OrphanedBlocksSidecar(names)...), nil
+}
+
+// BlockOrDefault returns the contents of a given block, or def if the
+// block doesn't exist. When the block exists, its "default" argument
+// determines how def is used, see blockDefaultKeep, blockDefaultReset
+// and blockDefaultAppend. moduleChanged denotes if the version of the
+// module rendering this file changed since the last run.
+func (f *File) BlockOrDefault(name, def string, moduleChanged bool) string {
+	b, ok := f.block(name)
+	if !ok {
+		return def
+	}
+
+	contents := b.Contents()
+	switch b.Default() {
+	case blockDefaultReset:
+		if moduleChanged {
+			return def
+		}
+	case blockDefaultAppend:
+		if def == "" || strings.Contains(contents, def) {
+			return contents
+		}
+		if contents == "" {
+			return def
+		}
+		return contents + "\n" + def
+	}
+
+	return contents
 }
 
 // AddDeprecationNotice adds a deprecation notice to a file
@@ -83,19 +249,65 @@ func (f *File) AddDeprecationNotice(msg string) {
 // SetPath updates the path of this file. This causes
 // the blocks to be parsed again.
 func (f *File) SetPath(path string) error {
-	blocks, err := parseBlocks(path)
+	if f.symlinkTarget != "" {
+		if err := validateSymlinkTarget(path, f.symlinkTarget); err != nil {
+			return err
+		}
+	}
+
+	blocks, err := parseBlocks(f.fs, path, f.commentStyles)
 	if err != nil {
 		return err
 	}
 	f.blocks = blocks
 	f.path = path
+	f.usedBlocks = nil
+	f.migratedBlocks = nil
+
+	return nil
+}
+
+// SetSymlink turns this file into a symlink to target, which must be a
+// relative path that, resolved from the directory of the file, stays
+// inside of the project. The contents and blocks of the file are
+// discarded.
+func (f *File) SetSymlink(target string) error {
+	if err := validateSymlinkTarget(f.path, target); err != nil {
+		return err
+	}
 
+	f.symlinkTarget = target
+	f.contents = nil
+	f.blocks = make(map[string]*block)
+	return nil
+}
+
+// SymlinkTarget returns the target of the symlink this file is, or an
+// empty string if this file is a regular file.
+func (f *File) SymlinkTarget() string {
+	return f.symlinkTarget
+}
+
+// validateSymlinkTarget returns an error if a symlink at path to target
+// would point outside of the project, which is the current directory.
+func validateSymlinkTarget(path, target string) error {
+	if target == "" {
+		return fmt.Errorf("symlink target of %q must not be empty", path)
+	}
+	if filepath.IsAbs(target) || filepath.IsAbs(path) {
+		return fmt.Errorf("symlink %q to %q must be relative", path, target)
+	}
+
+	resolved := filepath.Join(filepath.Dir(path), target)
+	if resolved == ".." || strings.HasPrefix(resolved, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("symlink %q to %q points outside of the project", path, target)
+	}
 	return nil
 }
 
 // SetMode updates the mode of the file
 func (f *File) SetMode(mode os.FileMode) {
-	f.mode = mode
+	f.mode = mode &^ os.ModeSymlink
 }
 
 // SetContents updates the contents of the current file
@@ -132,13 +344,21 @@ func (f *File) ModTime() time.Time {
 	return f.modTime
 }
 
-// Mode returns the file mode
+// Mode returns the file mode, which includes os.ModeSymlink if the
+// file is a symlink
 func (f *File) Mode() os.FileMode {
+	if f.symlinkTarget != "" {
+		return f.mode | os.ModeSymlink
+	}
 	return f.mode
 }
 
-// Size returns the size of the file
+// Size returns the size of the file, for symlinks this is the length of
+// the target like with os.Lstat
 func (f *File) Size() int64 {
+	if f.symlinkTarget != "" {
+		return int64(len(f.symlinkTarget))
+	}
 	return int64(len(f.contents))
 }
 
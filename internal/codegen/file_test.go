@@ -6,9 +6,13 @@ package codegen
 
 import (
 	"io"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
 	"gotest.tools/v3/assert"
 )
 
@@ -42,3 +46,110 @@ func TestFileBasic(t *testing.T) {
 	assert.Equal(t, cnts, string(f.contents), "expected SetContents() to set contents")
 	assert.Equal(t, cnts, f.String(), "expected String() to return proper contents")
 }
+
+func TestFileBlockOrDefault(t *testing.T) {
+	blocks, err := parseBlocks(osfs.New("."), "testdata/blockargs-test.txt", nil)
+	assert.NilError(t, err, "failed to parse blocks")
+	f := &File{blocks: blocks}
+
+	tests := []struct {
+		name          string
+		def           string
+		moduleChanged bool
+		want          string
+	}{
+		{"missing", "default", false, "default"},
+		{"keep", "default", false, "b"},
+		{"keep", "default", true, "b"},
+		{"reset", "default", false, "c"},
+		{"reset", "default", true, "default"},
+		{"append", "default", false, "d\ndefault"},
+		{"append", "d", false, "d"},
+		{"append", "", false, "d"},
+	}
+	for _, tt := range tests {
+		got := f.BlockOrDefault(tt.name, tt.def, tt.moduleChanged)
+		assert.Equal(t, got, tt.want, "block %q (moduleChanged=%v)", tt.name, tt.moduleChanged)
+	}
+}
+
+func TestFileOrphanedBlocks(t *testing.T) {
+	f, err := NewFile(osfs.New("."), "testdata/orphanedblocks-test.txt", 0o644, time.Now(), nil)
+	assert.NilError(t, err, "failed to create file")
+	assert.DeepEqual(t, f.OrphanedBlocks(), []string{"dropped", "kept", "outer", "renamed"})
+
+	assert.NilError(t, f.MigrateBlock("renamed", "newName"), "failed to migrate block")
+	assert.Equal(t, f.Block("kept"), "kept")
+	assert.Equal(t, f.Block("newName"), "renamed", "expected migrated block to return old contents")
+	assert.Equal(t, f.Block("outer"), "// <<Stencil::Block(inner)>>\ninner\n// <</Stencil::Block>>")
+	assert.DeepEqual(t, f.OrphanedBlocks(), []string{"dropped"})
+
+	assert.Equal(t, string(f.OrphanedBlocksSidecar(f.OrphanedBlocks())),
+		"## <<Stencil::Block(dropped)>>\ndropped\n## <</Stencil::Block>>\n")
+
+	// The sidecar file is appended to
+	fs := memfs.New()
+	sidecar, contents, err := f.OrphanedBlocksSidecarFile(fs)
+	assert.NilError(t, err)
+	assert.Equal(t, sidecar, "testdata/orphanedblocks-test.txt.stencil-orphaned")
+	assert.NilError(t, WriteFile(fs, sidecar, contents, 0o644, ""))
+	_, contents, err = f.OrphanedBlocksSidecarFile(fs)
+	assert.NilError(t, err)
+	assert.Equal(t, string(contents), strings.Repeat("## <<Stencil::Block(dropped)>>\ndropped\n## <</Stencil::Block>>\n", 2))
+}
+
+func TestFileOrphanedBlocksNested(t *testing.T) {
+	f, err := NewFile(osfs.New("."), "testdata/orphanedblocks-test.txt", 0o644, time.Now(), nil)
+	assert.NilError(t, err, "failed to create file")
+
+	// Reading only the nested block uses the block it's nested in
+	assert.Equal(t, f.Block("inner"), "inner")
+	assert.DeepEqual(t, f.OrphanedBlocks(), []string{"dropped", "kept", "renamed"})
+}
+
+func TestFileMigrateBlockErrors(t *testing.T) {
+	f, err := NewFile(osfs.New("."), "testdata/orphanedblocks-test.txt", 0o644, time.Now(), nil)
+	assert.NilError(t, err, "failed to create file")
+
+	assert.Error(t, f.MigrateBlock("kept", "kept"), `unable to migrate block "kept" to itself`)
+
+	f.Block("newName")
+	assert.Error(t, f.MigrateBlock("renamed", "newName"), `block "newName" must be migrated before it's read`)
+}
+
+func TestFileSetSymlink(t *testing.T) {
+	f := &File{path: "config/app.yaml", mode: 0o644}
+	f.SetContents("hello, world")
+
+	assert.NilError(t, f.SetSymlink("../shared/app.yaml"), "failed to set symlink")
+	assert.Equal(t, f.SymlinkTarget(), "../shared/app.yaml")
+	assert.Equal(t, f.String(), "", "expected symlink to have no contents")
+	assert.Equal(t, f.Mode(), 0o644|os.ModeSymlink)
+	assert.Equal(t, f.Size(), int64(len("../shared/app.yaml")))
+
+	assert.ErrorContains(t, f.SetPath("app.yaml"), "points outside of the project")
+}
+
+func TestValidateSymlinkTarget(t *testing.T) {
+	tests := []struct {
+		path, target string
+		err          string
+	}{
+		{"link", "target", ""},
+		{"a/b/link", "../../target", ""},
+		{"a/link", "../b/../a/target", ""},
+		{"link", "", "must not be empty"},
+		{"link", "/etc/passwd", "must be relative"},
+		{"link", "..", "points outside of the project"},
+		{"a/link", "../../target", "points outside of the project"},
+		{"a/link", "b/../../../target", "points outside of the project"},
+	}
+	for _, tt := range tests {
+		err := validateSymlinkTarget(tt.path, tt.target)
+		if tt.err == "" {
+			assert.NilError(t, err, "%s -> %s", tt.path, tt.target)
+		} else {
+			assert.ErrorContains(t, err, tt.err, "%s -> %s", tt.path, tt.target)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements the filesystem of a project on
+// disk, and a filesystem that templates can share.
+
+package codegen
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+)
+
+// _ ensures that projectFS supports changing the mode of files, which
+// the underlying filesystem doesn't
+var _ billy.Change = &projectFS{}
+
+// projectFS is the filesystem of a project on disk. Every path is
+// relative to the root of the project, and paths that escape it are
+// rejected with billy.ErrCrossedBoundary.
+type projectFS struct {
+	billy.Filesystem
+}
+
+// NewProjectFS returns the filesystem of the project in dir, which all
+// files of the project should be read from and written to.
+func NewProjectFS(dir string) billy.Filesystem {
+	return &projectFS{osfs.New(dir)}
+}
+
+// path returns the path of name on disk, ensuring that it's inside of
+// the project
+func (fs *projectFS) path(name string) (string, error) {
+	if _, err := fs.Lstat(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(fs.Root(), name), nil
+}
+
+// Chmod changes the mode of the file at name
+func (fs *projectFS) Chmod(name string, mode os.FileMode) error {
+	p, err := fs.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(p, mode)
+}
+
+// Lchown changes the owner of the file at name, without following it
+// if it's a symlink
+func (fs *projectFS) Lchown(name string, uid, gid int) error {
+	p, err := fs.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Lchown(p, uid, gid)
+}
+
+// Chown changes the owner of the file at name
+func (fs *projectFS) Chown(name string, uid, gid int) error {
+	p, err := fs.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Chown(p, uid, gid)
+}
+
+// Chtimes changes the access and modification times of the file at name
+func (fs *projectFS) Chtimes(name string, atime, mtime time.Time) error {
+	p, err := fs.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(p, atime, mtime)
+}
+
+// Chroot returns the filesystem of the provided directory of the
+// project.
+func (fs *projectFS) Chroot(path string) (billy.Filesystem, error) {
+	sub, err := fs.Filesystem.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return &projectFS{sub}, nil
+}
+
+// lockedFS is a billy.Filesystem that serializes changes to the
+// underlying filesystem, so that it can be used by templates rendered
+// concurrently. Not every billy.Filesystem (e.g., memfs) is safe to use
+// concurrently on its own.
+type lockedFS struct {
+	fs billy.Filesystem
+
+	// mu is shared by every filesystem chrooted from the same one
+	mu *sync.RWMutex
+}
+
+// newLockedFS returns fs as a lockedFS, see lockedFS
+func newLockedFS(fs billy.Filesystem) billy.Filesystem {
+	if lfs, ok := fs.(*lockedFS); ok {
+		return lfs
+	}
+	return &lockedFS{fs, &sync.RWMutex{}}
+}
+
+// Create creates the file at filename
+func (fs *lockedFS) Create(filename string) (billy.File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.fs.Create(filename)
+}
+
+// Open opens the file at filename for reading
+func (fs *lockedFS) Open(filename string) (billy.File, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.fs.Open(filename)
+}
+
+// OpenFile opens the file at filename with the provided flags
+func (fs *lockedFS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.fs.OpenFile(filename, flag, perm)
+}
+
+// Stat returns information about the file at filename
+func (fs *lockedFS) Stat(filename string) (os.FileInfo, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.fs.Stat(filename)
+}
+
+// Rename moves the file at oldpath to newpath
+func (fs *lockedFS) Rename(oldpath, newpath string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.fs.Rename(oldpath, newpath)
+}
+
+// Remove removes the file, or empty directory, at filename
+func (fs *lockedFS) Remove(filename string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.fs.Remove(filename)
+}
+
+// Join joins the provided path elements
+func (fs *lockedFS) Join(elem ...string) string {
+	return fs.fs.Join(elem...)
+}
+
+// TempFile creates a new temporary file in dir
+func (fs *lockedFS) TempFile(dir, prefix string) (billy.File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.fs.TempFile(dir, prefix)
+}
+
+// ReadDir returns the contents of the directory at path
+func (fs *lockedFS) ReadDir(path string) ([]os.FileInfo, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.fs.ReadDir(path)
+}
+
+// MkdirAll creates the directory at filename, and its parents
+func (fs *lockedFS) MkdirAll(filename string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.fs.MkdirAll(filename, perm)
+}
+
+// Lstat returns information about the file at filename, without
+// following it if it's a symlink
+func (fs *lockedFS) Lstat(filename string) (os.FileInfo, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.fs.Lstat(filename)
+}
+
+// Symlink creates a symlink at link that points to target
+func (fs *lockedFS) Symlink(target, link string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.fs.Symlink(target, link)
+}
+
+// Readlink returns the target of the symlink at link
+func (fs *lockedFS) Readlink(link string) (string, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return fs.fs.Readlink(link)
+}
+
+// Chroot returns the filesystem of the provided directory, which shares
+// its lock with fs.
+func (fs *lockedFS) Chroot(path string) (billy.Filesystem, error) {
+	sub, err := fs.fs.Chroot(path)
+	if err != nil {
+		return nil, err
+	}
+	return &lockedFS{sub, fs.mu}, nil
+}
+
+// Root returns the root of the filesystem
+func (fs *lockedFS) Root() string {
+	return fs.fs.Root()
+}
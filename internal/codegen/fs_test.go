@@ -0,0 +1,51 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codegen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"gotest.tools/v3/assert"
+)
+
+func TestProjectFSChmod(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewProjectFS(dir)
+	assert.NilError(t, util.WriteFile(fs, "sub/file.txt", []byte("hello"), 0o644))
+
+	assert.NilError(t, fs.(billy.Change).Chmod("sub/file.txt", 0o600))
+	fi, err := os.Stat(filepath.Join(dir, "sub", "file.txt"))
+	assert.NilError(t, err)
+	assert.Equal(t, fi.Mode().Perm(), os.FileMode(0o600))
+
+	sub, err := fs.Chroot("sub")
+	assert.NilError(t, err)
+	assert.NilError(t, sub.(billy.Change).Chmod("file.txt", 0o640))
+	fi, err = os.Stat(filepath.Join(dir, "sub", "file.txt"))
+	assert.NilError(t, err)
+	assert.Equal(t, fi.Mode().Perm(), os.FileMode(0o640))
+}
+
+func TestProjectFSRejectsPathsOutsideOfProject(t *testing.T) {
+	fs := NewProjectFS(t.TempDir())
+
+	_, err := fs.Open("../outside.txt")
+	assert.ErrorIs(t, err, billy.ErrCrossedBoundary)
+	assert.ErrorIs(t, fs.(billy.Change).Chmod("../outside.txt", 0o600), billy.ErrCrossedBoundary)
+}
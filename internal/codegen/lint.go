@@ -0,0 +1,155 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements static checks of templates and
+// module arguments, used by "stencil lint".
+
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"go.rgst.io/stencil/internal/modules/nativeext"
+	"go.rgst.io/stencil/pkg/configuration"
+	"go.rgst.io/stencil/pkg/slogext"
+)
+
+// lintReceivers maps the template functions that return an object
+// with methods to the type of that object.
+var lintReceivers = map[string]reflect.Type{
+	"stencil":    reflect.TypeOf(&TplStencil{}),
+	"file":       reflect.TypeOf(&TplFile{}),
+	"extensions": reflect.TypeOf(&nativeext.ExtensionCaller{}),
+}
+
+// LintTemplate statically checks the contents of a template, returning
+// every problem found. The template is parsed with the same functions
+// that are available when rendering it, calls to methods that don't
+// exist on stencil, file or extensions are reported, and block markers
+// are checked the same way they are when reading blocks from the file
+// the template renders, using the provided custom comment styles.
+func LintTemplate(name string, contents []byte, commentStyles map[string][]*configuration.BlockCommentStyle,
+	log slogext.Logger) []error {
+	var errs []error
+
+	t, err := template.New(name).Funcs(NewFuncMap(nil, nil, log)).Parse(string(contents))
+	if err != nil {
+		// The parse tree isn't available if parsing failed.
+		errs = append(errs, err)
+	} else {
+		for _, tpl := range t.Templates() {
+			if tpl.Tree == nil || tpl.Tree.Root == nil {
+				continue
+			}
+			walkTemplate(tpl.Tree.Root, func(n parse.Node) {
+				if err := lintNode(tpl.Tree, n); err != nil {
+					errs = append(errs, err)
+				}
+			})
+		}
+	}
+
+	if _, err := parseBlocksFromReader(bytes.NewReader(contents), strings.TrimSuffix(name, ".tpl"), commentStyles); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// lintNode checks a single node of a template's parse tree
+func lintNode(tree *parse.Tree, n parse.Node) error {
+	chain, ok := n.(*parse.ChainNode)
+	if !ok {
+		return nil
+	}
+
+	ident, ok := chain.Node.(*parse.IdentifierNode)
+	if !ok || len(chain.Field) == 0 {
+		return nil
+	}
+
+	typ, ok := lintReceivers[ident.Ident]
+	if !ok {
+		return nil
+	}
+
+	if _, ok := typ.MethodByName(chain.Field[0]); !ok {
+		loc, _ := tree.ErrorContext(n)
+		return fmt.Errorf("%s: %s.%s is not a function", loc, ident.Ident, chain.Field[0])
+	}
+	return nil
+}
+
+// walkTemplate calls fn for every node in the parse tree rooted at n
+//
+//nolint:gocyclo // Why: it's a flat switch over the node types.
+func walkTemplate(n parse.Node, fn func(parse.Node)) {
+	if reflect.ValueOf(n).IsNil() {
+		return
+	}
+	fn(n)
+
+	switch n := n.(type) {
+	case *parse.ListNode:
+		for _, c := range n.Nodes {
+			walkTemplate(c, fn)
+		}
+	case *parse.ActionNode:
+		walkTemplate(n.Pipe, fn)
+	case *parse.PipeNode:
+		for _, c := range n.Cmds {
+			walkTemplate(c, fn)
+		}
+	case *parse.CommandNode:
+		for _, c := range n.Args {
+			walkTemplate(c, fn)
+		}
+	case *parse.ChainNode:
+		walkTemplate(n.Node, fn)
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, fn)
+	case *parse.TemplateNode:
+		walkTemplate(n.Pipe, fn)
+	}
+}
+
+// walkBranch calls fn for every node in the provided branch
+func walkBranch(n *parse.BranchNode, fn func(parse.Node)) {
+	walkTemplate(n.Pipe, fn)
+	walkTemplate(n.List, fn)
+	walkTemplate(n.ElseList, fn)
+}
+
+// ValidateArgumentDefault ensures that the default value of the
+// argument at pth is valid according to the argument's schema.
+func ValidateArgumentDefault(pth string, arg *configuration.Argument) error {
+	if arg.Default == nil || arg.Schema == nil {
+		return nil
+	}
+
+	schema, err := compileArgSchema(pth, arg)
+	if err != nil {
+		return err
+	}
+	return schema.Validate(arg.Default)
+}
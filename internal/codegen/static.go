@@ -0,0 +1,131 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements copying static files, from the
+// files/ directory of a module, into a project.
+
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// StaticFilesDir is the directory, relative to the root of a module,
+// containing files that are copied into a project byte-for-byte.
+const StaticFilesDir = "files"
+
+// renderStatic creates the file of a static template, see
+// Template.Static. The file is skipped if a rule in the manifest of the
+// module excludes it.
+func (t *Template) renderStatic(st *Stencil, vals *Values) error {
+	rel := strings.TrimPrefix(t.Path, filepath.Join("..", StaticFilesDir)+string(filepath.Separator))
+
+	// Static files can't contain blocks, so the existing file isn't
+	// read.
+	f := &File{fs: st.fs, path: t.Module.ApplyDirReplacements(rel), mode: t.mode, modTime: t.modTime}
+	f.contents = t.Contents
+	t.Files = []*File{f}
+
+	include, err := t.staticFileIncluded(st, vals, rel)
+	if err != nil {
+		return err
+	}
+	if !include {
+		f.Skipped = true
+		f.SkippedReason = "excluded by a files rule in the module manifest"
+	}
+
+	return nil
+}
+
+// staticFileIncluded returns true if the static file at rel, relative to
+// StaticFilesDir, should be copied according to the files rules in the
+// manifest of the module. Every rule that matches the file must render
+// its condition to true.
+func (t *Template) staticFileIncluded(st *Stencil, vals *Values, rel string) (bool, error) {
+	if t.Module.Manifest == nil {
+		return true, nil
+	}
+
+	for i, rule := range t.Module.Manifest.Files {
+		if rule == nil || !matchGlob(rule.Glob, filepath.ToSlash(rel)) || rule.If == "" {
+			continue
+		}
+
+		tpl, err := template.New(fmt.Sprintf("files[%d].if", i)).Funcs(NewFuncMap(st, t, t.log)).Parse(rule.If)
+		if err != nil {
+			return false, fmt.Errorf("failed to parse condition of files rule %q: %w", rule.Glob, err)
+		}
+
+		var buf bytes.Buffer
+		if err := tpl.Execute(&buf, vals.WithModule(t.Module.Name, t.Module.Version).WithTemplate(t.Path)); err != nil {
+			return false, fmt.Errorf("failed to render condition of files rule %q: %w", rule.Glob, err)
+		}
+
+		out := strings.TrimSpace(buf.String())
+		if out == "" {
+			return false, nil
+		}
+
+		include, err := strconv.ParseBool(out)
+		if err != nil {
+			return false, fmt.Errorf("condition of files rule %q rendered %q, expected true or false", rule.Glob, out)
+		}
+		if !include {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// matchGlob returns true if name matches pattern. Both are slash
+// separated paths. In addition to the syntax supported by path.Match, a
+// ** element matches zero or more path elements.
+func matchGlob(pattern, name string) bool {
+	return matchGlobParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// matchGlobParts implements matchGlob on the elements of a pattern and
+// a path
+func matchGlobParts(pattern, name []string) bool {
+	for len(pattern) != 0 {
+		if pattern[0] == "**" {
+			// Try to match the rest of the pattern against every suffix of
+			// name, including the empty one.
+			for i := 0; i <= len(name); i++ {
+				if matchGlobParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
@@ -7,57 +7,154 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"maps"
 	"math/rand"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	goruntime "runtime"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/util"
 	"github.com/mitchellh/hashstructure/v2"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.rgst.io/stencil/internal/lockfile"
 	"go.rgst.io/stencil/internal/modules"
 	"go.rgst.io/stencil/internal/modules/nativeext"
+	"go.rgst.io/stencil/internal/modules/resolver"
+	"go.rgst.io/stencil/internal/profile"
+	"go.rgst.io/stencil/internal/tracing"
 	"go.rgst.io/stencil/internal/version"
 	"go.rgst.io/stencil/pkg/configuration"
 	"go.rgst.io/stencil/pkg/extensions/apiv1"
 	"go.rgst.io/stencil/pkg/slogext"
-	"go.rgst.io/stencil/pkg/stencil"
 )
 
-// NewStencil creates a new, fully initialized Stencil renderer function
+// NewStencil creates a new, fully initialized Stencil renderer function.
+// The project is rendered in the current directory, see SetProjectFS.
 func NewStencil(m *configuration.Manifest, mods []*modules.Module, log slogext.Logger) *Stencil {
 	return &Stencil{
 		log:         log,
 		m:           m,
+		fs:          newLockedFS(NewProjectFS(".")),
+		gitDir:      ".",
 		ext:         nativeext.NewHost(log),
 		modules:     mods,
-		isFirstPass: true,
 		sharedData:  newSharedData(),
+		maxPasses:   DefaultMaxPasses,
+		concurrency: goruntime.GOMAXPROCS(0),
+		seed:        time.Now().UnixNano(),
+		postRunOut:  os.Stdout,
 	}
 }
 
+// DefaultMaxPasses is the default maximum number of passes templates are
+// rendered in while waiting for the module hooks and globals they write
+// to settle, see Stencil.Render.
+const DefaultMaxPasses = 10
+
 // Stencil provides the basic functions for
 // stencil templates
 type Stencil struct {
 	log slogext.Logger
 	m   *configuration.Manifest
 
+	// fs is the filesystem of the project being rendered, existing files
+	// are read from it. It's shared by templates rendered concurrently,
+	// see lockedFS.
+	fs billy.Filesystem
+
+	// gitDir is the directory git information about the project is read
+	// from, see SetGitDir
+	gitDir string
+
 	ext       *nativeext.Host
 	extCaller *nativeext.ExtensionCaller
 
 	// modules is a list of modules used in this stencil render
 	modules []*modules.Module
 
-	// isFirstPass denotes if the renderer is currently in first
-	// pass mode
-	isFirstPass bool
+	// pass is the current render pass, starting at 1, see Render
+	pass int
 
-	// sharedData is the store for module hook data and globals
+	// maxPasses is the maximum number of passes to render before giving
+	// up on the shared data settling
+	maxPasses int
+
+	// sharedData is the module hook data and globals written during the
+	// previous pass, which templates read
 	sharedData *sharedData
+
+	// nextSharedData is the module hook data and globals written during
+	// the current pass, it's nil when writes are ignored
+	nextSharedData *sharedData
+
+	// lock is the lockfile of the previous run of stencil, if any
+	lock *lockfile.Lockfile
+
+	// concurrency is the maximum number of templates that are rendered
+	// at the same time
+	concurrency int
+
+	// seed is the seed of the random order templates are rendered in
+	seed int64
+
+	// profiler records how long rendering takes, if set
+	profiler *profile.Profiler
+
+	// renderCache is the cache of the output of templates rendered
+	// previously, if any
+	renderCache *RenderCache
+
+	// moduleCacheInfo is the information about each module needed to
+	// compute render keys, it's nil when the render cache isn't used for
+	// the current render.
+	moduleCacheInfo map[*modules.Module]*moduleCacheInfo
+
+	// valsHash is the hash of the values passed to templates, without
+	// and with .Git
+	valsHash [2]string
+
+	// reused is the number of templates whose output was reused from the
+	// render cache in the last pass
+	reused int
+
+	// postRunOut is where the output of post-run commands is written to
+	postRunOut io.Writer
+
+	// postRunResults are the results of the post-run commands that were
+	// run by PostRun
+	postRunResults []*PostRunResult
+}
+
+// PostRunResult is the result of running a post-run command, see
+// Stencil.PostRun
+type PostRunResult struct {
+	// Module is the import path of the module the command belongs to
+	Module string
+
+	// Name is the name of the command
+	Name string
+
+	// Command is the command that was run
+	Command string
+
+	// Duration is how long the command ran for
+	Duration time.Duration
+
+	// ExitCode is the exit code of the command, or -1 if it couldn't be
+	// started or was killed
+	ExitCode int
+
+	// Err is the error the command failed with, if any
+	Err error
 }
 
 // hashModuleHookValue hashes the module hook value using the
@@ -75,6 +172,10 @@ func hashModuleHookValue(m any) uint64 {
 type moduleHook struct {
 	// values are the values available for this module hook
 	values []any
+
+	// templates are the import paths of the templates that wrote to
+	// this module hook
+	templates map[string]bool
 }
 
 // Sort sorts the module hook values by their hash
@@ -88,7 +189,8 @@ func (m *moduleHook) Sort() {
 // type (specifically the globals struct field) so that we can track not only the
 // value of the global but also the template it came from.
 type global struct {
-	// template is the template that defined this global (and is scoped too)
+	// template is the import path of the template that defined this
+	// global (and is scoped too)
 	template string
 
 	// value is the underlying value
@@ -98,6 +200,10 @@ type global struct {
 // sharedData stores data that is injected by templates from modules
 // for both module hooks and template module globals.
 type sharedData struct {
+	// mu protects moduleHooks and globals, which are written to by
+	// templates rendered concurrently
+	mu sync.RWMutex
+
 	moduleHooks map[string]*moduleHook
 	globals     map[string]global
 }
@@ -118,11 +224,58 @@ func (*sharedData) key(module, key string) string {
 	return path.Join(module, key)
 }
 
+// hashes returns the hash of each module hook and global, by "hook:" or
+// "global:" followed by its key. Module hooks must have been sorted.
+func (d *sharedData) hashes() map[string]string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	hashes := make(map[string]string, len(d.moduleHooks)+len(d.globals))
+	for k, h := range d.moduleHooks {
+		hashes["hook:"+k] = hashValue(h.values)
+	}
+	for k, g := range d.globals {
+		hashes["global:"+k] = hashValue(g.value)
+	}
+	return hashes
+}
+
+// writers returns the import paths of the templates that wrote the
+// module hook or global with the provided name, see hashes
+func (d *sharedData) writers(name string) []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	kind, k, _ := strings.Cut(name, ":")
+	switch kind {
+	case "hook":
+		if h := d.moduleHooks[k]; h != nil {
+			writers := make([]string, 0, len(h.templates))
+			for t := range h.templates {
+				writers = append(writers, t)
+			}
+			sort.Strings(writers)
+			return writers
+		}
+	case "global":
+		if g, ok := d.globals[k]; ok {
+			return []string{g.template}
+		}
+	}
+	return nil
+}
+
 // RegisterExtensions registers all extensions on the currently loaded
 // modules.
 func (s *Stencil) RegisterExtensions(ctx context.Context) error {
 	for _, m := range s.modules {
-		if err := m.RegisterExtensions(ctx, s.ext); err != nil {
+		done := s.profiler.Start("extensions", "RegisterExtensions "+m.Name)
+		ctx, span := tracing.Start(ctx, "stencil.RegisterExtensions",
+			tracing.ModuleKey.String(m.Name), tracing.VersionKey.String(m.Version.String()))
+		err := m.RegisterExtensions(ctx, s.ext)
+		tracing.End(span, err)
+		done()
+		if err != nil {
 			return errors.Wrapf(err, "failed to load extensions from module %q", m.Name)
 		}
 	}
@@ -137,10 +290,106 @@ func (s *Stencil) RegisterInprocExtensions(name string, ext apiv1.Implementation
 	s.ext.RegisterInprocExtension(name, ext)
 }
 
-// GenerateLockfile generates a stencil.Lockfile based
+// RegisterExtension registers the native extension from the provided
+// source, see nativeext.Host.RegisterExtension. This API is used to
+// render templates against an extension that isn't provided by one of
+// the loaded modules, e.g. when testing an extension.
+func (s *Stencil) RegisterExtension(ctx context.Context, source, name string, version *resolver.Version,
+	opts *nativeext.RegisterExtensionOpts) error {
+	return s.ext.RegisterExtension(ctx, source, name, version, opts)
+}
+
+// SetProjectFS sets the filesystem of the project being rendered, which
+// existing files are read from. By default, the current directory is
+// used. Git information about the project is read from the directory of
+// filesystems created with NewProjectFS, and isn't available for other
+// filesystems unless set with SetGitDir.
+func (s *Stencil) SetProjectFS(fs billy.Filesystem) {
+	s.gitDir = ""
+	if pfs, ok := fs.(*projectFS); ok {
+		s.gitDir = pfs.Root()
+	}
+	s.fs = newLockedFS(fs)
+}
+
+// SetGitDir sets the directory that git information about the project,
+// e.g. the current branch, is read from. By default, it's the directory
+// of the project's filesystem, see SetProjectFS. When empty, no git
+// information is available to templates.
+func (s *Stencil) SetGitDir(dir string) {
+	s.gitDir = dir
+}
+
+// SetRenderCache sets the cache that the output of templates is stored
+// in, and reused from when the inputs of a template didn't change since
+// it was stored. By default, every template is rendered.
+func (s *Stencil) SetRenderCache(c *RenderCache) {
+	s.renderCache = c
+}
+
+// SetSeed sets the seed of the random order templates are rendered in,
+// by default the current time is used. Templates are also rendered one
+// at a time from then on, so that a render with the same seed renders
+// them in exactly the same order.
+func (s *Stencil) SetSeed(seed int64) {
+	s.seed = seed
+	s.concurrency = 1
+}
+
+// SetProfiler sets the profiler that records how long registering
+// extensions, parsing and rendering templates, calling extensions and
+// running post-run commands takes. By default nothing is recorded.
+func (s *Stencil) SetProfiler(p *profile.Profiler) {
+	s.profiler = p
+}
+
+// SetPostRunOutput sets where the output of post-run commands is
+// written to, by default stdout. Their errors are always written to
+// stderr.
+func (s *Stencil) SetPostRunOutput(w io.Writer) {
+	s.postRunOut = w
+}
+
+// PostRunResults returns the results of the post-run commands run by
+// PostRun, including the one that failed, if any
+func (s *Stencil) PostRunResults() []*PostRunResult {
+	return s.postRunResults
+}
+
+// Seed returns the seed of the random order templates are rendered in,
+// see SetSeed
+func (s *Stencil) Seed() int64 {
+	return s.seed
+}
+
+// SetLockfile sets the lockfile generated by the previous run of
+// stencil, which is used to determine which modules changed version
+// since then.
+func (s *Stencil) SetLockfile(l *lockfile.Lockfile) {
+	s.lock = l
+}
+
+// moduleVersionChanged returns true if the version of the provided
+// module differs from its version in the lockfile of the previous run.
+// Modules that weren't used in the previous run, or when there is no
+// previous run, are not considered changed.
+func (s *Stencil) moduleVersionChanged(m *modules.Module) bool {
+	if s == nil || s.lock == nil || m == nil {
+		return false
+	}
+
+	for _, lm := range s.lock.Modules {
+		if lm.Name == m.Name {
+			return !lm.Version.Equal(m.Version)
+		}
+	}
+	return false
+}
+
+// GenerateLockfile generates a lockfile.Lockfile based
 // on a list of templates.
-func (s *Stencil) GenerateLockfile(tpls []*Template) *stencil.Lockfile {
-	l := &stencil.Lockfile{
+func (s *Stencil) GenerateLockfile(tpls []*Template) *lockfile.Lockfile {
+	l := &lockfile.Lockfile{
 		Version: version.Version,
 	}
 
@@ -151,16 +400,24 @@ func (s *Stencil) GenerateLockfile(tpls []*Template) *stencil.Lockfile {
 				continue
 			}
 
-			l.Files = append(l.Files, &stencil.LockfileFileEntry{
+			entry := &lockfile.FileEntry{
 				Name:     f.Name(),
 				Template: tpl.Path,
 				Module:   tpl.Module.Name,
-			})
+			}
+			// The permissions of symlinks are meaningless, so only their
+			// target is recorded.
+			if target := f.SymlinkTarget(); target != "" {
+				entry.Symlink = target
+			} else if mode := fmt.Sprintf("%#o", f.Mode().Perm()); mode != lockfile.DefaultFileMode {
+				entry.Mode = mode
+			}
+			l.Files = append(l.Files, entry)
 		}
 	}
 
 	for _, m := range s.modules {
-		l.Modules = append(l.Modules, &stencil.LockfileModuleEntry{
+		l.Modules = append(l.Modules, &lockfile.ModuleEntry{
 			Name:    m.Name,
 			URL:     m.URI,
 			Version: m.Version,
@@ -179,6 +436,28 @@ func (s *Stencil) GenerateLockfile(tpls []*Template) *stencil.Lockfile {
 	return l
 }
 
+// moduleHookValues returns the values of the module hook with the
+// provided key, see sharedData.key
+func (s *Stencil) moduleHookValues(k string) []any {
+	s.sharedData.mu.RLock()
+	defer s.sharedData.mu.RUnlock()
+
+	if v := s.sharedData.moduleHooks[k]; v != nil {
+		return v.values
+	}
+	return nil
+}
+
+// globalValue returns the global with the provided key, see
+// sharedData.key
+func (s *Stencil) globalValue(k string) (global, bool) {
+	s.sharedData.mu.RLock()
+	defer s.sharedData.mu.RUnlock()
+
+	v, ok := s.sharedData.globals[k]
+	return v, ok
+}
+
 // sortModuleHooks sorts the module hooks by their hash
 func (s *Stencil) sortModuleHooks() {
 	for _, m := range s.sharedData.moduleHooks {
@@ -186,10 +465,104 @@ func (s *Stencil) sortModuleHooks() {
 	}
 }
 
+// renderPasses renders the templates in passes until the module hooks
+// and globals they write stop changing. Templates read the data written
+// during the previous pass, so once a pass writes the same data it read,
+// its templates were rendered with complete data and their files are
+// the output. This allows module hooks to depend on other module hooks.
+//
+// An error is returned when the data doesn't settle within s.maxPasses
+// passes, or when it changes back to the data of an earlier pass, since
+// it would never settle then.
+func (s *Stencil) renderPasses(ctx context.Context, log slogext.Logger, tpls []*Template, vals *Values) error {
+	// seen are the hashes of the data read in each pass
+	seen := []map[string]string{s.sharedData.hashes()}
+	for s.pass = 1; ; s.pass++ {
+		// Directory replacements are rendered as templates, so they can
+		// read shared data too.
+		if err := s.calcDirReplacements(vals); err != nil {
+			return err
+		}
+
+		for _, t := range tpls {
+			// Remove the files of the previous pass
+			t.Files = nil
+		}
+
+		s.nextSharedData = newSharedData()
+		pctx, span := tracing.Start(ctx, "stencil.RenderPass", tracing.PassKey.Int(s.pass))
+		err := s.renderTemplates(pctx, log, fmt.Sprintf("Pass %d", s.pass), tpls, vals)
+		tracing.End(span, err)
+		if err != nil {
+			return err
+		}
+		prev := s.sharedData
+		s.sharedData, s.nextSharedData = s.nextSharedData, nil
+
+		// Sort module hook data before it's compared and read
+		s.sortModuleHooks()
+
+		hashes := s.sharedData.hashes()
+		if maps.Equal(hashes, seen[len(seen)-1]) {
+			log.Debugf("Module hooks and globals settled after %d pass(es)", s.pass)
+			return nil
+		}
+
+		for i := range seen[:len(seen)-1] {
+			if maps.Equal(hashes, seen[i]) {
+				return fmt.Errorf("module hooks and globals written in pass %d are the same as the ones read in pass %d, "+
+					"they will never settle. Templates keep changing them:\n  %s",
+					s.pass, i+1, strings.Join(sharedDataChanges(prev, seen[len(seen)-1], s.sharedData, hashes), "\n  "))
+			}
+		}
+		if s.pass >= s.maxPasses {
+			return fmt.Errorf("module hooks and globals didn't settle after %d passes, templates keep changing them:\n  %s",
+				s.pass, strings.Join(sharedDataChanges(prev, seen[len(seen)-1], s.sharedData, hashes), "\n  "))
+		}
+		seen = append(seen, hashes)
+	}
+}
+
+// sharedDataChanges describes the module hooks and globals that changed
+// from prev, whose hashes are prevHashes, to next, whose hashes are
+// nextHashes, along with the templates that wrote them.
+func sharedDataChanges(prev *sharedData, prevHashes map[string]string,
+	next *sharedData, nextHashes map[string]string) []string {
+	names := make([]string, 0, len(nextHashes))
+	for name, hash := range nextHashes {
+		if prevHashes[name] != hash {
+			names = append(names, name)
+		}
+	}
+	for name := range prevHashes {
+		if _, ok := nextHashes[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := make([]string, 0, len(names))
+	for _, name := range names {
+		kind, k, _ := strings.Cut(name, ":")
+		if writers := next.writers(name); len(writers) > 0 {
+			changes = append(changes, fmt.Sprintf("%s %s: changed by %s", kind, k, strings.Join(writers, ", ")))
+		} else {
+			changes = append(changes, fmt.Sprintf("%s %s: no longer written by %s", kind, k,
+				strings.Join(prev.writers(name), ", ")))
+		}
+	}
+	return changes
+}
+
 // Render renders all templates using the Manifest that was
 // provided to stencil at creation time, returned is the templates
-// that were produced and their associated files.
-func (s *Stencil) Render(ctx context.Context, log slogext.Logger) ([]*Template, error) {
+// that were produced and their associated files. Templates are rendered
+// until the module hooks and globals they write settle, see
+// renderPasses.
+func (s *Stencil) Render(ctx context.Context, log slogext.Logger) (_ []*Template, err error) {
+	ctx, span := tracing.Start(ctx, "stencil.Render")
+	defer func() { tracing.End(span, err) }()
+
 	tplfiles, err := s.getTemplates(ctx, log)
 	if err != nil {
 		return nil, err
@@ -198,51 +571,152 @@ func (s *Stencil) Render(ctx context.Context, log slogext.Logger) ([]*Template,
 	if s.extCaller, err = s.ext.GetExtensionCaller(ctx); err != nil {
 		return nil, err
 	}
+	s.extCaller.SetProfiler(s.profiler)
 
 	log.Debug("Creating values for template")
-	vals := NewValues(ctx, s.m, s.modules)
+	vals := NewValues(ctx, s.m, s.modules, s.gitDir)
 	log.Debug("Finished creating values")
 
 	// Add the templates to their modules template to allow them to be able to access
 	// functions declared in the same module
 	for _, t := range tplfiles {
 		log.Debugf("Parsing template %s", t.ImportPath())
-		if err := t.Parse(s); err != nil {
+		done := s.profiler.Start("parse", t.ImportPath())
+		err := t.Parse(s)
+		done()
+		if err != nil {
 			return nil, errors.Wrapf(err, "failed to parse template %q", t.ImportPath())
 		}
 	}
+	s.prepareRenderCache(tplfiles, vals)
 
-	// Render the first pass, this is used to populate shared data
-	for _, t := range tplfiles {
-		log.Debugf("First pass render of template %s", t.ImportPath())
-		if err := t.Render(s, vals); err != nil {
-			return nil, errors.Wrapf(err, "failed to render template %q", t.ImportPath())
-		}
+	if err := s.renderPasses(ctx, log, tplfiles, vals); err != nil {
+		return nil, err
+	}
 
-		// Remove the files, we're just using this to populate the shared data.
-		t.Files = nil
+	if err := s.checkOrphanedBlocks(tplfiles); err != nil {
+		return nil, err
 	}
-	s.isFirstPass = false
 
-	// Sort module hook data before the next pass
-	s.sortModuleHooks()
+	if s.moduleCacheInfo != nil {
+		log.Infof("Reused the output of %d of %d template(s) from the render cache", s.reused, len(tplfiles))
+	}
+	return tplfiles, nil
+}
 
-	if err := s.calcDirReplacements(vals); err != nil {
-		return nil, err
+// renderTemplates renders the provided templates concurrently, at most
+// s.concurrency at a time. Templates only read the shared data written
+// during the previous pass, so the order templates are rendered in
+// doesn't change the output. When templates fail to render, the
+// error of the one with the lowest import path is returned so that the
+// error doesn't depend on scheduling either.
+func (s *Stencil) renderTemplates(ctx context.Context, log slogext.Logger, pass string, tpls []*Template,
+	vals *Values) error {
+	concurrency := s.concurrency
+	if concurrency < 1 {
+		concurrency = 1
 	}
 
-	tpls := make([]*Template, 0)
-	for _, t := range tplfiles {
-		log.Debugf("Second pass render of template %s", t.ImportPath())
-		if err := t.Render(s, vals); err != nil {
-			return nil, errors.Wrapf(err, "failed to render template %q", t.ImportPath())
+	errs := make([]error, len(tpls))
+	reused := make([]bool, len(tpls))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, t := range tpls {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			log.Debugf("%s render of template %s", pass, t.ImportPath())
+			var span trace.Span
+			t.ctx, span = tracing.Start(ctx, "stencil.RenderTemplate",
+				tracing.ModuleKey.String(t.Module.Name),
+				tracing.VersionKey.String(t.Module.Version.String()),
+				tracing.TemplateKey.String(t.Path),
+				tracing.PassKey.Int(s.pass),
+			)
+			var err error
+			done := s.profiler.Start("render", fmt.Sprintf("%s (pass %d)", t.ImportPath(), s.pass))
+			reused[i], err = s.renderCached(t, vals)
+			done()
+			span.SetAttributes(attribute.Bool("stencil.reused", reused[i]))
+			tracing.End(span, err)
+			if err != nil {
+				errs[i] = errors.Wrapf(err, "failed to render template %q", t.ImportPath())
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Only count the output that was reused from the last pass
+	s.reused = 0
+	for i := range reused {
+		if reused[i] {
+			s.reused++
 		}
+	}
 
-		// append the rendered template to our list of templates processed
-		tpls = append(tpls, t)
+	var failed *Template
+	var err error
+	for i, t := range tpls {
+		if errs[i] != nil && (failed == nil || t.ImportPath() < failed.ImportPath()) {
+			failed, err = t, errs[i]
+		}
 	}
+	return err
+}
 
-	return tpls, nil
+// OrphanedBlocksSuffix is the suffix of the sidecar file that preserves
+// the contents of orphaned blocks, see
+// configuration.OrphanedBlocksPreserve.
+const OrphanedBlocksSuffix = ".stencil-orphaned"
+
+// checkOrphanedBlocks handles the blocks of the rendered files that
+// weren't read by their template according to the orphaned blocks
+// policy of the project, either by returning an error or by adding a
+// warning to the file.
+func (s *Stencil) checkOrphanedBlocks(tpls []*Template) error {
+	policy := s.m.OrphanedBlocks
+	if policy == "" {
+		policy = configuration.OrphanedBlocksWarn
+	}
+
+	var errs []string
+	for _, t := range tpls {
+		for _, f := range t.Files {
+			if f.Skipped || f.Deleted {
+				continue
+			}
+
+			names := f.OrphanedBlocks()
+			if len(names) == 0 {
+				continue
+			}
+
+			switch policy {
+			case configuration.OrphanedBlocksFail:
+				errs = append(errs, fmt.Sprintf("%s (template %q): %s", f.Name(), t.ImportPath(), strings.Join(names, ", ")))
+			case configuration.OrphanedBlocksWarn:
+				f.Warnings = append(f.Warnings,
+					fmt.Sprintf("contents of orphaned block(s) %s will be lost", strings.Join(names, ", ")))
+			case configuration.OrphanedBlocksPreserve:
+				f.Warnings = append(f.Warnings, fmt.Sprintf("contents of orphaned block(s) %s were preserved in %s",
+					strings.Join(names, ", "), f.Name()+OrphanedBlocksSuffix))
+			default:
+				return fmt.Errorf("unknown orphanedBlocks policy %q", policy)
+			}
+		}
+	}
+
+	if len(errs) != 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("found block(s) that are no longer rendered by their template but contain data, "+
+			"use file.MigrateBlock when renaming blocks:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
 }
 
 // calcDirReplacements calculates all of the final rendered paths for dirReplacements for each module
@@ -284,7 +758,7 @@ func (s *Stencil) renderDirReplacement(template string, m *modules.Module, vals
 }
 
 // PostRun runs all post run commands specified in the modules that
-// this project depends on
+// this project depends on, in the root of the project filesystem
 func (s *Stencil) PostRun(ctx context.Context, log slogext.Logger) error {
 	log.Info("Running post-run command(s)")
 	for _, m := range s.modules {
@@ -292,10 +766,31 @@ func (s *Stencil) PostRun(ctx context.Context, log slogext.Logger) error {
 			log.Infof(" - %s", cmdStr.Name)
 			//nolint:gosec // Why: This is by design
 			cmd := exec.CommandContext(ctx, "/usr/bin/env", "bash", "-c", cmdStr.Command)
+			cmd.Dir = s.fs.Root()
 			cmd.Stdin = os.Stdin
 			cmd.Stderr = os.Stderr
-			cmd.Stdout = os.Stdout
-			if err := cmd.Run(); err != nil {
+			cmd.Stdout = s.postRunOut
+			done := s.profiler.Start("post-run", m.Name+": "+cmdStr.Name)
+			_, span := tracing.Start(ctx, "stencil.PostRun",
+				tracing.ModuleKey.String(m.Name), attribute.String("stencil.post_run.command", cmdStr.Name))
+			started := time.Now()
+			err := cmd.Run()
+			res := &PostRunResult{
+				Module:   m.Name,
+				Name:     cmdStr.Name,
+				Command:  cmdStr.Command,
+				Duration: time.Since(started),
+				ExitCode: -1,
+				Err:      err,
+			}
+			if cmd.ProcessState != nil {
+				res.ExitCode = cmd.ProcessState.ExitCode()
+				span.SetAttributes(attribute.Int("process.exit.code", res.ExitCode))
+			}
+			s.postRunResults = append(s.postRunResults, res)
+			tracing.End(span, err)
+			done()
+			if err != nil {
 				return errors.Wrapf(err, "failed to run post run command for module %q", m.Name)
 			}
 		}
@@ -322,6 +817,12 @@ func (s *Stencil) getTemplates(ctx context.Context, log slogext.Logger) ([]*Temp
 			continue
 		}
 
+		staticTpls, err := s.getStaticFiles(m, fs, log)
+		if err != nil {
+			return nil, err
+		}
+		tpls = append(tpls, staticTpls...)
+
 		log.Debugf("Discovering templates from module %q", m.Name)
 
 		// Only find templates in the templates/ directory
@@ -367,16 +868,57 @@ func (s *Stencil) getTemplates(ctx context.Context, log slogext.Logger) ([]*Temp
 
 	log.Debug("Finished discovering templates")
 
+	// Start from a stable order, so that the order only depends on the
+	// seed. Modules aren't always resolved in the same order.
+	sort.Slice(tpls, func(i, j int) bool { return tpls[i].ImportPath() < tpls[j].ImportPath() })
+
 	// Shuffle the templates to prevent accidental file order guarantees
-	// from being relied upon.
+	// from being relied upon. The seed is reported when rendering fails,
+	// so that the order can be reproduced.
 	//nolint:gosec // Why: We don't need that much entropy.
-	rand.New(rand.NewSource(time.Now().UnixNano())).Shuffle(len(tpls), func(i, j int) {
+	rand.New(rand.NewSource(s.seed)).Shuffle(len(tpls), func(i, j int) {
 		tpls[i], tpls[j] = tpls[j], tpls[i]
 	})
 
 	return tpls, nil
 }
 
+// getStaticFiles returns a static template (see Template.Static) for
+// every file in the StaticFilesDir directory of the provided module.
+func (s *Stencil) getStaticFiles(m *modules.Module, fs billy.Filesystem, log slogext.Logger) ([]*Template, error) {
+	if _, err := fs.Stat(StaticFilesDir); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	log.Debugf("Discovering static files from module %q", m.Name)
+
+	var tpls []*Template
+	err := util.Walk(fs, StaticFilesDir, func(path string, inf os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if inf.IsDir() {
+			return nil
+		}
+
+		contents, err := util.ReadFile(fs, path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read static file %q from module %q", path, m.Name)
+		}
+
+		log.Debugf("Discovered static file %q", path)
+		tpl, err := NewTemplate(m, filepath.Join("..", path), inf.Mode(), inf.ModTime(), contents, log)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create static file %q from module %q", path, m.Name)
+		}
+		tpl.Static, tpl.Library = true, false
+		tpls = append(tpls, tpl)
+
+		return nil
+	})
+	return tpls, err
+}
+
 // Close closes all resources that should be closed when done
 // rendering templates.
 func (s *Stencil) Close() error {
@@ -1,19 +1,31 @@
 package codegen
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"os"
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"go.rgst.io/stencil/internal/lockfile"
 	"go.rgst.io/stencil/internal/modules"
 	"go.rgst.io/stencil/internal/modules/modulestest"
+	"go.rgst.io/stencil/internal/modules/nativeext/apiv1"
 	"go.rgst.io/stencil/internal/modules/resolver"
+	"go.rgst.io/stencil/internal/profile"
 	"go.rgst.io/stencil/internal/version"
 	"go.rgst.io/stencil/pkg/configuration"
 	"go.rgst.io/stencil/pkg/slogext"
-	"go.rgst.io/stencil/pkg/stencil"
 	"gotest.tools/v3/assert"
 )
 
@@ -47,20 +59,21 @@ func TestBasicE2ERender(t *testing.T) {
 	assert.Equal(t, tpls[0].Files[0].String(), "test", "expected Render() to return correct output")
 
 	lock := st.GenerateLockfile(tpls)
-	assert.DeepEqual(t, lock, &stencil.Lockfile{
+	assert.DeepEqual(t, lock, &lockfile.Lockfile{
 		Version: version.Version,
-		Modules: []*stencil.LockfileModuleEntry{
+		Modules: []*lockfile.ModuleEntry{
 			{
 				Name:    "testing",
 				URL:     "vfs://testing",
 				Version: &resolver.Version{Virtual: "vfs"},
 			},
 		},
-		Files: []*stencil.LockfileFileEntry{
+		Files: []*lockfile.FileEntry{
 			{
 				Name:     "test-template",
 				Template: "test-template.tpl",
 				Module:   "testing",
+				Mode:     "0666",
 			},
 		},
 	})
@@ -139,7 +152,567 @@ func TestBadDirReplacement(t *testing.T) {
 	assert.NilError(t, err, "failed to NewModuleFromTemplates")
 
 	st := NewStencil(sm, []*modules.Module{m}, log)
-	vals := NewValues(context.Background(), sm, nil)
+	vals := NewValues(context.Background(), sm, nil, "")
 	_, err = st.renderDirReplacement("b/c", m, vals)
 	assert.ErrorContains(t, err, "contains path separator in output")
 }
+
+// greetExtension is an extension with a single typed function
+type greetExtension struct{}
+
+// GetConfig implements apiv1.Implementation
+func (*greetExtension) GetConfig() (*apiv1.Config, error) {
+	return &apiv1.Config{}, nil
+}
+
+// GetTemplateFunctions implements apiv1.Implementation
+func (*greetExtension) GetTemplateFunctions() ([]*apiv1.TemplateFunction, error) {
+	return []*apiv1.TemplateFunction{{
+		Name:      "Greet",
+		Arguments: []apiv1.TemplateFunctionArgument{{Name: "name", Type: apiv1.ArgumentTypeString}},
+	}}, nil
+}
+
+// ExecuteTemplateFunction implements apiv1.Implementation
+func (*greetExtension) ExecuteTemplateFunction(t *apiv1.TemplateFunctionExec) (interface{}, error) {
+	return "hello " + t.Arguments[0].(string), nil
+}
+
+func TestExtensionArgumentErrorsNameTemplate(t *testing.T) {
+	log := slogext.NewTestLogger(t)
+	sm := &configuration.Manifest{Name: "testing"}
+	m1man := &configuration.TemplateRepositoryManifest{Name: "testing1"}
+	m, err := modulestest.NewModuleFromTemplates(m1man, "testdata/extensions/bad-call.tpl")
+	assert.NilError(t, err, "failed to NewModuleFromTemplates")
+
+	st := NewStencil(sm, []*modules.Module{m}, log)
+	st.RegisterInprocExtensions("test", &greetExtension{})
+
+	_, err = st.Render(context.Background(), log)
+	assert.ErrorContains(t, err, "bad-call.tpl")
+	assert.ErrorContains(t, err, `invalid call to template function "test.Greet": argument 1 ("name"): expected string, got int`)
+}
+
+func TestModuleVersionChanged(t *testing.T) {
+	st := NewStencil(&configuration.Manifest{Name: "test"}, nil, slogext.NewTestLogger(t))
+	m := &modules.Module{Name: "testing", Version: &resolver.Version{Tag: "v1.1.0"}}
+	assert.Equal(t, st.moduleVersionChanged(m), false, "expected no lockfile to not be a change")
+
+	st.SetLockfile(&lockfile.Lockfile{
+		Modules: []*lockfile.ModuleEntry{{Name: "testing", Version: &resolver.Version{Tag: "v1.0.0"}}},
+	})
+	assert.Equal(t, st.moduleVersionChanged(m), true, "expected a different version to be a change")
+
+	m.Version = &resolver.Version{Tag: "v1.0.0"}
+	assert.Equal(t, st.moduleVersionChanged(m), false, "expected the same version to not be a change")
+
+	other := &modules.Module{Name: "other", Version: &resolver.Version{Tag: "v1.0.0"}}
+	assert.Equal(t, st.moduleVersionChanged(other), false, "expected a new module to not be a change")
+}
+
+func TestBlockDefaultRender(t *testing.T) {
+	fs := memfs.New()
+	ctx := context.Background()
+	log := slogext.NewTestLogger(t)
+
+	f, _ := fs.Create("manifest.yaml")
+	f.Write([]byte("name: testing"))
+	f.Close()
+
+	f, err := fs.Create("templates/test-template.tpl")
+	assert.NilError(t, err, "failed to create stub template")
+	f.Write([]byte("// <<Stencil::Block(name, default=reset)>>\n{{ file.Block \"name\" \"hello\" }}\n// <</Stencil::Block>>\n"))
+	f.Close()
+
+	tp, err := modulestest.NewWithFS(ctx, "testing", fs)
+	assert.NilError(t, err, "failed to NewWithFS")
+	st := NewStencil(&configuration.Manifest{
+		Name:      "test",
+		Arguments: map[string]any{},
+	}, []*modules.Module{tp}, log)
+
+	tpls, err := st.Render(ctx, log)
+	assert.NilError(t, err, "expected Render() to not fail")
+	assert.Equal(t, tpls[0].Files[0].String(),
+		"// <<Stencil::Block(name, default=reset)>>\nhello\n// <</Stencil::Block>>\n",
+		"expected Render() to use the block default")
+}
+
+func TestCheckOrphanedBlocks(t *testing.T) {
+	newTemplates := func() []*Template {
+		f, err := NewFile(osfs.New("."), "testdata/orphanedblocks-test.txt", 0o644, time.Now(), nil)
+		assert.NilError(t, err, "failed to create file")
+		f.Block("kept")
+		f.Block("renamed")
+		f.Block("outer")
+		return []*Template{{Path: "test.tpl", Module: &modules.Module{Name: "testing"}, Files: []*File{f}}}
+	}
+
+	// Warning is the default policy
+	st := NewStencil(&configuration.Manifest{Name: "test"}, nil, slogext.NewTestLogger(t))
+	tpls := newTemplates()
+	assert.NilError(t, st.checkOrphanedBlocks(tpls))
+	assert.DeepEqual(t, tpls[0].Files[0].Warnings, []string{"contents of orphaned block(s) dropped will be lost"})
+
+	st.m.OrphanedBlocks = configuration.OrphanedBlocksPreserve
+	tpls = newTemplates()
+	assert.NilError(t, st.checkOrphanedBlocks(tpls))
+	assert.DeepEqual(t, tpls[0].Files[0].Warnings, []string{
+		"contents of orphaned block(s) dropped were preserved in testdata/orphanedblocks-test.txt.stencil-orphaned",
+	})
+
+	st.m.OrphanedBlocks = configuration.OrphanedBlocksFail
+	assert.ErrorContains(t, st.checkOrphanedBlocks(newTemplates()),
+		`testdata/orphanedblocks-test.txt (template "testing/test.tpl"): dropped`)
+}
+
+func TestStaticFilesRender(t *testing.T) {
+	fs := memfs.New()
+	ctx := context.Background()
+	log := slogext.NewTestLogger(t)
+
+	mf := `name: testing
+arguments:
+  docs:
+    schema:
+      type: boolean
+dirReplacements:
+  assets: '{{ "images" }}'
+files:
+  - glob: docs/**
+    if: '{{ stencil.Arg "docs" }}'
+`
+	assert.NilError(t, util.WriteFile(fs, "manifest.yaml", []byte(mf), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/.keep", nil, 0o644))
+	assert.NilError(t, util.WriteFile(fs, "files/bin/tool", []byte("#!/bin/sh\n"), 0o755))
+	assert.NilError(t, util.WriteFile(fs, "files/assets/logo.png", []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}, 0o644))
+	assert.NilError(t, util.WriteFile(fs, "files/docs/guide/index.md", []byte("{{ not a template }}"), 0o644))
+
+	tp, err := modulestest.NewWithFS(ctx, "testing", fs)
+	assert.NilError(t, err, "failed to NewWithFS")
+	st := NewStencil(&configuration.Manifest{
+		Name:      "test",
+		Arguments: map[string]any{"docs": false},
+	}, []*modules.Module{tp}, log)
+
+	tpls, err := st.Render(ctx, log)
+	assert.NilError(t, err, "expected Render() to not fail")
+
+	files := make(map[string]*File)
+	for _, tpl := range tpls {
+		assert.Assert(t, tpl.Static, "expected only static files")
+		for _, f := range tpl.Files {
+			files[f.Name()] = f
+		}
+	}
+	assert.Equal(t, len(files), 3)
+	assert.Equal(t, files["bin/tool"].String(), "#!/bin/sh\n")
+	assert.Equal(t, files["bin/tool"].Mode().Perm(), os.FileMode(0o755))
+	assert.DeepEqual(t, files["images/logo.png"].Bytes(), []byte{0x89, 'P', 'N', 'G', 0x00, 0xff})
+	assert.Equal(t, files["docs/guide/index.md"].String(), "{{ not a template }}")
+	assert.Assert(t, files["docs/guide/index.md"].Skipped, "expected docs to be skipped")
+
+	lock := st.GenerateLockfile(tpls)
+	assert.DeepEqual(t, lock.Files, []*lockfile.FileEntry{
+		{Name: "bin/tool", Template: "../files/bin/tool", Module: "testing", Mode: "0755"},
+		{Name: "images/logo.png", Template: "../files/assets/logo.png", Module: "testing"},
+	})
+}
+
+func TestStaticFilesDontCollideWithTemplates(t *testing.T) {
+	fs := memfs.New()
+	ctx := context.Background()
+	log := slogext.NewTestLogger(t)
+
+	// The static file has the same path as the template, relative to
+	// their directories
+	assert.NilError(t, util.WriteFile(fs, "manifest.yaml", []byte("name: testing"), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/files/x.tpl", []byte("template"), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "files/x.tpl", []byte("static"), 0o644))
+
+	tp, err := modulestest.NewWithFS(ctx, "testing", fs)
+	assert.NilError(t, err, "failed to NewWithFS")
+	st := NewStencil(&configuration.Manifest{Name: "test"}, []*modules.Module{tp}, log)
+
+	tpls, err := st.Render(ctx, log)
+	assert.NilError(t, err, "expected Render() to not fail")
+	assert.Equal(t, len(tpls), 2)
+
+	byImportPath := make(map[string]*Template)
+	for _, tpl := range tpls {
+		byImportPath[tpl.ImportPath()] = tpl
+	}
+	assert.Equal(t, len(byImportPath), 2, "expected the template and static file to have different import paths")
+	assert.Equal(t, byImportPath["testing/files/x.tpl"].Files[0].String(), "template")
+	assert.Equal(t, byImportPath["testing/../files/x.tpl"].Files[0].String(), "static")
+
+	lock := st.GenerateLockfile(tpls)
+	assert.DeepEqual(t, lock.Files, []*lockfile.FileEntry{
+		{Name: "files/x", Template: "files/x.tpl", Module: "testing"},
+		{Name: "x.tpl", Template: "../files/x.tpl", Module: "testing"},
+	})
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.png", "logo.png", true},
+		{"*.png", "images/logo.png", false},
+		{"**/*.png", "logo.png", true},
+		{"**/*.png", "images/icons/logo.png", true},
+		{"images/**", "images/icons/logo.png", true},
+		{"images/**", "docs/logo.png", false},
+		{"images/**/logo.png", "images/logo.png", true},
+		{"images/[a-z]*.png", "images/logo.png", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, matchGlob(tt.pattern, tt.name), tt.want, "%s %s", tt.pattern, tt.name)
+	}
+}
+
+func TestSetModeRender(t *testing.T) {
+	fs := memfs.New()
+	ctx := context.Background()
+	log := slogext.NewTestLogger(t)
+
+	assert.NilError(t, util.WriteFile(fs, "manifest.yaml", []byte("name: testing"), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/private.tpl", []byte("{{ file.SetMode 0600 }}"), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/script.sh.tpl", []byte("{{ file.SetExecutable }}"), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/computed.tpl", []byte("{{ file.SetMode (add 0600 040) }}"), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/invalid.tpl", []byte("{{ file.SetMode 01000755 }}"), 0o644))
+
+	tp, err := modulestest.NewWithFS(ctx, "testing", fs)
+	assert.NilError(t, err, "failed to NewWithFS")
+	st := NewStencil(&configuration.Manifest{
+		Name:      "test",
+		Arguments: map[string]any{},
+	}, []*modules.Module{tp}, log)
+
+	_, err = st.Render(ctx, log)
+	assert.ErrorContains(t, err, "invalid mode 01000755, it must be at most 07777")
+
+	assert.NilError(t, fs.Remove("templates/invalid.tpl"))
+	tp, err = modulestest.NewWithFS(ctx, "testing", fs)
+	assert.NilError(t, err, "failed to NewWithFS")
+	st = NewStencil(&configuration.Manifest{
+		Name:      "test",
+		Arguments: map[string]any{},
+	}, []*modules.Module{tp}, log)
+
+	tpls, err := st.Render(ctx, log)
+	assert.NilError(t, err, "expected Render() to not fail")
+
+	lock := st.GenerateLockfile(tpls)
+	assert.DeepEqual(t, lock.Files, []*lockfile.FileEntry{
+		{Name: "computed", Template: "computed.tpl", Module: "testing", Mode: "0640"},
+		{Name: "private", Template: "private.tpl", Module: "testing", Mode: "0600"},
+		{Name: "script.sh", Template: "script.sh.tpl", Module: "testing", Mode: "0755"},
+	})
+}
+
+func TestSymlinkRender(t *testing.T) {
+	fs := memfs.New()
+	ctx := context.Background()
+	log := slogext.NewTestLogger(t)
+
+	assert.NilError(t, util.WriteFile(fs, "manifest.yaml", []byte("name: testing"), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/config/shared.tpl",
+		[]byte(`{{ file.Symlink "../shared/config" }}ignored`), 0o644))
+
+	tp, err := modulestest.NewWithFS(ctx, "testing", fs)
+	assert.NilError(t, err, "failed to NewWithFS")
+	st := NewStencil(&configuration.Manifest{
+		Name:      "test",
+		Arguments: map[string]any{},
+	}, []*modules.Module{tp}, log)
+
+	tpls, err := st.Render(ctx, log)
+	assert.NilError(t, err, "expected Render() to not fail")
+	assert.Equal(t, tpls[0].Files[0].SymlinkTarget(), "../shared/config")
+	assert.Equal(t, tpls[0].Files[0].String(), "", "expected output of the template to be ignored")
+
+	lock := st.GenerateLockfile(tpls)
+	assert.DeepEqual(t, lock.Files, []*lockfile.FileEntry{
+		{Name: "config/shared", Template: "config/shared.tpl", Module: "testing", Symlink: "../shared/config"},
+	})
+
+	assert.NilError(t, util.WriteFile(fs, "templates/config/shared.tpl",
+		[]byte(`{{ file.Symlink "../../outside" }}`), 0o644))
+	tp, err = modulestest.NewWithFS(ctx, "testing", fs)
+	assert.NilError(t, err, "failed to NewWithFS")
+	st = NewStencil(&configuration.Manifest{
+		Name:      "test",
+		Arguments: map[string]any{},
+	}, []*modules.Module{tp}, log)
+
+	_, err = st.Render(ctx, log)
+	assert.ErrorContains(t, err, `symlink "config/shared" to "../../outside" points outside of the project`)
+}
+
+func TestRenderUsesProjectFS(t *testing.T) {
+	fs := memfs.New()
+	ctx := context.Background()
+	log := slogext.NewTestLogger(t)
+
+	assert.NilError(t, util.WriteFile(fs, "manifest.yaml", []byte("name: testing"), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/out.txt.tpl", []byte(
+		"{{ stencil.ReadFile \"in.txt\" }}{{ stencil.Exists \"missing.txt\" }}\n"+
+			"{{- $_ := file.RemoveAll \"old\" }}\n"+
+			"## <<Stencil::Block(custom)>>\n{{ file.Block \"custom\" }}\n## <</Stencil::Block>>\n"), 0o644))
+
+	project := memfs.New()
+	assert.NilError(t, util.WriteFile(project, "in.txt", []byte("from project "), 0o644))
+	assert.NilError(t, util.WriteFile(project, "old/file.txt", []byte("remove me"), 0o644))
+	assert.NilError(t, util.WriteFile(project, "out.txt", []byte(
+		"## <<Stencil::Block(custom)>>\nkept\n## <</Stencil::Block>>\n"), 0o644))
+
+	tp, err := modulestest.NewWithFS(ctx, "testing", fs)
+	assert.NilError(t, err, "failed to NewWithFS")
+	st := NewStencil(&configuration.Manifest{
+		Name:      "test",
+		Arguments: map[string]any{},
+	}, []*modules.Module{tp}, log)
+	st.SetProjectFS(project)
+
+	tpls, err := st.Render(ctx, log)
+	assert.NilError(t, err, "expected Render() to not fail")
+	files := make(map[string]*File)
+	for _, f := range tpls[0].Files {
+		files[f.Name()] = f
+	}
+	assert.Equal(t, len(files), 2)
+	assert.Equal(t, files["out.txt"].String(),
+		"from project false\n## <<Stencil::Block(custom)>>\nkept\n## <</Stencil::Block>>\n")
+
+	// Removing files is left to writing the rendered files
+	assert.Assert(t, files["old"].Deleted)
+	_, err = project.Stat("old/file.txt")
+	assert.NilError(t, err, "expected file.RemoveAll to not remove from the project while rendering")
+}
+
+func TestRenderReadsGitFromProjectFS(t *testing.T) {
+	fs := memfs.New()
+	ctx := context.Background()
+	log := slogext.NewTestLogger(t)
+	assert.NilError(t, util.WriteFile(fs, "manifest.yaml", []byte("name: testing"), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/ref.txt.tpl", []byte("{{ .Git.Ref }}"), 0o644))
+
+	dir := t.TempDir()
+	r, err := gogit.PlainInit(dir, false)
+	assert.NilError(t, err, "expected gogit.PlainInit() not to fail")
+	wrk, err := r.Worktree()
+	assert.NilError(t, err, "expected gogit.(Repository).Worktree() not to fail")
+	_, err = wrk.Commit("initial commit", &gogit.CommitOptions{
+		AllowEmptyCommits: true,
+		Author:            &object.Signature{Name: "Stencil", Email: "email@example.com", When: time.Now()},
+	})
+	assert.NilError(t, err, "expected worktree.Commit() not to fail")
+	assert.NilError(t, wrk.Checkout(&gogit.CheckoutOptions{
+		Create: true,
+		Branch: plumbing.NewBranchReferenceName("project-branch"),
+	}), "expected worktree.Checkout() not to fail")
+
+	tests := []struct {
+		name    string
+		project billy.Filesystem
+		want    string
+	}{
+		{name: "project directory", project: NewProjectFS(dir), want: "refs/heads/project-branch"},
+		{name: "in-memory filesystem", project: memfs.New(), want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tp, err := modulestest.NewWithFS(ctx, "testing", fs)
+			assert.NilError(t, err, "failed to NewWithFS")
+			st := NewStencil(&configuration.Manifest{Name: "test"}, []*modules.Module{tp}, log)
+			st.SetProjectFS(tt.project)
+
+			tpls, err := st.Render(ctx, log)
+			assert.NilError(t, err, "expected Render() to not fail")
+			assert.Equal(t, tpls[0].Files[0].String(), tt.want)
+		})
+	}
+}
+
+func TestConcurrentRenderIsDeterministic(t *testing.T) {
+	ctx := context.Background()
+	log := slogext.NewTestLogger(t)
+
+	fs := memfs.New()
+	assert.NilError(t, util.WriteFile(fs, "manifest.yaml", []byte("name: testing"), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/helpers.library.tpl", []byte(
+		`{{- define "greet" }}Hello, {{ . }}!{{ end }}`), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/hooks.txt.tpl", []byte(
+		`{{- range stencil.GetModuleHook "names" }}{{ . }} {{ end }}`), 0o644))
+	for i := range 50 {
+		assert.NilError(t, util.WriteFile(fs, fmt.Sprintf("templates/%02d.txt.tpl", i), []byte(fmt.Sprintf(
+			`{{- stencil.AddToModuleHook "testing" "names" (list "%02d") }}`+
+				`{{- stencil.ApplyTemplate "greet" "%02d" }} {{ file.Path }}`, i, i)), 0o644))
+	}
+
+	render := func() map[string]string {
+		tp, err := modulestest.NewWithFS(ctx, "testing", fs)
+		assert.NilError(t, err, "failed to NewWithFS")
+		st := NewStencil(&configuration.Manifest{Name: "test", Arguments: map[string]any{}}, []*modules.Module{tp}, log)
+		st.SetProjectFS(memfs.New())
+
+		tpls, err := st.Render(ctx, log)
+		assert.NilError(t, err, "expected Render() to not fail")
+
+		files := make(map[string]string)
+		for _, tpl := range tpls {
+			for _, f := range tpl.Files {
+				files[f.Name()] = f.String()
+			}
+		}
+		return files
+	}
+
+	want := render()
+	assert.Equal(t, len(want), 51)
+	assert.Equal(t, want["07.txt"], "Hello, 07! 07.txt")
+	assert.Equal(t, len(strings.Fields(want["hooks.txt"])), 50, "expected every template to add to the module hook")
+	for range 5 {
+		assert.DeepEqual(t, render(), want)
+	}
+}
+
+func TestConcurrentRenderReturnsErrorDeterministically(t *testing.T) {
+	ctx := context.Background()
+	log := slogext.NewTestLogger(t)
+
+	fs := memfs.New()
+	assert.NilError(t, util.WriteFile(fs, "manifest.yaml", []byte("name: testing"), 0o644))
+	for _, name := range []string{"a", "b", "c", "d"} {
+		assert.NilError(t, util.WriteFile(fs, "templates/"+name+".tpl", []byte(`{{ fail "`+name+`" }}`), 0o644))
+	}
+
+	for range 5 {
+		tp, err := modulestest.NewWithFS(ctx, "testing", fs)
+		assert.NilError(t, err, "failed to NewWithFS")
+		st := NewStencil(&configuration.Manifest{Name: "test", Arguments: map[string]any{}}, []*modules.Module{tp}, log)
+		st.SetProjectFS(memfs.New())
+
+		_, err = st.Render(ctx, log)
+		assert.ErrorContains(t, err, `failed to render template "testing/a.tpl"`)
+	}
+}
+
+// renderModuleTemplates renders a module with the provided templates,
+// by path, returning the rendered files by name
+func renderModuleTemplates(t *testing.T, tpls map[string]string) (map[string]string, error) {
+	ctx := context.Background()
+	log := slogext.NewTestLogger(t)
+
+	fs := memfs.New()
+	assert.NilError(t, util.WriteFile(fs, "manifest.yaml", []byte("name: testing"), 0o644))
+	for path, contents := range tpls {
+		assert.NilError(t, util.WriteFile(fs, "templates/"+path, []byte(contents), 0o644))
+	}
+
+	tp, err := modulestest.NewWithFS(ctx, "testing", fs)
+	assert.NilError(t, err, "failed to NewWithFS")
+	st := NewStencil(&configuration.Manifest{Name: "test", Arguments: map[string]any{}}, []*modules.Module{tp}, log)
+	st.SetProjectFS(memfs.New())
+
+	rendered, err := st.Render(ctx, log)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]string)
+	for _, tpl := range rendered {
+		for _, f := range tpl.Files {
+			files[f.Name()] = f.String()
+		}
+	}
+	return files, nil
+}
+
+func TestModuleHooksCanDependOnModuleHooks(t *testing.T) {
+	files, err := renderModuleTemplates(t, map[string]string{
+		"first.library.tpl": `{{- stencil.AddToModuleHook "testing" "first" (list "a" "b") }}`,
+		"second.library.tpl": `{{- range stencil.GetModuleHook "first" }}` +
+			`{{- stencil.AddToModuleHook "testing" "second" (list (upper .)) }}{{ end }}`,
+		"out.txt.tpl": `{{- range sortAlpha (stencil.GetModuleHook "second") }}{{ . }}{{ end }}`,
+	})
+	assert.NilError(t, err, "expected Render() to not fail")
+	assert.Equal(t, files["out.txt"], "AB")
+}
+
+func TestModuleHooksThatNeverSettleFail(t *testing.T) {
+	_, err := renderModuleTemplates(t, map[string]string{
+		"grow.library.tpl": `{{- stencil.AddToModuleHook "testing" "count" ` +
+			`(list (len (stencil.GetModuleHook "count"))) }}` +
+			`{{- range stencil.GetModuleHook "count" }}` +
+			`{{- stencil.AddToModuleHook "testing" "count" (list .) }}{{ end }}`,
+	})
+	assert.ErrorContains(t, err, fmt.Sprintf("didn't settle after %d passes", DefaultMaxPasses))
+	assert.ErrorContains(t, err, "hook testing/count: changed by testing/grow.library.tpl")
+}
+
+func TestModuleHookCyclesFail(t *testing.T) {
+	_, err := renderModuleTemplates(t, map[string]string{
+		"flip.library.tpl": `{{- if empty (stencil.GetModuleHook "flip") }}` +
+			`{{- stencil.AddToModuleHook "testing" "flip" (list "on") }}{{ end }}`,
+	})
+	assert.ErrorContains(t, err, "written in pass 2 are the same as the ones read in pass 1")
+	assert.ErrorContains(t, err, "hook testing/flip: no longer written by testing/flip.library.tpl")
+}
+
+func TestSeedDeterminesTemplateOrder(t *testing.T) {
+	ctx := context.Background()
+	log := slogext.NewTestLogger(t)
+
+	fs := memfs.New()
+	assert.NilError(t, util.WriteFile(fs, "manifest.yaml", []byte("name: testing"), 0o644))
+	for i := range 20 {
+		assert.NilError(t, util.WriteFile(fs, fmt.Sprintf("templates/%02d.tpl", i), []byte(""), 0o644))
+	}
+
+	order := func(seed int64) []string {
+		tp, err := modulestest.NewWithFS(ctx, "testing", fs)
+		assert.NilError(t, err, "failed to NewWithFS")
+		st := NewStencil(&configuration.Manifest{Name: "test"}, []*modules.Module{tp}, log)
+		st.SetSeed(seed)
+		assert.Equal(t, st.Seed(), seed)
+
+		tpls, err := st.getTemplates(ctx, log)
+		assert.NilError(t, err)
+		paths := make([]string, 0, len(tpls))
+		for _, tpl := range tpls {
+			paths = append(paths, tpl.ImportPath())
+		}
+		return paths
+	}
+
+	assert.DeepEqual(t, order(42), order(42))
+	assert.Assert(t, !slices.Equal(order(42), order(43)), "expected different seeds to shuffle differently")
+}
+
+func TestRenderIsProfiled(t *testing.T) {
+	ctx := context.Background()
+	log := slogext.NewTestLogger(t)
+
+	fs := memfs.New()
+	assert.NilError(t, util.WriteFile(fs, "manifest.yaml", []byte("name: testing"), 0o644))
+	assert.NilError(t, util.WriteFile(fs, "templates/a.txt.tpl", []byte("a"), 0o644))
+
+	tp, err := modulestest.NewWithFS(ctx, "testing", fs)
+	assert.NilError(t, err, "failed to NewWithFS")
+	st := NewStencil(&configuration.Manifest{Name: "test", Arguments: map[string]any{}}, []*modules.Module{tp}, log)
+	st.SetProjectFS(memfs.New())
+
+	p := profile.New()
+	st.SetProfiler(p)
+	_, err = st.Render(ctx, log)
+	assert.NilError(t, err, "expected Render() to not fail")
+
+	var buf bytes.Buffer
+	assert.NilError(t, p.WriteTable(&buf))
+	assert.Assert(t, strings.Contains(buf.String(), "testing/a.txt.tpl (pass 1)"), buf.String())
+	assert.Assert(t, strings.Contains(buf.String(), "parse"), buf.String())
+}
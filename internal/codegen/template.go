@@ -6,13 +6,16 @@ package codegen
 
 import (
 	"bytes"
+	"context"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"text/template"
 	"time"
 
 	"go.rgst.io/stencil/internal/modules"
+	"go.rgst.io/stencil/pkg/configuration"
 	"go.rgst.io/stencil/pkg/slogext"
 )
 
@@ -35,6 +38,22 @@ type Template struct {
 	// for the default file if not modified during render time
 	modTime time.Time
 
+	// deps are the inputs read by this template while it's being
+	// rendered, it's nil when they aren't tracked, see RenderCache.
+	deps *renderDeps
+
+	// ctx is the context of the current render of this template, calls
+	// of extensions made by it are traced in it
+	ctx context.Context
+
+	// moduleChanged denotes if the version of Module changed since the
+	// last run of stencil, it's set when rendering.
+	moduleChanged bool
+
+	// tpl is the copy of the go template of Module that this template is
+	// executed with, see modules.Module.CloneTemplate
+	tpl *template.Template
+
 	// Module is the underlying module that's creating this template
 	Module *modules.Module
 
@@ -44,12 +63,23 @@ type Template struct {
 	// Files is a list of files that this template generated
 	Files []*File
 
+	// removed are the paths removed by file.RemoveAll, as deleted files.
+	// They're added to Files once the template has been rendered.
+	removed []*File
+
 	// Contents is the content of this template
 	Contents []byte
 
 	// Library denotes if a template is a library template or not. Library
 	// templates cannot generate files.
 	Library bool
+
+	// Static denotes this template as a static file, from the files/
+	// directory of the module, that is copied byte-for-byte instead of
+	// being rendered. Path is relative to the templates/ directory, like
+	// the path of other templates, so it starts with ../files/ and never
+	// collides with the path of a template (e.g. files/x.tpl).
+	Static bool
 }
 
 // NewTemplate creates a new Template with the current file being the same name
@@ -76,17 +106,35 @@ func NewTemplate(m *modules.Module, fpath string, mode os.FileMode,
 // ImportPath returns the path to this template, this is meant to denote
 // which module this template is attached to
 func (t *Template) ImportPath() string {
+	if t.Static {
+		// path.Join would clean the ../ away, see Static
+		return t.Module.Name + "/" + filepath.ToSlash(t.Path)
+	}
 	return path.Join(t.Module.Name, t.Path)
 }
 
+// blockCommentStyles returns the custom block comment styles declared
+// by the module of this template
+func (t *Template) blockCommentStyles() map[string][]*configuration.BlockCommentStyle {
+	if t == nil || t.Module == nil || t.Module.Manifest == nil {
+		return nil
+	}
+	return t.Module.Manifest.BlockCommentStyles
+}
+
 // Parse parses the provided template and makes it available to be Rendered
 // in the context of the current module.
 func (t *Template) Parse(_ *Stencil) error {
+	// Static files aren't templates
+	if t.Static {
+		t.parsed = true
+		return nil
+	}
+
 	// Add the current template to the template object on the module that we're
 	// attached to. This enables us to call functions in other templates within our
 	// 'module context'.
-	if _, err := t.Module.GetTemplate().New(t.ImportPath()).Funcs(NewFuncMap(nil, nil, t.log)).
-		Parse(string(t.Contents)); err != nil {
+	if err := t.Module.ParseTemplate(t.ImportPath(), string(t.Contents), NewFuncMap(nil, nil, t.log)); err != nil {
 		return err
 	}
 
@@ -98,13 +146,18 @@ func (t *Template) Parse(_ *Stencil) error {
 // Render renders the provided template, the produced files
 // are rendered onto the Files field of the template struct.
 func (t *Template) Render(st *Stencil, vals *Values) error {
+	if t.Static {
+		return t.renderStatic(st, vals)
+	}
+
 	if len(t.Files) == 0 && !t.Library {
 		p := strings.TrimSuffix(t.Path, ".tpl")
 		p = t.Module.ApplyDirReplacements(p)
-		f, err := NewFile(p, t.mode, t.modTime)
+		f, err := NewFile(st.fs, p, t.mode, t.modTime, t.blockCommentStyles())
 		if err != nil {
 			return err
 		}
+		t.deps.add("blocks:"+p, hashBlocks(f.blocks))
 		t.Files = []*File{f}
 	}
 
@@ -115,21 +168,31 @@ func (t *Template) Render(st *Stencil, vals *Values) error {
 		}
 	}
 
+	t.moduleChanged = st.moduleVersionChanged(t.Module)
+
 	// Update the module values
 	t.args = vals.WithModule(t.Module.Name, t.Module.Version).WithTemplate(t.Path)
 
+	// Execute on a copy of the shared template, so that other templates
+	// of the module can be rendered at the same time with their own
+	// functions.
+	tpl, err := t.Module.CloneTemplate()
+	if err != nil {
+		return err
+	}
+	t.tpl = tpl.Funcs(NewFuncMap(st, t, t.log))
+
 	// Execute a specific file because we're using a shared template, if we attempt to render
 	// the entire template we'll end up just rendering the base template (<module>) which is empty
 	var buf bytes.Buffer
-	if err := t.Module.GetTemplate().Funcs(NewFuncMap(st, t, t.log)).
-		ExecuteTemplate(&buf, t.ImportPath(), t.args); err != nil {
+	if err := t.tpl.ExecuteTemplate(&buf, t.ImportPath(), t.args); err != nil {
 		return err
 	}
 
 	// If we're a library template, we don't want to generate any files so
 	// we can return early here.
 	if t.Library {
-		t.Files = nil
+		t.Files = t.removed
 		return nil
 	}
 
@@ -137,8 +200,9 @@ func (t *Template) Render(st *Stencil, vals *Values) error {
 	// then we should write the output of the rendered template.
 	//
 	// This ensures that templates don't need to call file.Create
-	// by default, only when they want to customize the output
-	if len(t.Files) == 1 && len(t.Files[0].Bytes()) == 0 {
+	// by default, only when they want to customize the output. Symlinks
+	// have no contents.
+	if len(t.Files) == 1 && len(t.Files[0].Bytes()) == 0 && t.Files[0].SymlinkTarget() == "" {
 		t.Files[0].SetContents(buf.String())
 	} else if len(t.Files) > 1 {
 		// otherwise, remove the first file that was created when
@@ -146,6 +210,7 @@ func (t *Template) Render(st *Stencil, vals *Values) error {
 		// no calls to file.Create
 		t.Files = t.Files[1:len(t.Files)]
 	}
+	t.Files = append(t.Files, t.removed...)
 
 	return nil
 }
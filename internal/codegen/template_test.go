@@ -13,6 +13,7 @@ import (
 
 	_ "embed"
 
+	"github.com/go-git/go-billy/v5/osfs"
 	"go.rgst.io/stencil/internal/modules"
 	"go.rgst.io/stencil/internal/modules/modulestest"
 	"go.rgst.io/stencil/internal/testing/testmemfs"
@@ -49,7 +50,7 @@ func TestSingleFileRender(t *testing.T) {
 	sm := &configuration.Manifest{Name: "testing"}
 
 	st := NewStencil(sm, []*modules.Module{m}, log)
-	err = tpl.Render(st, NewValues(context.Background(), sm, nil))
+	err = tpl.Render(st, NewValues(context.Background(), sm, nil, ""))
 	assert.NilError(t, err, "expected Render() to not fail")
 	assert.Equal(t, tpl.Files[0].String(), "hello world!", "expected Render() to modify first created file")
 }
@@ -70,7 +71,7 @@ func TestMultiFileRender(t *testing.T) {
 	}}
 
 	st := NewStencil(sm, []*modules.Module{m}, log)
-	err = tpl.Render(st, NewValues(context.Background(), sm, nil))
+	err = tpl.Render(st, NewValues(context.Background(), sm, nil, ""))
 	assert.NilError(t, err, "expected Render() to not fail")
 	assert.Equal(t, len(tpl.Files), 3, "expected Render() to create 3 files")
 
@@ -95,7 +96,7 @@ func TestMultiFileWithInputRender(t *testing.T) {
 	}}
 
 	st := NewStencil(sm, []*modules.Module{m}, log)
-	err = tpl.Render(st, NewValues(context.Background(), sm, nil))
+	err = tpl.Render(st, NewValues(context.Background(), sm, nil, ""))
 	assert.NilError(t, err, "expected Render() to not fail")
 	assert.Equal(t, len(tpl.Files), 3, "expected Render() to create 3 files")
 
@@ -120,7 +121,7 @@ func TestApplyTemplateArgumentPassthrough(t *testing.T) {
 	}}
 
 	st := NewStencil(sm, []*modules.Module{m}, log)
-	err = tpl.Render(st, NewValues(context.Background(), sm, nil))
+	err = tpl.Render(st, NewValues(context.Background(), sm, nil, ""))
 	assert.NilError(t, err, "expected Render() to not fail")
 	assert.Equal(t, len(tpl.Files), 1, "expected Render() to create 1 files")
 
@@ -146,12 +147,12 @@ func TestGeneratedBlock(t *testing.T) {
 		time.Now(), []byte(generatedBlockTemplate), log)
 	assert.NilError(t, err, "failed to create template")
 
-	tplf, err := NewFile(fakeFilePath, 0o644, time.Now())
+	tplf, err := NewFile(osfs.New(tempDir), filepath.Base(fakeFilePath), 0o644, time.Now(), nil)
 	assert.NilError(t, err, "failed to create file")
 
 	// Add the file (fake) to the template so that the template uses it for blocks
 	tpl.Files = []*File{tplf}
-	tpl.Render(st, NewValues(context.Background(), sm, nil))
+	tpl.Render(st, NewValues(context.Background(), sm, nil, ""))
 
 	assert.Equal(t, tpl.Files[0].String(), fakeGeneratedBlockFile, "expected fake to equal rendered output")
 }
@@ -171,7 +172,7 @@ func TestLibraryTemplate(t *testing.T) {
 
 	assert.NilError(t, tpl.Render(
 		NewStencil(&configuration.Manifest{Name: "testing"}, []*modules.Module{m},
-			log), NewValues(context.Background(), &configuration.Manifest{Name: "testing"}, nil)),
+			log), NewValues(context.Background(), &configuration.Manifest{Name: "testing"}, nil, "")),
 		"expected library template to not fail on render")
 
 	assert.Equal(t, len(tpl.Files), 0, "expected library template to not generate files")
@@ -191,7 +192,7 @@ func TestLibraryCantAccessFileFunctions(t *testing.T) {
 	assert.Equal(t, tpl.Library, true, "expected library template to be marked as such")
 
 	err = tpl.Render(NewStencil(&configuration.Manifest{Name: "testing"}, []*modules.Module{m}, log),
-		NewValues(context.Background(), &configuration.Manifest{Name: "testing"}, nil))
+		NewValues(context.Background(), &configuration.Manifest{Name: "testing"}, nil, ""))
 	assert.ErrorContains(t, err,
 		"attempted to use file in a template that doesn't support file rendering",
 		"expected library template to fail on render",
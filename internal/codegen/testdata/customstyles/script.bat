@@ -0,0 +1,7 @@
+@echo off
+REM <<Stencil::Block(commands)>>
+echo hello
+REM <</Stencil::Block>>
+(* <<Stencil::Block(pascal)>> *)
+pascal
+(* <</Stencil::Block>> *)
@@ -0,0 +1 @@
+{{ extensions.Call "test.Greet" 1 }}
@@ -28,8 +28,12 @@ func NewFuncMap(st *Stencil, t *Template, log slogext.Logger) template.FuncMap {
 		tplf = &TplFile{t.Files[0], t, log}
 	}
 
-	// build the function map
-	funcs := Default
+	// build the function map, copying Default so that templates rendered
+	// concurrently don't share their functions
+	funcs := make(template.FuncMap, len(Default)+3)
+	for k, v := range Default {
+		funcs[k] = v
+	}
 	funcs["stencil"] = func() *TplStencil { return tplst }
 	funcs["file"] = func() *TplFile {
 		if tplf == nil {
@@ -38,6 +42,14 @@ func NewFuncMap(st *Stencil, t *Template, log slogext.Logger) template.FuncMap {
 
 		return tplf
 	}
-	funcs["extensions"] = func() *nativeext.ExtensionCaller { return st.extCaller }
+	funcs["extensions"] = func() *nativeext.ExtensionCaller {
+		if t == nil {
+			return st.extCaller
+		}
+
+		// Extensions are external, so what they return isn't tracked
+		t.deps.markUncacheable("calls native extensions")
+		return st.extCaller.WithContext(t.ctx)
+	}
 	return funcs
 }
@@ -6,7 +6,12 @@
 package codegen
 
 import (
+	"fmt"
+	"math"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.rgst.io/stencil/pkg/slogext"
@@ -45,8 +50,40 @@ type TplFile struct {
 //	{{ - /* Short hand syntax, but adds newline if no contents */}}
 //	{{ file.Block "name" }}
 //	###EndBlock(name)
-func (f *TplFile) Block(name string) string {
-	return f.f.Block(name)
+//
+// An optional default can be provided, which is returned when the block
+// doesn't exist yet. Blocks may be nested, and the "default" argument
+// of a block controls what happens to its existing contents: "keep"
+// (the default) keeps them, "reset" replaces them with the default when
+// the version of the module changed since the last run, and "append"
+// appends the default to them unless they already contain it.
+//
+//	// <<Stencil::Block(imports, default=append)>>
+//	{{ file.Block "imports" "import \"fmt\"" }}
+//	// <</Stencil::Block>>
+func (f *TplFile) Block(name string, def ...string) (string, error) {
+	switch len(def) {
+	case 0:
+		return f.f.Block(name), nil
+	case 1:
+		return f.f.BlockOrDefault(name, def[0], f.t.moduleChanged), nil
+	default:
+		return "", fmt.Errorf("expected at most one default for block %q, got %d", name, len(def))
+	}
+}
+
+// MigrateBlock renames a block of the current file, so that reading
+// the new block returns the contents of the old block when the file
+// doesn't contain the new block yet. Use it when renaming a block to
+// prevent its contents from being orphaned. It must be called before the
+// new block is read.
+//
+//	{{- file.MigrateBlock "oldName" "newName" }}
+//	// <<Stencil::Block(newName)>>
+//	{{ file.Block "newName" }}
+//	// <</Stencil::Block>>
+func (f *TplFile) MigrateBlock(oldName, newName string) (out string, err error) {
+	return "", f.f.MigrateBlock(oldName, newName)
 }
 
 // SetPath changes the path of the current file being rendered
@@ -54,8 +91,85 @@ func (f *TplFile) Block(name string) string {
 //	{{- file.SetPath "new/path/to/file.txt" }}
 func (f *TplFile) SetPath(path string) (out string, err error) {
 	path = f.t.Module.ApplyDirReplacements(path)
-	err = f.f.SetPath(path)
-	return "", err
+	if err := f.f.SetPath(path); err != nil {
+		return "", err
+	}
+	f.t.deps.add("blocks:"+path, hashBlocks(f.f.blocks))
+	return "", nil
+}
+
+// SetMode sets the permissions of the file being rendered, by default
+// the permissions of the template are used. The permissions are applied
+// to existing files as well. The mode is a number, e.g. from an
+// argument, or a string containing an octal number (e.g. "0600").
+//
+//	{{- file.SetMode 0600 }}
+func (f *TplFile) SetMode(mode any) (out string, err error) {
+	perm, err := parseFileMode(mode)
+	if err != nil {
+		return "", err
+	}
+
+	f.f.SetMode(f.f.Mode()&^os.ModePerm | perm)
+	return "", nil
+}
+
+// parseFileMode converts the mode passed to file.SetMode, which may be
+// of any number type or an octal string, into permission bits
+func parseFileMode(mode any) (os.FileMode, error) {
+	var n int64
+	switch v := reflect.ValueOf(mode); {
+	case v.CanInt():
+		n = v.Int()
+	case v.CanUint():
+		if v.Uint() > math.MaxInt64 {
+			return 0, fmt.Errorf("invalid mode %#o, it must be at most 07777", v.Uint())
+		}
+		n = int64(v.Uint())
+	case v.CanFloat():
+		if v.Float() != math.Trunc(v.Float()) {
+			return 0, fmt.Errorf("invalid mode %v, expected a whole number", v.Float())
+		}
+		if math.Abs(v.Float()) > 0o7777 {
+			return 0, fmt.Errorf("invalid mode %v, it must be at most 07777", v.Float())
+		}
+		n = int64(v.Float())
+	case v.Kind() == reflect.String:
+		var err error
+		if n, err = strconv.ParseInt(strings.TrimPrefix(v.String(), "0o"), 8, 64); err != nil {
+			return 0, fmt.Errorf("invalid mode %q, expected an octal number", v.String())
+		}
+	default:
+		return 0, fmt.Errorf("invalid mode %v, expected a number, got %T", mode, mode)
+	}
+
+	if n < 0 || n > 0o7777 {
+		return 0, fmt.Errorf("invalid mode %#o, it must be at most 07777", n)
+	}
+	if n&^int64(os.ModePerm) != 0 {
+		return 0, fmt.Errorf("invalid mode %#o, only permission bits can be set, not setuid, setgid or sticky", n)
+	}
+	return os.FileMode(n), nil
+}
+
+// SetExecutable makes the file being rendered executable by everyone
+// that can read it, like chmod +x.
+//
+//	{{- file.SetExecutable }}
+func (f *TplFile) SetExecutable() string {
+	mode := f.f.Mode()
+	f.f.SetMode(mode | (mode&0o444)>>2)
+	return ""
+}
+
+// Symlink makes the file being rendered a symlink to target, instead of
+// a regular file. The target must be relative to the directory of the
+// file and stay inside of the project. The output of the template is
+// ignored.
+//
+//	{{- file.Symlink "../shared/config" }}
+func (f *TplFile) Symlink(target string) (out string, err error) {
+	return "", f.f.SetSymlink(target)
 }
 
 // SetContents sets the contents of file being rendered to the value
@@ -96,7 +210,8 @@ func (f *TplFile) Delete() error {
 //	{{ file.Static }}
 func (f *TplFile) Static() (out string, err error) {
 	// if the file already exists, skip it
-	if _, err := os.Stat(f.f.path); err == nil {
+	f.t.deps.add("file:"+f.f.path, hashFile(f.f.fs, f.f.path))
+	if _, err := f.f.fs.Stat(f.f.path); err == nil {
 		f.log.With("template", f.t.Path, "path", f.f.path).
 			Debug("Skipping static file because it already exists")
 		return f.Skip("Static file, output already exists")
@@ -134,21 +249,22 @@ func (f *TplFile) Path() string {
 //	{{- stencil.ApplyTemplate "command" | file.SetContents }}
 //	{{- end }}
 func (f *TplFile) Create(path string, mode os.FileMode, modTime time.Time) (out, err error) {
-	f.f, err = NewFile(path, mode, modTime)
+	f.f, err = NewFile(f.f.fs, path, mode, modTime, f.t.blockCommentStyles())
 	if err != nil {
 		return err, err
 	}
+	f.t.deps.add("blocks:"+path, hashBlocks(f.f.blocks))
 
 	f.t.Files = append(f.t.Files, f.f)
 	return nil, nil
 }
 
-// RemoveAll deletes all the contents in the provided path
+// RemoveAll deletes all the contents in the provided path. The path is
+// removed when the rendered files are written, before any of them is.
 //
 //	{{ file.RemoveAll "path" }}
 func (f *TplFile) RemoveAll(path string) (out, err error) {
-	if err := os.RemoveAll(path); err != nil {
-		return err, err
-	}
+	f.t.deps.markUncacheable("removes files")
+	f.t.removed = append(f.t.removed, &File{fs: f.f.fs, path: path, Deleted: true})
 	return nil, nil
 }
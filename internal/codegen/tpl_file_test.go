@@ -0,0 +1,57 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codegen
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestParseFileMode(t *testing.T) {
+	tests := []struct {
+		mode    any
+		want    os.FileMode
+		wantErr string
+	}{
+		{mode: 0o600, want: 0o600},
+		{mode: int64(0o755), want: 0o755},
+		{mode: os.FileMode(0o644), want: 0o644},
+		{mode: float64(0o640), want: 0o640},
+		{mode: "0600", want: 0o600},
+		{mode: "0o750", want: 0o750},
+		{mode: "755", want: 0o755},
+		{mode: 0.5, wantErr: "expected a whole number"},
+		{mode: "rw-r--r--", wantErr: "expected an octal number"},
+		{mode: -1, wantErr: "it must be at most 07777"},
+		{mode: 0o10000, wantErr: "it must be at most 07777"},
+		{mode: os.ModeDir | 0o755, wantErr: "it must be at most 07777"},
+		{mode: 0o4755, wantErr: "only permission bits can be set"},
+		{mode: true, wantErr: "expected a number, got bool"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%T(%v)", tt.mode, tt.mode), func(t *testing.T) {
+			got, err := parseFileMode(tt.mode)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, got, tt.want)
+		})
+	}
+}
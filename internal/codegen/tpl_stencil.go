@@ -14,7 +14,6 @@ import (
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/go-git/go-billy/v5"
-	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/pkg/errors"
 	"go.rgst.io/stencil/pkg/slogext"
 )
@@ -38,21 +37,23 @@ type TplStencil struct {
 // for your module. The value returned by this function is always a
 // []any, aka a list.
 //
+// Templates are rendered until the data written to module hooks stops
+// changing, so the data written to a module hook may depend on another
+// module hook.
+//
 //	{{- /* This returns a []any */}}
 //	{{ $hook := stencil.GetModuleHook "myModuleHook" }}
 //	{{- range $hook }}
 //	  {{ . }}
 //	{{- end }}
 func (s *TplStencil) GetModuleHook(name string) []any {
-	// On the first pass, never return any data. If we did, the data would
-	// be unreliably set because we don't sort the templates in any way or
-	// guarantee that they will be rendered in specific any order.
-	if s.s.isFirstPass {
-		return []any{}
-	}
-
+	// Only the data written during the previous pass is returned. If we
+	// returned the data of the current pass, it would be unreliably set
+	// because we don't sort the templates in any way or guarantee that
+	// they will be rendered in specific any order.
 	k := s.s.sharedData.key(s.t.Module.Name, name)
-	v := s.s.sharedData.moduleHooks[k]
+	v := s.s.moduleHookValues(k)
+	s.deps().addValue("hook:"+k, v)
 	if v == nil {
 		// No data, return nothing
 		return []any{}
@@ -61,7 +62,7 @@ func (s *TplStencil) GetModuleHook(name string) []any {
 	s.log.With("template", s.t.ImportPath(), "path", k, "data", spew.Sdump(v)).
 		Debug("getting module hook")
 
-	return v.values
+	return v
 }
 
 // SetGlobal sets a global to be used in the context of the current template module
@@ -78,19 +79,24 @@ func (s *TplStencil) GetModuleHook(name string) []any {
 //	{{- /* This writes a global into the current context of the template module repository */}}
 //	{{- stencil.SetGlobal "IsGeorgeCool" true -}}
 func (s *TplStencil) SetGlobal(name string, data interface{}) (output string, err error) {
-	// Only modify on first pass
-	if !s.s.isFirstPass {
+	// Only modify while rendering templates, writes are read in the next
+	// pass
+	next := s.s.nextSharedData
+	if next == nil {
 		return "", nil
 	}
 
-	k := s.s.sharedData.key(s.t.Module.Name, name)
+	k := next.key(s.t.Module.Name, name)
 	s.log.With("template", s.t.ImportPath(), "path", k, "data", spew.Sdump(data)).
 		Debug("adding to global store")
 
-	s.s.sharedData.globals[k] = global{
-		template: s.t.Path,
+	next.mu.Lock()
+	next.globals[k] = global{
+		template: s.t.ImportPath(),
 		value:    data,
 	}
+	next.mu.Unlock()
+	s.deps().markWroteSharedData()
 
 	return "", nil
 }
@@ -104,7 +110,9 @@ func (s *TplStencil) SetGlobal(name string, data interface{}) (output string, er
 func (s *TplStencil) GetGlobal(name string) interface{} {
 	k := s.s.sharedData.key(s.t.Module.Name, name)
 
-	if v, ok := s.s.sharedData.globals[k]; ok {
+	v, ok := s.s.globalValue(k)
+	s.deps().addValue("global:"+k, v.value)
+	if ok {
 		s.log.With(
 			"template", s.t.ImportPath(),
 			"path", k,
@@ -116,7 +124,7 @@ func (s *TplStencil) GetGlobal(name string) interface{} {
 	}
 
 	// Don't log on the first pass because we haven't rendered all the templates yet
-	if !s.s.isFirstPass {
+	if s.s.pass > 1 {
 		s.log.With("template", s.t.ImportPath(), "path", k).
 			Warn("failed to retrieved data from global store")
 	}
@@ -135,12 +143,14 @@ func (s *TplStencil) GetGlobal(name string) interface{} {
 //	{{- /* This writes to a module hook */}}
 //	{{- stencil.AddToModuleHook "github.com/myorg/repo" "myModuleHook" (list "myData") }}
 func (s *TplStencil) AddToModuleHook(module, name string, data interface{}) (out string, err error) {
-	// Only modify on first pass
-	if !s.s.isFirstPass {
+	// Only modify while rendering templates, writes are read in the next
+	// pass
+	next := s.s.nextSharedData
+	if next == nil {
 		return "", nil
 	}
 
-	k := s.s.sharedData.key(module, name)
+	k := next.key(module, name)
 	s.log.With("template", s.t.ImportPath(), "path", k, "data", spew.Sdump(data)).
 		Debug("adding to module hook")
 
@@ -163,17 +173,22 @@ func (s *TplStencil) AddToModuleHook(module, name string, data interface{}) (out
 		interfaceSlice[i] = v.Index(i).Interface()
 	}
 
+	s.deps().markWroteSharedData()
+
 	// if set, append, otherwise assign
-	if _, ok := s.s.sharedData.moduleHooks[k]; ok {
-		s.s.sharedData.moduleHooks[k].values = append(s.s.sharedData.moduleHooks[k].values, interfaceSlice...)
+	next.mu.Lock()
+	defer next.mu.Unlock()
+	if _, ok := next.moduleHooks[k]; ok {
+		next.moduleHooks[k].values = append(next.moduleHooks[k].values, interfaceSlice...)
 	} else {
-		s.s.sharedData.moduleHooks[k] = &moduleHook{values: interfaceSlice}
+		next.moduleHooks[k] = &moduleHook{values: interfaceSlice, templates: make(map[string]bool)}
 	}
+	next.moduleHooks[k].templates[s.t.ImportPath()] = true
 
 	return "", nil
 }
 
-// ReadFile reads a file from the current directory and returns it's contents
+// ReadFile reads a file from the project and returns it's contents
 //
 //	{{ stencil.ReadFile "myfile.txt" }}
 func (s *TplStencil) ReadFile(name string) (string, error) {
@@ -190,7 +205,7 @@ func (s *TplStencil) ReadFile(name string) (string, error) {
 	return string(b), nil
 }
 
-// Exists returns true if the file exists in the current directory
+// Exists returns true if the file exists in the project
 //
 //	{{- if stencil.Exists "myfile.txt" }}
 //	{{ stencil.ReadFile "myfile.txt" }}
@@ -206,12 +221,8 @@ func (s *TplStencil) Exists(name string) bool {
 // exists returns a billy.File if the file exists, and true. If it doesn't,
 // nil is returned and false.
 func (s *TplStencil) exists(name string) (billy.File, bool) {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return nil, false
-	}
-
-	f, err := osfs.New(cwd).Open(name)
+	s.deps().add("file:"+name, hashFile(s.s.fs, name))
+	f, err := s.s.fs.Open(name)
 	if err != nil {
 		return nil, false
 	}
@@ -250,7 +261,7 @@ func (s *TplStencil) ApplyTemplate(name string, dataSli ...interface{}) (string,
 	}
 
 	var buf bytes.Buffer
-	if err := s.t.Module.GetTemplate().ExecuteTemplate(&buf, name, data); err != nil {
+	if err := s.t.tpl.ExecuteTemplate(&buf, name, data); err != nil {
 		return "", err
 	}
 
@@ -272,26 +283,27 @@ func (s *TplStencil) ApplyTemplate(name string, dataSli ...interface{}) (string,
 //	  {{- $data }}
 //	{{- end }}
 func (s *TplStencil) ReadBlocks(fpath string) (map[string]string, error) {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return nil, err
-	}
-
-	// ensure that the file is within the current directory
-	// and not attempting to escape it
-	if _, err := osfs.New(cwd).Stat(fpath); err != nil {
+	// ensure that the file is within the project and not attempting to
+	// escape it
+	if _, err := s.s.fs.Stat(fpath); err != nil {
 		if errors.Is(err, os.ErrNotExist) {
+			s.deps().add("blocks:"+fpath, hashBlocks(nil))
 			return map[string]string{}, nil
 		}
 
 		return nil, err
 	}
 
-	data, err := parseBlocks(fpath)
+	blocks, err := parseBlocks(s.s.fs, fpath, s.t.blockCommentStyles())
 	if err != nil {
 		return nil, err
 	}
+	s.deps().add("blocks:"+fpath, hashBlocks(blocks))
 
+	data := make(map[string]string, len(blocks))
+	for name, b := range blocks {
+		data[name] = b.Contents()
+	}
 	return data, nil
 }
 
@@ -304,3 +316,12 @@ func (s *TplStencil) Debug(args ...interface{}) error {
 	// We have to return something...
 	return nil
 }
+
+// deps returns the inputs read by the current template, which are only
+// recorded while it's rendered for the render cache
+func (s *TplStencil) deps() *renderDeps {
+	if s.t == nil {
+		return nil
+	}
+	return s.t.deps
+}
@@ -69,6 +69,7 @@ func (s *TplStencil) Arg(pth string) (interface{}, error) {
 		}
 	}
 
+	s.deps().addValue("arg:"+pth, v)
 	return v, nil
 }
 
@@ -156,11 +157,11 @@ func (s *TplStencil) resolveFrom(_ context.Context, pth string, arg *configurati
 	return &fromArg, nil
 }
 
-// validateArg validates an argument against the schema
-func (s *TplStencil) validateArg(pth string, arg *configuration.Argument, v interface{}) error {
+// compileArgSchema compiles the JSON schema of the argument at pth
+func compileArgSchema(pth string, arg *configuration.Argument) (*jsonschema.Schema, error) {
 	schemaBuf := new(bytes.Buffer)
 	if err := json.NewEncoder(schemaBuf).Encode(arg.Schema); err != nil {
-		return errors.Wrap(err, "failed to encode schema into JSON")
+		return nil, errors.Wrap(err, "failed to encode schema into JSON")
 	}
 
 	jsc := jsonschema.NewCompiler()
@@ -168,12 +169,21 @@ func (s *TplStencil) validateArg(pth string, arg *configuration.Argument, v inte
 
 	schemaURL := "manifest.yaml/arguments/" + pth
 	if err := jsc.AddResource(schemaURL, schemaBuf); err != nil {
-		return errors.Wrapf(err, "failed to add argument '%s' json schema to compiler", pth)
+		return nil, errors.Wrapf(err, "failed to add argument '%s' json schema to compiler", pth)
 	}
 
 	schema, err := jsc.Compile(schemaURL)
 	if err != nil {
-		return errors.Wrapf(err, "failed to compile argument '%s' schema", pth)
+		return nil, errors.Wrapf(err, "failed to compile argument '%s' schema", pth)
+	}
+	return schema, nil
+}
+
+// validateArg validates an argument against the schema
+func (s *TplStencil) validateArg(pth string, arg *configuration.Argument, v interface{}) error {
+	schema, err := compileArgSchema(pth, arg)
+	if err != nil {
+		return err
 	}
 
 	if err := schema.Validate(v); err != nil {
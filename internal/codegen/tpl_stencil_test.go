@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
 	"go.rgst.io/stencil/internal/modules/modulestest"
 	"go.rgst.io/stencil/pkg/configuration"
 	"go.rgst.io/stencil/pkg/slogext"
@@ -56,7 +57,7 @@ func TestTplStencil_ReadBlocks(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &TplStencil{}
+			s := &TplStencil{s: &Stencil{fs: osfs.New(".")}}
 			got, err := s.ReadBlocks(tt.args.fpath)
 
 			// String checking because errors.Is isn't working
@@ -127,14 +128,10 @@ func TestTplStencil_GetModuleHook(t *testing.T) {
 						log,
 					),
 				),
-				s: &Stencil{sharedData: &sharedData{
-					moduleHooks: make(map[string]*moduleHook),
-					globals:     make(map[string]global),
-				}},
+				s:   &Stencil{sharedData: newSharedData(), nextSharedData: newSharedData()},
 				log: log,
 			}
 
-			s.s.isFirstPass = true
 			for _, insert := range tt.inserts {
 				if _, err := s.AddToModuleHook(s.t.Module.Name, tt.args.name, insert); err != nil {
 					t.Errorf("TplStencil.GetModuleHook() error = %v", err)
@@ -142,15 +139,15 @@ func TestTplStencil_GetModuleHook(t *testing.T) {
 				}
 			}
 
-			// Ensure that GetModuleHook never returns anything other than
-			// `[]any` during the first pass.
+			// Ensure that GetModuleHook never returns data written during
+			// the current pass.
 			if got := s.GetModuleHook(tt.args.name); !reflect.DeepEqual(got, []any{}) {
 				t.Errorf("TplStencil.GetModuleHook() = %v, want %v", got, []any{})
 			}
-			s.s.isFirstPass = false
 
-			// Sort the module hooks, which should be called by stencil before
-			// the second pass
+			// Start the next pass and sort the module hooks, which should be
+			// done by stencil before the next pass
+			s.s.sharedData, s.s.nextSharedData = s.s.nextSharedData, nil
 			s.s.sortModuleHooks()
 
 			if got := s.GetModuleHook(tt.args.name); !reflect.DeepEqual(got, tt.want) {
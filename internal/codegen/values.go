@@ -141,8 +141,10 @@ func (v *Values) WithTemplate(name string) *Values {
 }
 
 // NewValues returns a fully initialized Values
-// based on the current runtime environment.
-func NewValues(ctx context.Context, sm *configuration.Manifest, mods []*modules.Module) *Values {
+// based on the current runtime environment. Git information is read
+// from the repository the project directory, dir, is in. It's not set
+// when dir is empty.
+func NewValues(ctx context.Context, sm *configuration.Manifest, mods []*modules.Module, dir string) *Values {
 	vals := &Values{
 		Git: git{},
 		Runtime: runtime{
@@ -170,9 +172,13 @@ func NewValues(ctx context.Context, sm *configuration.Manifest, mods []*modules.
 		vals.Runtime.Box = &box.Config{}
 	}
 
+	if dir == "" {
+		return vals
+	}
+
 	// If we're a repository, add repository information
-	if r, err := gogit.PlainOpen(""); err == nil {
-		db, err := stencilgit.GetDefaultBranch(ctx, "")
+	if r, err := gogit.PlainOpenWithOptions(dir, &gogit.PlainOpenOptions{DetectDotGit: true}); err == nil {
+		db, err := stencilgit.GetDefaultBranch(ctx, dir)
 		if err != nil {
 			db = "main"
 		}
@@ -0,0 +1,69 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements writing rendered files to the
+// filesystem of a project.
+
+package codegen
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+// WriteFile writes a file at name in fs with the provided contents and
+// mode, or a symlink to target when target isn't empty. The existing
+// file or symlink at name is replaced: symlinks are replaced instead of
+// written through, so that switching between a file and a symlink
+// works.
+func WriteFile(fs billy.Filesystem, name string, contents []byte, mode os.FileMode, target string) error {
+	existing, err := fs.Lstat(name)
+	if err == nil && existing.IsDir() {
+		return fmt.Errorf("failed to write file %q: a directory exists at its path", name)
+	}
+
+	if err == nil && (target != "" || existing.Mode()&os.ModeSymlink != 0) {
+		if err := fs.Remove(name); err != nil {
+			return fmt.Errorf("failed to remove existing file %q: %w", name, err)
+		}
+	}
+
+	if err := fs.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory %q: %w", filepath.Dir(name), err)
+	}
+
+	if target != "" {
+		if err := fs.Symlink(target, name); err != nil {
+			return fmt.Errorf("failed to create symlink %q: %w", name, err)
+		}
+		return nil
+	}
+
+	if err := util.WriteFile(fs, name, contents, mode.Perm()); err != nil {
+		return fmt.Errorf("failed to write file %q: %w", name, err)
+	}
+
+	// Writing a file only sets the mode when creating it, and is subject
+	// to the umask, so always apply it when the filesystem supports it.
+	if cfs, ok := fs.(billy.Change); ok {
+		if err := cfs.Chmod(name, mode.Perm()); err != nil {
+			return fmt.Errorf("failed to set mode of file %q: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codegen
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"gotest.tools/v3/assert"
+)
+
+func TestWriteFileReplacesFilesAndSymlinks(t *testing.T) {
+	fs := memfs.New()
+	assert.NilError(t, util.WriteFile(fs, "target.txt", []byte("target"), 0o644))
+
+	assert.NilError(t, WriteFile(fs, "dir/file", []byte("hello"), 0o755, ""))
+	b, err := util.ReadFile(fs, "dir/file")
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), "hello")
+
+	// A file is replaced by a symlink
+	assert.NilError(t, WriteFile(fs, "dir/file", nil, 0o644, "../target.txt"))
+	target, err := fs.Readlink("dir/file")
+	assert.NilError(t, err)
+	assert.Equal(t, target, "../target.txt")
+
+	// A symlink is replaced, not written through
+	assert.NilError(t, WriteFile(fs, "dir/file", []byte("again"), 0o644, ""))
+	inf, err := fs.Lstat("dir/file")
+	assert.NilError(t, err)
+	assert.Equal(t, inf.Mode()&os.ModeSymlink, os.FileMode(0))
+	b, err = util.ReadFile(fs, "target.txt")
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), "target")
+
+	assert.ErrorContains(t, WriteFile(fs, "dir", []byte("hello"), 0o644, ""), "a directory exists at its path")
+}
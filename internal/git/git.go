@@ -14,7 +14,9 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 	"go.rgst.io/stencil/internal/testing/cmdexec"
+	"go.rgst.io/stencil/internal/tracing"
 )
 
 // This block contains errors and regexes
@@ -52,7 +54,10 @@ func GetDefaultBranch(ctx context.Context, path string) (string, error) {
 // Clone clone a git repository to a temporary directory and returns the
 // path to the repository. If ref is empty, the default branch will be
 // used.
-func Clone(ctx context.Context, ref, url string) (string, error) {
+func Clone(ctx context.Context, ref, url string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "git.Clone", attribute.String("git.url", url), attribute.String("git.ref", ref))
+	defer func() { tracing.End(span, err) }()
+
 	tempDir, err := os.MkdirTemp("", "stencil-"+strings.ReplaceAll(url, "/", "-"))
 	if err != nil {
 		return "", errors.Wrap(err, "failed to create temporary directory")
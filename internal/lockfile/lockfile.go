@@ -0,0 +1,84 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lockfile contains the types of the lockfile generated by
+// stencil. They're exposed publicly by the pkg/stencil package, but
+// live here so that internal packages, which pkg/stencil depends on,
+// can use them as well.
+package lockfile
+
+import "go.rgst.io/stencil/internal/modules/resolver"
+
+// Name is the name of the lockfile used by stencil
+const Name = "stencil.lock"
+
+// DefaultFileMode is the mode of files that don't record one in the
+// lockfile.
+const DefaultFileMode = "0644"
+
+// ModuleEntry is an entry in the lockfile for a module
+// that was used during the last run of stencil.
+type ModuleEntry struct {
+	// Name is the name of the module. This usually comes from
+	// the TemplateManifest entry, but is up to the module
+	// package.
+	Name string
+
+	// URL is the url of the module that was used.
+	URL string
+
+	// Version is the version of the module that was
+	// downloaded at the time.
+	Version *resolver.Version
+}
+
+// FileEntry is an entry in the lockfile for a file
+// that was generated by stencil. This contains metadata on what
+// generated it among other future information
+type FileEntry struct {
+	// Name is the relative file path, to the invocation of stencil,
+	// of the generated file
+	Name string
+
+	// Template is the template that generated this file in the given
+	// module.
+	Template string
+
+	// Module is the URL of the module that generated this file.
+	Module string
+
+	// Mode are the permissions of the file, in octal (e.g. 0755). It's
+	// empty when the file has the DefaultFileMode.
+	Mode string `yaml:"mode,omitempty"`
+
+	// Symlink is the target of the file, if it's a symlink
+	Symlink string `yaml:"symlink,omitempty"`
+}
+
+// Lockfile is generated by stencil on a ran to store version
+// information.
+type Lockfile struct {
+	// Version correlates to the version of bootstrap
+	// that generated this file.
+	Version string `yaml:"version"`
+
+	// Modules is a list of modules and their versions that was
+	// used the last time stencil was ran.
+	// Note: This is only set in stencil.lock
+	Modules []*ModuleEntry `yaml:"modules"`
+
+	// Files is a list of files and metadata about them that were
+	// generated by stencil
+	Files []*FileEntry `yaml:"files"`
+}
@@ -0,0 +1,87 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements a cache of modules that is shared
+// between multiple projects.
+
+package modules
+
+import (
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	"go.rgst.io/stencil/internal/modules/resolver"
+)
+
+// Cache is shared between the projects rendered by a single stencil
+// invocation, so that the versions of a module are only listed, and a
+// version of a module is only fetched, once. A nil *Cache is valid and
+// caches nothing.
+type Cache struct {
+	// resolver resolves the versions of modules
+	resolver *resolver.Resolver
+
+	// mu protects fs
+	mu sync.Mutex
+
+	// fs are the filesystems of fetched modules, keyed by their URI and
+	// version
+	fs map[string]billy.Filesystem
+}
+
+// NewCache returns a new, empty, Cache
+func NewCache() *Cache {
+	return &Cache{
+		resolver: resolver.NewResolver(),
+		fs:       make(map[string]billy.Filesystem),
+	}
+}
+
+// cacheKey returns the key of the module at uri with version v
+func cacheKey(uri string, v *resolver.Version) string {
+	return uri + "@" + v.GitRef() + "#" + v.Commit
+}
+
+// getResolver returns the resolver to resolve versions of modules with,
+// creating a new one if c is nil
+func (c *Cache) getResolver() *resolver.Resolver {
+	if c == nil {
+		return resolver.NewResolver()
+	}
+	return c.resolver
+}
+
+// get returns the filesystem of the module at uri with version v, if
+// it was fetched before
+func (c *Cache) get(uri string, v *resolver.Version) (billy.Filesystem, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fs, ok := c.fs[cacheKey(uri, v)]
+	return fs, ok
+}
+
+// set stores the filesystem of the module at uri with version v
+func (c *Cache) set(uri string, v *resolver.Version, fs billy.Filesystem) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fs[cacheKey(uri, v)] = fs
+}
@@ -20,7 +20,9 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"text/template"
 
 	"github.com/Masterminds/sprig/v3"
@@ -46,6 +48,10 @@ type Module struct {
 	// Note: We don't currently support sharing templates across modules.
 	t *template.Template
 
+	// tmu protects t, which templates of the module are parsed into and
+	// cloned from concurrently
+	tmu sync.Mutex
+
 	// Manifest is the module's manifest information/configuration
 	Manifest *configuration.TemplateRepositoryManifest
 
@@ -63,6 +69,18 @@ type Module struct {
 	// fs is underlying filesystem for this module
 	fs billy.Filesystem
 
+	// storageDir is the directory fs is stored in on disk, empty until
+	// it's fetched or when it isn't on disk (e.g. NewModuleOpts.FS)
+	storageDir string
+
+	// dir is the directory of the project that uses this module, which
+	// relative local URIs are resolved against
+	dir string
+
+	// cache is the optional cache that fetched filesystems are shared
+	// through
+	cache *Cache
+
 	// dirReplacementsRendered is a rendered list of dirReplacements from the manifest,
 	// ready to be used for immediate replacements.  It's a mapping of relative paths
 	// to just the replacement name for the last path segment.
@@ -97,6 +115,15 @@ type NewModuleOpts struct {
 	// FS is an optional filesystem to use for the module. When set, it
 	// will be used instead of fetching the module from the network/disk.
 	FS billy.Filesystem
+
+	// Dir is the directory of the project that uses the module. When
+	// set, relative local URIs are resolved against it instead of the
+	// current working directory.
+	Dir string
+
+	// Cache is an optional cache to share fetched modules through, see
+	// [Cache].
+	Cache *Cache
 }
 
 // New creates a new module from a TemplateRepository. Version must be
@@ -127,6 +154,8 @@ func New(ctx context.Context, uri string, opts NewModuleOpts) (*Module, error) {
 		URI:     uri,
 		Version: opts.Version,
 		fs:      opts.FS,
+		dir:     opts.Dir,
+		cache:   opts.Cache,
 	}
 
 	mani, err := m.getManifest(ctx)
@@ -143,15 +172,43 @@ func (m *Module) GetTemplate() *template.Template {
 	return m.t
 }
 
+// ParseTemplate parses text as the template with the provided name into
+// the go template of this module, see GetTemplate, making it available
+// to the other templates of this module.
+func (m *Module) ParseTemplate(name, text string, funcs template.FuncMap) error {
+	m.tmu.Lock()
+	defer m.tmu.Unlock()
+
+	_, err := m.t.New(name).Funcs(funcs).Parse(text)
+	return err
+}
+
+// CloneTemplate returns a copy of the go template of this module, see
+// GetTemplate. Templates are executed on a copy so that multiple
+// templates of this module can be executed concurrently, each with
+// their own functions.
+func (m *Module) CloneTemplate() (*template.Template, error) {
+	m.tmu.Lock()
+	defer m.tmu.Unlock()
+
+	return m.t.Clone()
+}
+
 // RegisterExtensions registers all extensions provided by the given
 // module. If the module is a local file URI then extensions will be
-// sourced from the `./bin` directory of the base of the path.
+// sourced from the `./bin` directory of the base of the path
+// (`bin/plugin`, or `bin/plugin.wasm` for WebAssembly extensions).
 func (m *Module) RegisterExtensions(ctx context.Context, ext *nativeext.Host) error {
 	// Only register extensions if this repository declares extensions explicitly in its type.
-	if !m.Manifest.Type.Contains(configuration.TemplateRepositoryTypeExt) {
+	wasm := m.Manifest.Type.Contains(configuration.TemplateRepositoryTypeWASMExt)
+	if !m.Manifest.Type.Contains(configuration.TemplateRepositoryTypeExt) && !wasm {
 		return nil
 	}
-	return ext.RegisterExtension(ctx, m.URI, m.Name, m.Version)
+	return ext.RegisterExtension(ctx, m.source(), m.Name, m.Version, &nativeext.RegisterExtensionOpts{
+		Releases:  m.Manifest.ExtensionSource,
+		WASM:      wasm,
+		ModuleDir: m.storageDir,
+	})
 }
 
 // getManifest downloads the module if not already downloaded and
@@ -193,7 +250,8 @@ func (m *Module) GetFS(ctx context.Context) (billy.Filesystem, error) {
 		return m.fs, nil
 	}
 
-	u, err := giturls.Parse(m.URI)
+	source := m.source()
+	u, err := giturls.Parse(source)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse module URI: %w", err)
 	}
@@ -201,8 +259,13 @@ func (m *Module) GetFS(ctx context.Context) (billy.Filesystem, error) {
 	var storageDir string
 	if u.Scheme == "file" {
 		// File URLs are already on disk, so just use that path.
-		storageDir = strings.TrimPrefix(m.URI, "file://")
+		storageDir = strings.TrimPrefix(source, "file://")
 	} else {
+		if fs, ok := m.cache.get(m.URI, m.Version); ok {
+			m.fs, m.storageDir = fs, fs.Root()
+			return m.fs, nil
+		}
+
 		var err error
 		storageDir, err = git.Clone(ctx, m.Version.GitRef(), m.URI)
 		if err != nil {
@@ -223,10 +286,34 @@ func (m *Module) GetFS(ctx context.Context) (billy.Filesystem, error) {
 		}
 	}
 
-	m.fs = osfs.New(storageDir)
+	m.fs, m.storageDir = osfs.New(storageDir), storageDir
+	if u.Scheme != "file" {
+		m.cache.set(m.URI, m.Version, m.fs)
+	}
 	return m.fs, nil
 }
 
+// source returns the URI to fetch the module from, which is its URI
+// with relative local paths resolved against the directory of the
+// project that uses it.
+func (m *Module) source() string {
+	if m.dir == "" || !uriIsLocal(m.URI) {
+		return m.URI
+	}
+
+	path := strings.TrimPrefix(m.URI, "file://")
+	if filepath.IsAbs(path) {
+		return m.URI
+	}
+	return "file://" + filepath.Join(m.dir, path)
+}
+
+// DirReplacements returns the rendered directory replacements stored by
+// StoreDirReplacements
+func (m *Module) DirReplacements() map[string]string {
+	return m.dirReplacementsRendered
+}
+
 // StoreDirReplacements pokes the template-rendered output from the stencil render
 // function for use by the module rendering later on via ApplyDirReplacements.
 func (m *Module) StoreDirReplacements(reps map[string]string) {
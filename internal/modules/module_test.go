@@ -341,3 +341,26 @@ func TestSimpleDirReplacement(t *testing.T) {
 
 	assert.Equal(t, m.ApplyDirReplacements("a/base"), "b/base")
 }
+
+func TestLocalReplacementIsRelativeToDir(t *testing.T) {
+	sm := &configuration.Manifest{
+		Name: "testing-project",
+		Modules: []*configuration.TemplateRepository{
+			{
+				Name: "github.com/getoutreach/stencil-base",
+			},
+		},
+		Replacements: map[string]string{
+			"github.com/getoutreach/stencil-base": "file://modules/testdata",
+		},
+	}
+
+	mods, err := modules.FetchModules(context.Background(), &modules.ModuleResolveOptions{
+		Manifest: sm,
+		Log:      newLogger(t),
+		Dir:      "..",
+	})
+	assert.NilError(t, err, "expected FetchModules() to resolve the replacement relative to Dir")
+	assert.Equal(t, len(mods), 1, "expected exactly one module to be returned")
+	assert.Equal(t, mods[0].URI, "file://modules/testdata", "expected module to keep the replacement URI")
+}
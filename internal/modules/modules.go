@@ -15,6 +15,7 @@ import (
 
 	"github.com/Masterminds/semver/v3"
 	"go.rgst.io/stencil/internal/modules/resolver"
+	"go.rgst.io/stencil/internal/tracing"
 	"go.rgst.io/stencil/pkg/configuration"
 	"go.rgst.io/stencil/pkg/slogext"
 )
@@ -65,6 +66,15 @@ type ModuleResolveOptions struct {
 	// in the manifest. This is mainly meant for tests/importing of stencil
 	// as these modules will be used instead of fetching them.
 	Replacements map[string]*Module
+
+	// Dir is the directory of the project, relative local replacements
+	// are resolved against it. Defaults to the current working
+	// directory.
+	Dir string
+
+	// Cache is an optional cache that is shared with other projects,
+	// see [Cache].
+	Cache *Cache
 }
 
 // criteriaForVersionString returns a resolver.Criteria for a given
@@ -125,14 +135,18 @@ func resolutionError(importPath string, history []history) error {
 // FetchModules fetches modules for a given Manifest. See
 // [ModuleResolveOptions] for more information on the various options
 // that this function supports.
-func FetchModules(ctx context.Context, opts *ModuleResolveOptions) ([]*Module, error) {
+func FetchModules(ctx context.Context, opts *ModuleResolveOptions) (_ []*Module, err error) {
+	ctx, span := tracing.Start(ctx, "modules.FetchModules")
+	defer func() { tracing.End(span, err) }()
+
 	// Used to track which modules to resolve and which one's have been
 	// resolved, for returning later.
 	resolveList := make([]resolveModule, 0)
 	modules := make(map[string]*resolvedModule)
 
-	// Create a new resolver
-	r := resolver.NewResolver()
+	// Use the resolver of the cache, so that versions are only listed
+	// once across projects
+	r := opts.Cache.getResolver()
 
 	// For each module in the manifest, add it to the list of modules
 	// to be resolved.
@@ -209,8 +223,7 @@ func FetchModules(ctx context.Context, opts *ModuleResolveOptions) ([]*Module, e
 				criteria = append(criteria, h.criteria)
 			}
 
-			var err error
-			version, err = r.Resolve(ctx, uri, criteria...)
+			version, err = resolveVersion(ctx, r, importPath, uri, criteria)
 			if err != nil {
 				return nil, resolutionError(importPath, modules[importPath].history)
 			}
@@ -229,10 +242,11 @@ func FetchModules(ctx context.Context, opts *ModuleResolveOptions) ([]*Module, e
 		if opts.Replacements[importPath] != nil {
 			m = opts.Replacements[importPath]
 		} else {
-			var err error
 			m, err = New(ctx, uri, NewModuleOpts{
 				ImportPath: importPath,
 				Version:    version,
+				Dir:        opts.Dir,
+				Cache:      opts.Cache,
 			})
 			if err != nil {
 				return nil, err
@@ -265,3 +279,18 @@ func FetchModules(ctx context.Context, opts *ModuleResolveOptions) ([]*Module, e
 	}
 	return modulesList, nil
 }
+
+// resolveVersion resolves the version of the module with the provided
+// import path, fetched from uri, that satisfies all of criteria
+func resolveVersion(ctx context.Context, r *resolver.Resolver, importPath, uri string,
+	criteria []*resolver.Criteria) (_ *resolver.Version, err error) {
+	ctx, span := tracing.Start(ctx, "modules.ResolveVersion", tracing.ModuleKey.String(importPath))
+	defer func() { tracing.End(span, err) }()
+
+	v, err := r.Resolve(ctx, uri, criteria...)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(tracing.VersionKey.String(v.String()))
+	return v, nil
+}
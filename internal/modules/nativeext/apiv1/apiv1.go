@@ -7,7 +7,12 @@
 // written in Go.
 package apiv1
 
-import "encoding/gob"
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"strings"
+)
 
 // init registers known types
 func init() { //nolint:gochecknoinits // Why: see comment
@@ -41,9 +46,110 @@ type TemplateFunction struct {
 	//  extensions.<extensionLowerName>.<name>
 	Name string
 
+	// Description is a human readable description of the template
+	// function, used when generating documentation.
+	Description string
+
 	// NumberOfArguments is the number of arguments that the
-	// template function takes.
+	// template function takes. This is ignored if Arguments is set.
 	NumberOfArguments int
+
+	// Arguments describes the arguments that the template function
+	// takes. When set, calls to the template function are validated
+	// against it by the extension host before being sent to the
+	// extension.
+	Arguments []TemplateFunctionArgument
+
+	// ReturnType is the type of the value returned by the template
+	// function (see the ArgumentType* constants), used when generating
+	// documentation.
+	ReturnType string
+}
+
+// This block contains the types that can be used for arguments (and
+// return values) of a template function.
+const (
+	// ArgumentTypeAny accepts any value, this is the default
+	ArgumentTypeAny = "any"
+
+	// ArgumentTypeString accepts a string
+	ArgumentTypeString = "string"
+
+	// ArgumentTypeBool accepts a boolean
+	ArgumentTypeBool = "bool"
+
+	// ArgumentTypeInt accepts any integer, or a float without a
+	// fractional part. The extension receives an int.
+	ArgumentTypeInt = "int"
+
+	// ArgumentTypeFloat accepts any number. The extension receives a
+	// float64.
+	ArgumentTypeFloat = "float"
+
+	// ArgumentTypeList accepts any slice or array. The extension
+	// receives a []interface{}.
+	ArgumentTypeList = "list"
+
+	// ArgumentTypeMap accepts any map with string keys. The extension
+	// receives a map[string]interface{}.
+	ArgumentTypeMap = "map"
+)
+
+// TemplateFunctionArgument describes an argument of a template
+// function.
+type TemplateFunctionArgument struct {
+	// Name of the argument, used in errors and documentation.
+	Name string
+
+	// Type of the argument, one of the ArgumentType* constants. Defaults
+	// to ArgumentTypeAny.
+	Type string
+
+	// Description is a human readable description of the argument, used
+	// when generating documentation.
+	Description string
+
+	// Optional denotes that the argument may be omitted. Only trailing
+	// arguments may be optional.
+	Optional bool
+}
+
+// Signature returns a human readable signature of the template
+// function, e.g. ParseGoMod(path string, contents string) any
+func (t *TemplateFunction) Signature() string {
+	var sb strings.Builder
+	sb.WriteString(t.Name)
+	sb.WriteString("(")
+	if t.Arguments == nil {
+		for i := 0; i < t.NumberOfArguments; i++ {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			fmt.Fprintf(&sb, "arg%d any", i+1)
+		}
+	}
+	for i, arg := range t.Arguments {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(arg.Name)
+		if arg.Optional {
+			sb.WriteString("?")
+		}
+		sb.WriteString(" ")
+		sb.WriteString(TypeOrAny(arg.Type))
+	}
+	sb.WriteString(") ")
+	sb.WriteString(TypeOrAny(t.ReturnType))
+	return sb.String()
+}
+
+// TypeOrAny returns typ, or ArgumentTypeAny if typ is empty
+func TypeOrAny(typ string) string {
+	if typ == "" {
+		return ArgumentTypeAny
+	}
+	return typ
 }
 
 // TemplateFunctionExec executes a template function
@@ -74,3 +180,15 @@ type Implementation interface {
 	// and returns its response.
 	ExecuteTemplateFunction(t *TemplateFunctionExec) (interface{}, error)
 }
+
+// ContextImplementation is an Implementation whose template functions
+// can be stopped, e.g. a WebAssembly extension. The extension host uses
+// it, when implemented, to stop calls once rendering is stopped.
+type ContextImplementation interface {
+	Implementation
+
+	// ExecuteTemplateFunctionContext executes a provided template
+	// function, like ExecuteTemplateFunction, and stops it once ctx is
+	// done.
+	ExecuteTemplateFunctionContext(ctx context.Context, t *TemplateFunctionExec) (interface{}, error)
+}
@@ -2,6 +2,8 @@
 
 // Description: This file implements the plugin client (stencil -> plugin)
 
+//go:build !wasip1
+
 package apiv1
 
 import (
@@ -40,17 +42,21 @@ func NewExtensionClient(ctx context.Context, extPath string, log slogext.Logger)
 
 	rpcClient, err := client.Client()
 	if err != nil {
+		client.Kill()
 		return nil, func() error { return nil }, errors.Wrap(err, "failed to create connection to extension")
 	}
 
+	// The extension is running from here on, so stop it if we fail
+	kill := func() error { client.Kill(); return nil }
+
 	raw, err := rpcClient.Dispense(Name)
 	if err != nil {
-		return nil, func() error { return nil }, errors.Wrap(err, "failed to setup extension connection over extension")
+		return nil, kill, errors.Wrap(err, "failed to setup extension connection over extension")
 	}
 
 	ext, ok := raw.(implementationTransport)
 	if !ok {
-		return nil, func() error { return nil }, fmt.Errorf("failed to create apiv1.Implementation from type %s", reflect.TypeOf(raw).String())
+		return nil, kill, fmt.Errorf("failed to create apiv1.Implementation from type %s", reflect.TypeOf(raw).String())
 	}
 
 	return newImplementationTransportToImplementation(ext), rpcClient.Close, nil
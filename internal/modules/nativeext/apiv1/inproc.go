@@ -0,0 +1,83 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements an in-process client that
+// serializes data the same way the go-plugin transport does.
+
+package apiv1
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/pkg/errors"
+)
+
+// _ is a compile time assertion we implement the interface
+var _ implementationTransport = &inprocTransport{}
+
+// NewInprocExtensionClient returns an Implementation that calls impl
+// within the same process, but serializes all requests and responses
+// the same way as the go-plugin transport. This allows testing how an
+// Implementation behaves over the wire without starting a process.
+func NewInprocExtensionClient(impl Implementation) Implementation {
+	return newImplementationTransportToImplementation(&inprocTransport{newImplementationToImplementationTransport(impl)})
+}
+
+// inprocTransport implements implementationTransport by round tripping
+// all data through gob, like net/rpc does.
+type inprocTransport struct {
+	impl implementationTransport
+}
+
+// gobRoundTrip encodes and decodes v with gob, returning the decoded
+// value.
+func gobRoundTrip[T any](v T) (T, error) {
+	var out T
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&v); err != nil {
+		return out, errors.Wrap(err, "failed to encode data")
+	}
+
+	err := gob.NewDecoder(&buf).Decode(&out)
+	return out, errors.Wrap(err, "failed to decode data")
+}
+
+// GetConfig returns the config for the extension
+func (t *inprocTransport) GetConfig() (*Config, error) {
+	resp, err := t.impl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return gobRoundTrip(resp)
+}
+
+// GetTemplateFunctions returns the template functions for this extension
+func (t *inprocTransport) GetTemplateFunctions() ([]*TemplateFunction, error) {
+	resp, err := t.impl.GetTemplateFunctions()
+	if err != nil {
+		return nil, err
+	}
+	return gobRoundTrip(resp)
+}
+
+// ExecuteTemplateFunction executes a template function for this extension
+func (t *inprocTransport) ExecuteTemplateFunction(exec *TemplateFunctionExec) ([]byte, error) {
+	exec, err := gobRoundTrip(exec)
+	if err != nil {
+		return nil, err
+	}
+	return t.impl.ExecuteTemplateFunction(exec)
+}
@@ -2,6 +2,8 @@
 
 // Description: Implements the plugin RPC logic for the extension host
 
+//go:build !wasip1
+
 package apiv1
 
 import (
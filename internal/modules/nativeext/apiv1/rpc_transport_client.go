@@ -2,6 +2,8 @@
 
 // Description: This file implements the rpc client transport for go-plugin
 
+//go:build !wasip1
+
 package apiv1
 
 import (
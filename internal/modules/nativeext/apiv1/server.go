@@ -3,6 +3,8 @@
 // Description: Implements a plugin Implementation
 // for the extensions host.
 
+//go:build !wasip1
+
 package apiv1
 
 import (
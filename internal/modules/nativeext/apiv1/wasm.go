@@ -0,0 +1,101 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements the protocol used by WebAssembly
+// (WASI) extensions and the extension side of it.
+
+package apiv1
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// This block contains the methods that can be called on a WebAssembly
+// extension. They map 1:1 to the methods of Implementation.
+const (
+	wasmMethodGetConfig               = "GetConfig"
+	wasmMethodGetTemplateFunctions    = "GetTemplateFunctions"
+	wasmMethodExecuteTemplateFunction = "ExecuteTemplateFunction"
+)
+
+// wasmRequest is the request written to the stdin of a WebAssembly
+// extension. Each invocation of the extension handles exactly one
+// request.
+type wasmRequest struct {
+	// Method is the Implementation method to call
+	Method string `json:"method"`
+
+	// Exec is the template function to execute, only set when Method
+	// is ExecuteTemplateFunction.
+	Exec *TemplateFunctionExec `json:"exec,omitempty"`
+}
+
+// wasmResponse is the response written to the stdout of a WebAssembly
+// extension.
+type wasmResponse struct {
+	// Result is the JSON encoded return value of the method
+	Result json.RawMessage `json:"result,omitempty"`
+
+	// Error is the error returned by the method, if any
+	Error string `json:"error,omitempty"`
+}
+
+// NewWASMExtensionImplementation serves impl as a WebAssembly (WASI)
+// extension. This should be called from the main function of an
+// extension compiled with GOOS=wasip1 GOARCH=wasm.
+//
+// Unlike NewExtensionImplementation, the extension is executed once per
+// call, reading a single request from stdin and writing the response to
+// stdout. Logs should be written to stderr.
+func NewWASMExtensionImplementation(impl Implementation) error {
+	return serveWASM(impl, os.Stdin, os.Stdout)
+}
+
+// serveWASM reads a single wasmRequest from r, calls impl and writes the
+// wasmResponse to w.
+func serveWASM(impl Implementation, r io.Reader, w io.Writer) error {
+	var req wasmRequest
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
+		return fmt.Errorf("failed to decode request: %w", err)
+	}
+
+	var result any
+	var err error
+	switch req.Method {
+	case wasmMethodGetConfig:
+		result, err = impl.GetConfig()
+	case wasmMethodGetTemplateFunctions:
+		result, err = impl.GetTemplateFunctions()
+	case wasmMethodExecuteTemplateFunction:
+		if req.Exec == nil {
+			err = fmt.Errorf("missing template function to execute")
+			break
+		}
+		result, err = impl.ExecuteTemplateFunction(req.Exec)
+	default:
+		err = fmt.Errorf("unknown method %q", req.Method)
+	}
+
+	var resp wasmResponse
+	if err != nil {
+		resp.Error = err.Error()
+	} else if resp.Result, err = json.Marshal(result); err != nil {
+		resp.Error = fmt.Sprintf("failed to encode response: %v", err)
+	}
+
+	return json.NewEncoder(w).Encode(&resp)
+}
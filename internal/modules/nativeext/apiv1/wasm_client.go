@@ -0,0 +1,199 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements the WebAssembly extension client
+// (stencil -> extension)
+
+//go:build !wasip1
+
+package apiv1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"go.rgst.io/stencil/pkg/slogext"
+)
+
+// _ is a compile time assertion we implement the interface
+var _ ContextImplementation = &wasmClient{}
+
+// Limits of WebAssembly extensions
+const (
+	// WASMCallTimeout is the maximum amount of time a call to a
+	// WebAssembly extension may take before it's stopped
+	WASMCallTimeout = time.Minute
+
+	// WASMMemoryLimitPages is the maximum amount of memory a WebAssembly
+	// extension may use, in pages of 64KiB (256MiB)
+	WASMMemoryLimitPages = 4096
+
+	// WASMOutputLimit is the maximum amount of bytes a call to a
+	// WebAssembly extension may write to stdout, and to stderr, before
+	// it's stopped
+	WASMOutputLimit = 16 << 20
+)
+
+// wasmClient implements Implementation by executing a WebAssembly
+// extension with an embedded runtime. The extension is instantiated
+// for every call and has no access to the filesystem, network or
+// environment of the host.
+type wasmClient struct {
+	log     slogext.Logger
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+
+	// ctx is the context calls without a context of their own (e.g.
+	// GetConfig) are made in, they're stopped once it's done
+	ctx context.Context
+}
+
+// limitedWriter is an io.Writer that stores up to limit bytes, calling
+// stop and failing writes once more are written
+type limitedWriter struct {
+	buf   bytes.Buffer
+	limit int
+	stop  func()
+
+	// exceeded is true once more than limit bytes were written
+	exceeded bool
+}
+
+// Write implements io.Writer
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.exceeded || w.buf.Len()+len(p) > w.limit {
+		w.exceeded = true
+		w.stop()
+		return 0, fmt.Errorf("output exceeds the limit of %d bytes", w.limit)
+	}
+	return w.buf.Write(p)
+}
+
+// NewWASMExtensionClient creates a new Implementation from the
+// WebAssembly (WASI) module at extPath. Calls to the extension are
+// stopped once ctx, or the context of the call for
+// ExecuteTemplateFunctionContext, is done, when they take longer than
+// WASMCallTimeout or when they write more than WASMOutputLimit bytes.
+func NewWASMExtensionClient(ctx context.Context, extPath string, log slogext.Logger) (Implementation, func() error, error) {
+	b, err := os.ReadFile(extPath)
+	if err != nil {
+		return nil, func() error { return nil }, errors.Wrap(err, "failed to read extension")
+	}
+
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(WASMMemoryLimitPages))
+	closer := func() error { return r.Close(context.Background()) }
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		return nil, closer, errors.Wrap(err, "failed to setup WASI")
+	}
+
+	mod, err := r.CompileModule(ctx, b)
+	if err != nil {
+		return nil, closer, errors.Wrap(err, "failed to compile extension")
+	}
+
+	return &wasmClient{log, r, mod, ctx}, closer, nil
+}
+
+// call executes the extension with the provided request and decodes
+// the result into resp. The extension is stopped once ctx is done.
+func (c *wasmClient) call(ctx context.Context, req *wasmRequest, resp any) error {
+	ctx, cancel := context.WithTimeout(ctx, WASMCallTimeout)
+	defer cancel()
+
+	in, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode request")
+	}
+
+	stdout := &limitedWriter{limit: WASMOutputLimit, stop: cancel}
+	stderr := &limitedWriter{limit: WASMOutputLimit, stop: cancel}
+	conf := wazero.NewModuleConfig().
+		// An empty name allows the module to be instantiated multiple times.
+		WithName("").
+		WithArgs("extension").
+		WithStdin(bytes.NewReader(in)).
+		WithStdout(stdout).
+		WithStderr(stderr)
+
+	mod, err := c.runtime.InstantiateModule(ctx, c.module, conf)
+	if mod != nil {
+		defer mod.Close(ctx)
+	}
+	if stderr.buf.Len() > 0 {
+		c.log.With("method", req.Method).Debugf("[wasm] %s", strings.TrimSpace(stderr.buf.String()))
+	}
+	if stdout.exceeded || stderr.exceeded {
+		return fmt.Errorf("failed to execute extension, it wrote more than %d bytes of output", WASMOutputLimit)
+	}
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("failed to execute extension, it was stopped: %w", ctxErr)
+		}
+		return fmt.Errorf("failed to execute extension: %w", err)
+	}
+
+	var wresp wasmResponse
+	if err := json.NewDecoder(&stdout.buf).Decode(&wresp); err != nil {
+		return errors.Wrap(err, "failed to decode extension response")
+	}
+	if wresp.Error != "" {
+		return errors.New(wresp.Error)
+	}
+
+	return errors.Wrap(json.Unmarshal(wresp.Result, resp), "failed to decode extension result")
+}
+
+// GetConfig returns the config for the extension
+func (c *wasmClient) GetConfig() (*Config, error) {
+	var resp *Config
+	err := c.call(c.ctx, &wasmRequest{Method: wasmMethodGetConfig}, &resp)
+	return resp, err
+}
+
+// GetTemplateFunctions returns the template functions for this extension
+func (c *wasmClient) GetTemplateFunctions() ([]*TemplateFunction, error) {
+	var resp []*TemplateFunction
+	err := c.call(c.ctx, &wasmRequest{Method: wasmMethodGetTemplateFunctions}, &resp)
+	return resp, err
+}
+
+// ExecuteTemplateFunction executes a template function for this extension
+func (c *wasmClient) ExecuteTemplateFunction(t *TemplateFunctionExec) (interface{}, error) {
+	return c.ExecuteTemplateFunctionContext(c.ctx, t)
+}
+
+// ExecuteTemplateFunctionContext executes a template function for this
+// extension, it's stopped once ctx, or the context the extension was
+// created with, is done
+func (c *wasmClient) ExecuteTemplateFunctionContext(ctx context.Context, t *TemplateFunctionExec) (interface{}, error) {
+	// Stop the call when either context is done
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(c.ctx, cancel)
+	defer stop()
+
+	var resp interface{}
+	err := c.call(ctx, &wasmRequest{Method: wasmMethodExecuteTemplateFunction, Exec: t}, &resp)
+	return resp, err
+}
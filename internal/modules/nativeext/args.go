@@ -0,0 +1,144 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements validation of the arguments passed
+// to extension template functions.
+
+package nativeext
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	"go.rgst.io/stencil/internal/modules/nativeext/apiv1"
+)
+
+// checkArguments validates args against the arguments declared by fn,
+// returning the arguments converted into the types that the extension
+// expects (see the apiv1.ArgumentType* constants).
+func checkArguments(fn *apiv1.TemplateFunction, args []interface{}) ([]interface{}, error) {
+	// Extensions that don't declare their arguments only declare how
+	// many arguments they take at most.
+	if fn.Arguments == nil {
+		if len(args) > fn.NumberOfArguments {
+			return nil, fmt.Errorf("too many arguments, expected %d, got %d", fn.NumberOfArguments, len(args))
+		}
+		return args, nil
+	}
+
+	if len(args) > len(fn.Arguments) {
+		return nil, fmt.Errorf("too many arguments, expected %d, got %d", len(fn.Arguments), len(args))
+	}
+
+	if required := requiredArguments(fn); len(args) < required {
+		return nil, fmt.Errorf("not enough arguments, expected %d, got %d: missing argument %q",
+			required, len(args), fn.Arguments[len(args)].Name)
+	}
+
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		decl := &fn.Arguments[i]
+		v, err := convertArgument(decl.Type, arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d (%q): %w", i+1, decl.Name, err)
+		}
+		converted[i] = v
+	}
+	return converted, nil
+}
+
+// requiredArguments returns the number of arguments of fn that are not
+// optional.
+func requiredArguments(fn *apiv1.TemplateFunction) int {
+	n := 0
+	for i := range fn.Arguments {
+		if !fn.Arguments[i].Optional {
+			n = i + 1
+		}
+	}
+	return n
+}
+
+// convertArgument converts v into the representation used for the
+// provided type, returning an error if v isn't of that type.
+//
+//nolint:gocyclo // Why: it's a flat switch over the supported types.
+func convertArgument(typ string, v interface{}) (interface{}, error) {
+	if typ == "" || typ == apiv1.ArgumentTypeAny {
+		return v, nil
+	}
+
+	if v == nil {
+		return nil, fmt.Errorf("expected %s, got nil", typ)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch typ {
+	case apiv1.ArgumentTypeString:
+		if rv.Kind() == reflect.String {
+			return rv.String(), nil
+		}
+	case apiv1.ArgumentTypeBool:
+		if rv.Kind() == reflect.Bool {
+			return rv.Bool(), nil
+		}
+	case apiv1.ArgumentTypeInt:
+		switch {
+		case rv.CanInt():
+			return int(rv.Int()), nil
+		case rv.CanUint():
+			return int(rv.Uint()), nil
+		case rv.CanFloat() && rv.Float() == math.Trunc(rv.Float()):
+			return int(rv.Float()), nil
+		}
+	case apiv1.ArgumentTypeFloat:
+		switch {
+		case rv.CanInt():
+			return float64(rv.Int()), nil
+		case rv.CanUint():
+			return float64(rv.Uint()), nil
+		case rv.CanFloat():
+			return rv.Float(), nil
+		}
+	case apiv1.ArgumentTypeList:
+		if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+			l := make([]interface{}, rv.Len())
+			for i := range l {
+				l[i] = rv.Index(i).Interface()
+			}
+			return l, nil
+		}
+	case apiv1.ArgumentTypeMap:
+		if rv.Kind() == reflect.Map {
+			m := make(map[string]interface{}, rv.Len())
+			iter := rv.MapRange()
+			for iter.Next() {
+				k := iter.Key()
+				if k.Kind() == reflect.Interface {
+					k = k.Elem()
+				}
+				if k.Kind() != reflect.String {
+					return nil, fmt.Errorf("expected map with string keys, got key %v", iter.Key())
+				}
+				m[k.String()] = iter.Value().Interface()
+			}
+			return m, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown argument type %q", typ)
+	}
+
+	return nil, fmt.Errorf("expected %s, got %T", typ, v)
+}
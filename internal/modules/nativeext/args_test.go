@@ -0,0 +1,149 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nativeext_test
+
+import (
+	"context"
+	"testing"
+
+	"go.rgst.io/stencil/internal/modules/nativeext"
+	"go.rgst.io/stencil/internal/modules/nativeext/apiv1"
+	"go.rgst.io/stencil/pkg/slogext"
+	"gotest.tools/v3/assert"
+)
+
+// typedExtension is an extension that declares the types of its
+// arguments and returns the arguments it received.
+type typedExtension struct{}
+
+// GetConfig implements apiv1.Implementation
+func (*typedExtension) GetConfig() (*apiv1.Config, error) {
+	return &apiv1.Config{}, nil
+}
+
+// GetTemplateFunctions implements apiv1.Implementation
+func (*typedExtension) GetTemplateFunctions() ([]*apiv1.TemplateFunction, error) {
+	return []*apiv1.TemplateFunction{
+		{
+			Name: "Typed",
+			Arguments: []apiv1.TemplateFunctionArgument{
+				{Name: "name", Type: apiv1.ArgumentTypeString},
+				{Name: "count", Type: apiv1.ArgumentTypeInt},
+				{Name: "items", Type: apiv1.ArgumentTypeList},
+				{Name: "values", Type: apiv1.ArgumentTypeMap, Optional: true},
+			},
+			ReturnType: apiv1.ArgumentTypeList,
+		},
+		{Name: "Untyped", NumberOfArguments: 1},
+	}, nil
+}
+
+// ExecuteTemplateFunction implements apiv1.Implementation
+func (*typedExtension) ExecuteTemplateFunction(t *apiv1.TemplateFunctionExec) (interface{}, error) {
+	return t.Arguments, nil
+}
+
+// newTypedCaller returns an extension caller for typedExtension
+// registered as "test".
+func newTypedCaller(t *testing.T) *nativeext.ExtensionCaller {
+	ext := nativeext.NewHost(slogext.NewTestLogger(t))
+	t.Cleanup(func() { ext.Close() })
+	ext.RegisterInprocExtension("test", &typedExtension{})
+
+	caller, err := ext.GetExtensionCaller(context.Background())
+	assert.NilError(t, err, "failed to get extension caller")
+	return caller
+}
+
+func TestConvertsTypedArguments(t *testing.T) {
+	caller := newTypedCaller(t)
+
+	resp, err := caller.Call("test.Typed", "a", 2.0, []string{"b"}, map[interface{}]interface{}{"c": 1})
+	assert.NilError(t, err, "failed to call extension")
+	assert.DeepEqual(t, resp, []interface{}{
+		"a", 2, []interface{}{"b"}, map[string]interface{}{"c": 1},
+	})
+
+	// Optional arguments can be omitted
+	resp, err = caller.Call("test.Typed", "a", uint8(2), []int{1})
+	assert.NilError(t, err, "failed to call extension")
+	assert.DeepEqual(t, resp, []interface{}{"a", 2, []interface{}{1}})
+}
+
+func TestRejectsInvalidArguments(t *testing.T) {
+	caller := newTypedCaller(t)
+
+	tests := []struct {
+		name string
+		args []interface{}
+		err  string
+	}{
+		{
+			name: "not enough arguments",
+			args: []interface{}{"a"},
+			err:  `invalid call to template function "test.Typed": not enough arguments, expected 3, got 1: missing argument "count"`,
+		},
+		{
+			name: "too many arguments",
+			args: []interface{}{"a", 1, nil, nil, nil},
+			err:  `invalid call to template function "test.Typed": too many arguments, expected 4, got 5`,
+		},
+		{
+			name: "wrong type",
+			args: []interface{}{1, 1, []string{}},
+			err:  `invalid call to template function "test.Typed": argument 1 ("name"): expected string, got int`,
+		},
+		{
+			name: "float with fraction for int",
+			args: []interface{}{"a", 1.5, []string{}},
+			err:  `argument 2 ("count"): expected int, got float64`,
+		},
+		{
+			name: "nil list",
+			args: []interface{}{"a", 1, nil},
+			err:  `argument 3 ("items"): expected list, got nil`,
+		},
+		{
+			name: "map without string keys",
+			args: []interface{}{"a", 1, []string{}, map[int]string{1: "a"}},
+			err:  `argument 4 ("values"): expected map with string keys, got key 1`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := caller.Call(append([]interface{}{"test.Typed"}, tt.args...)...)
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestUntypedArgumentsAreOnlyLimited(t *testing.T) {
+	caller := newTypedCaller(t)
+
+	resp, err := caller.Call("test.Untyped")
+	assert.NilError(t, err, "failed to call extension")
+	assert.DeepEqual(t, resp, []interface{}{})
+
+	_, err = caller.Call("test.Untyped", 1, 2)
+	assert.ErrorContains(t, err, "too many arguments, expected 1, got 2")
+}
+
+func TestTemplateFunctionSignature(t *testing.T) {
+	fns, err := (&typedExtension{}).GetTemplateFunctions()
+	assert.NilError(t, err)
+
+	assert.Equal(t, fns[0].Signature(), "Typed(name string, count int, items list, values? map) list")
+	assert.Equal(t, fns[1].Signature(), "Untyped(arg1 any) any")
+}
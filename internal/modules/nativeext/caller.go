@@ -15,14 +15,42 @@
 package nativeext
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.rgst.io/stencil/internal/profile"
+	"go.rgst.io/stencil/internal/tracing"
 )
 
 // ExtensionCaller calls extension functions
 type ExtensionCaller struct {
 	funcMap map[string]map[string]generatedTemplateFunc
+
+	// profiler records how long calls take, if set
+	profiler *profile.Profiler
+
+	// ctx is the context calls are traced in, see WithContext
+	ctx context.Context
+}
+
+// WithContext returns a copy of the caller that traces calls as children
+// of the span in ctx, e.g. the span of the template making them
+func (ec *ExtensionCaller) WithContext(ctx context.Context) *ExtensionCaller {
+	if ec == nil {
+		return nil
+	}
+	c := *ec
+	c.ctx = ctx
+	return &c
+}
+
+// SetProfiler sets the profiler that records how long each call of an
+// extension function takes
+func (ec *ExtensionCaller) SetProfiler(p *profile.Profiler) {
+	ec.profiler = p
 }
 
 // Call returns a function based on its path, e.g. test.callFunction
@@ -48,5 +76,10 @@ func (ec *ExtensionCaller) Call(args ...interface{}) (interface{}, error) {
 		return nil, fmt.Errorf("extension '%s' doesn't provide function '%s'", extName, extFn)
 	}
 
-	return ec.funcMap[extName][extFn](args[1:]...)
+	defer ec.profiler.Start("extension", extPath)()
+	_, span := tracing.Start(ec.ctx, "extension.Call",
+		tracing.ModuleKey.String(extName), attribute.String("stencil.extension.function", extFn))
+	v, err := ec.funcMap[extName][extFn](args[1:]...)
+	tracing.End(span, err)
+	return v, err
 }
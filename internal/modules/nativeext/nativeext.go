@@ -8,21 +8,21 @@ package nativeext
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"runtime"
+	"sort"
 	"strings"
 
 	giturls "github.com/chainguard-dev/git-urls"
-	"github.com/getoutreach/gobox/pkg/cfg"
 	"github.com/getoutreach/gobox/pkg/cli/updater/archive"
-	"github.com/getoutreach/gobox/pkg/cli/updater/release"
-	"go.rgst.io/stencil/internal/git/vcs/github"
 	"go.rgst.io/stencil/internal/modules/nativeext/apiv1"
 	"go.rgst.io/stencil/internal/modules/resolver"
+	"go.rgst.io/stencil/pkg/configuration"
 	"go.rgst.io/stencil/pkg/slogext"
 )
 
@@ -43,6 +43,30 @@ type Host struct {
 type extension struct {
 	impl   apiv1.Implementation
 	closer func() error
+
+	// info is information about where the extension came from
+	info ExtensionInfo
+}
+
+// ExtensionInfo contains information about a registered extension
+type ExtensionInfo struct {
+	// Name is the name of the extension, this is the import path of the
+	// module that provides it.
+	Name string
+
+	// Version is the version of the module that provided the extension,
+	// nil for in-process extensions.
+	Version *resolver.Version
+
+	// Path is the path to the binary (or WebAssembly module) of the
+	// extension, empty for in-process extensions.
+	Path string
+
+	// Checksum is the sha256 checksum of the file at Path
+	Checksum string
+
+	// WASM denotes the extension as a WebAssembly extension
+	WASM bool
 }
 
 // NewHost creates a new extension host
@@ -55,20 +79,31 @@ func NewHost(log slogext.Logger) *Host {
 }
 
 // createFunctionFromTemplateFunction takes a given
-// TemplateFunction and turns it into a callable function
-func (h *Host) createFunctionFromTemplateFunction(extName string, ext apiv1.Implementation,
+// TemplateFunction and turns it into a callable function. Calls are
+// stopped once ctx is done, if the extension supports it.
+func (h *Host) createFunctionFromTemplateFunction(ctx context.Context, extName string, ext apiv1.Implementation,
 	fn *apiv1.TemplateFunction) generatedTemplateFunc {
 	extPath := extName + "." + fn.Name
 
 	return func(args ...interface{}) (interface{}, error) {
-		if len(args) > fn.NumberOfArguments {
-			return nil, fmt.Errorf("too many arguments, expected %d, got %d", fn.NumberOfArguments, len(args))
+		// Validate the arguments before sending them to the extension, so
+		// that errors are reported here instead of by the transport.
+		args, err := checkArguments(fn, args)
+		if err != nil {
+			return nil, fmt.Errorf("invalid call to template function %q: %w", extPath, err)
 		}
 
-		resp, err := ext.ExecuteTemplateFunction(&apiv1.TemplateFunctionExec{
+		exec := &apiv1.TemplateFunctionExec{
 			Name:      fn.Name,
 			Arguments: args,
-		})
+		}
+
+		var resp interface{}
+		if cext, ok := ext.(apiv1.ContextImplementation); ok {
+			resp, err = cext.ExecuteTemplateFunctionContext(ctx, exec)
+		} else {
+			resp, err = ext.ExecuteTemplateFunction(exec)
+		}
 		if err != nil {
 			// return an error if the extension returns an error
 			return nil, fmt.Errorf("failed to execute template function %q: %w", extPath, err)
@@ -80,8 +115,9 @@ func (h *Host) createFunctionFromTemplateFunction(extName string, ext apiv1.Impl
 }
 
 // GetExtensionCaller returns an extension caller that's
-// aware of all extension functions
-func (h *Host) GetExtensionCaller(_ context.Context) (*ExtensionCaller, error) {
+// aware of all extension functions. Calls to extensions that can be
+// stopped, e.g. WebAssembly extensions, are stopped once ctx is done.
+func (h *Host) GetExtensionCaller(ctx context.Context) (*ExtensionCaller, error) {
 	// funcMap stores the extension functions discovered
 	funcMap := map[string]map[string]generatedTemplateFunc{}
 
@@ -94,7 +130,7 @@ func (h *Host) GetExtensionCaller(_ context.Context) (*ExtensionCaller, error) {
 
 		for _, f := range funcs {
 			h.log.With("extension", extName).With("function", f.Name).Debug("Registering extension function")
-			tfunc := h.createFunctionFromTemplateFunction(extName, ext.impl, f)
+			tfunc := h.createFunctionFromTemplateFunction(ctx, extName, ext.impl, f)
 
 			if _, ok := funcMap[extName]; !ok {
 				funcMap[extName] = make(map[string]generatedTemplateFunc)
@@ -104,33 +140,81 @@ func (h *Host) GetExtensionCaller(_ context.Context) (*ExtensionCaller, error) {
 	}
 
 	// return the lookup function, used via Call()
-	return &ExtensionCaller{funcMap}, nil
+	return &ExtensionCaller{funcMap: funcMap}, nil
 }
 
 // TODO(jaredallard)[DTSS-1926]: Refactor a lot of this RegisterExtension code.
 
+// RegisterExtensionOpts contains options for registering an extension
+type RegisterExtensionOpts struct {
+	// Releases configures where releases of the extension are
+	// downloaded from, when nil they're downloaded from Github. See
+	// NewReleaseSource.
+	Releases *configuration.ExtensionSource
+
+	// WASM denotes the extension as a WebAssembly (WASI) extension, which
+	// is executed by an embedded runtime instead of go-plugin.
+	WASM bool
+
+	// ModuleDir is the directory of the module that provides the
+	// extension, relative directories in Releases are resolved against
+	// it.
+	ModuleDir string
+}
+
 // RegisterExtension registers a ext from a given source
 // and compiles/downloads it. A client is then created
 // that is able to communicate with the ext.
-func (h *Host) RegisterExtension(ctx context.Context, source, name string, version *resolver.Version) error { //nolint:lll // Why: OK length.
+func (h *Host) RegisterExtension(ctx context.Context, source, name string, version *resolver.Version,
+	opts *RegisterExtensionOpts) (err error) {
 	h.log.With("extension", name).With("source", source).Debug("Registered extension")
 
+	if opts == nil {
+		opts = &RegisterExtensionOpts{}
+	}
+
 	u, err := giturls.Parse(source)
 	if err != nil {
 		return fmt.Errorf("failed to parse extension URL: %w", err)
 	}
 
+	rel := newRelease(filepath.Base(name), version.Tag)
+	if opts.WASM {
+		rel = newWASMRelease(filepath.Base(name), version.Tag)
+	}
+
 	var extPath string
 	if u.Scheme == "file" {
 		extPath = filepath.Join(strings.TrimPrefix(source, "file://"), "bin", "plugin")
+		if opts.WASM {
+			extPath += ".wasm"
+		}
 	} else {
-		extPath, err = h.downloadFromRemote(ctx, name, version)
+		var rs ReleaseSource
+		rs, err = NewReleaseSource(name, opts.Releases, opts.ModuleDir, h.log)
+		if err == nil {
+			extPath, err = h.downloadFromRemote(ctx, name, version, rs, rel)
+		}
 	}
 	if err != nil {
 		return fmt.Errorf("failed to setup extension: %w", err)
 	}
 
-	ext, closer, err := apiv1.NewExtensionClient(ctx, extPath, h.log)
+	newClient := apiv1.NewExtensionClient
+	if opts.WASM {
+		newClient = apiv1.NewWASMExtensionClient
+	}
+
+	ext, closer, err := newClient(ctx, extPath, h.log)
+	// The extension is only closed by the host once it's registered, so
+	// stop it ourselves if anything fails until then.
+	defer func() {
+		if err != nil {
+			if cerr := closer(); cerr != nil {
+				h.log.WithError(cerr).With("extension", name).Warn("Failed to stop extension")
+			}
+		}
+	}()
 	if err != nil {
 		return err
 	}
@@ -140,7 +224,19 @@ func (h *Host) RegisterExtension(ctx context.Context, source, name string, versi
 	if _, err := ext.GetConfig(); err != nil {
 		return fmt.Errorf("failed to get config from extension: %w", err)
 	}
-	h.extensions[name] = extension{ext, closer}
+
+	checksum, err := checksumFile(extPath)
+	if err != nil {
+		return fmt.Errorf("failed to checksum extension: %w", err)
+	}
+
+	h.extensions[name] = extension{ext, closer, ExtensionInfo{
+		Name:     name,
+		Version:  version,
+		Path:     extPath,
+		Checksum: checksum,
+		WASM:     opts.WASM,
+	}}
 
 	return nil
 }
@@ -150,7 +246,53 @@ func (h *Host) RegisterExtension(ctx context.Context, source, name string, versi
 // of this API for unit testing only!
 func (h *Host) RegisterInprocExtension(name string, ext apiv1.Implementation) {
 	h.log.With("extension", name).Debug("Registered inproc extension")
-	h.extensions[name] = extension{ext, func() error { return nil }}
+	h.extensions[name] = extension{ext, func() error { return nil }, ExtensionInfo{Name: name}}
+}
+
+// Extensions returns information about all registered extensions,
+// sorted by name.
+func (h *Host) Extensions() []ExtensionInfo {
+	infos := make([]ExtensionInfo, 0, len(h.extensions))
+	for _, ext := range h.extensions {
+		infos = append(infos, ext.info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// GetTemplateFunctions returns the template functions provided by the
+// extension with the provided name, sorted by name.
+func (h *Host) GetTemplateFunctions(name string) ([]*apiv1.TemplateFunction, error) {
+	ext, ok := h.extensions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown extension '%s'", name)
+	}
+
+	funcs, err := ext.impl.GetTemplateFunctions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template functions from plugin %q: %w", name, err)
+	}
+	sort.Slice(funcs, func(i, j int) bool {
+		return funcs[i].Name < funcs[j].Name
+	})
+	return funcs, nil
+}
+
+// checksumFile returns the hex encoded sha256 checksum of a file
+func checksumFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 // getExtensionPath returns the path to an extension binary
@@ -174,7 +316,8 @@ func (h *Host) getExtensionPath(version *resolver.Version, name string) (string,
 	return path, nil
 }
 
-// downloadFromRemote downloads a release from github and extracts it to disk
+// downloadFromRemote downloads a release from the provided release
+// source and extracts it to disk
 //
 // using the example extension module: go.rgst.io/stencil-plugin
 //
@@ -182,36 +325,33 @@ func (h *Host) getExtensionPath(version *resolver.Version, name string) (string,
 //	repo: stencil-plugin
 //	name: go.rgst.io/stencil-plugin
 func (h *Host) downloadFromRemote(ctx context.Context, name string,
-	version *resolver.Version) (string, error) {
-	token, err := github.Token()
-	if err != nil {
-		h.log.WithError(err).Warn("Failed to get github token, falling back to anonymous")
-	}
-
-	repoURL := "https://" + name
-
+	version *resolver.Version, rs ReleaseSource, rel *Release) (string, error) {
 	// Check if the version we're pulling already exists on disk
 	dlPath, err := h.getExtensionPath(version, name)
 	if err != nil {
 		return "", fmt.Errorf("failed to get extension path: %w", err)
 	}
+	if rel.IsWASM() {
+		dlPath += ".wasm"
+	}
 	if info, err := os.Stat(dlPath); err == nil && info.Mode() == 0o755 {
 		return dlPath, nil
 	}
 
-	h.log.With("version", version).With("repo", repoURL).Debug("Downloading native extension")
-	a, archiveName, _, err := release.Fetch(ctx, cfg.SecretData(token), &release.FetchOptions{
-		AssetName: filepath.Base(name) + "_*_" + runtime.GOOS + "_" + runtime.GOARCH + ".tar.gz",
-		RepoURL:   repoURL,
-		Tag:       version.Tag,
-	})
+	h.log.With("version", version).With("extension", name).Debug("Downloading native extension")
+	a, archiveName, err := rs.Fetch(ctx, rel)
 	if err != nil {
-		return "", fmt.Errorf("failed to fetch release: %w", err)
+		return "", err
 	}
+	defer a.Close()
 
-	bin, _, err := archive.Extract(ctx, archiveName, a, archive.WithFilePath(filepath.Base(name)))
-	if err != nil {
-		return "", fmt.Errorf("failed to extract archive: %w", err)
+	// WebAssembly extensions aren't archived
+	var bin io.Reader = a
+	if !rel.IsWASM() {
+		bin, _, err = archive.Extract(ctx, archiveName, a, archive.WithFilePath(filepath.Base(name)))
+		if err != nil {
+			return "", fmt.Errorf("failed to extract archive: %w", err)
+		}
 	}
 
 	f, err := os.Create(dlPath)
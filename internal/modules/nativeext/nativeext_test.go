@@ -21,7 +21,7 @@ func TestCanImportNativeExtension(t *testing.T) {
 	version := &resolver.Version{
 		Tag: "v1.3.0",
 	}
-	err := ext.RegisterExtension(ctx, "https://github.com/getoutreach/stencil-golang", "github.com/getoutreach/stencil-golang", version)
+	err := ext.RegisterExtension(ctx, "https://github.com/getoutreach/stencil-golang", "github.com/getoutreach/stencil-golang", version, nil)
 	assert.NilError(t, err, "failed to register extension")
 
 	caller, err := ext.GetExtensionCaller(ctx)
@@ -0,0 +1,238 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements the release source abstraction
+// used to download native extensions.
+
+package nativeext
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"text/template"
+
+	"go.rgst.io/stencil/pkg/configuration"
+	"go.rgst.io/stencil/pkg/slogext"
+)
+
+// This block contains the supported release source types.
+const (
+	// SourceTypeGitHub downloads releases from Github releases
+	SourceTypeGitHub = "github"
+
+	// SourceTypeGitLab downloads releases from Gitlab releases
+	SourceTypeGitLab = "gitlab"
+
+	// SourceTypeHTTP downloads releases from a URL template
+	SourceTypeHTTP = "http"
+
+	// SourceTypeDir reads releases from a local directory
+	SourceTypeDir = "dir"
+)
+
+// This block contains the platform used for WebAssembly extensions,
+// matching the GOOS and GOARCH used to compile them.
+const (
+	wasmOS   = "wasip1"
+	wasmArch = "wasm"
+)
+
+// Release is a release of a native extension that should be fetched
+// from a ReleaseSource.
+type Release struct {
+	// Name is the name of the extension binary, this is the last
+	// element of the module import path (e.g. stencil-golang).
+	Name string
+
+	// Tag is the tag of the release (e.g. v1.3.0).
+	Tag string
+
+	// Version is the tag of the release without the "v" prefix (e.g.
+	// 1.3.0), this matches the version used by goreleaser archives.
+	Version string
+
+	// OS is the operating system to fetch the release for.
+	OS string
+
+	// Arch is the architecture to fetch the release for.
+	Arch string
+}
+
+// newRelease creates a Release for the current platform
+func newRelease(name, tag string) *Release {
+	return &Release{
+		Name:    name,
+		Tag:     tag,
+		Version: strings.TrimPrefix(tag, "v"),
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+	}
+}
+
+// newWASMRelease creates a Release of a WebAssembly extension, which
+// is platform independent.
+func newWASMRelease(name, tag string) *Release {
+	rel := newRelease(name, tag)
+	rel.OS, rel.Arch = wasmOS, wasmArch
+	return rel
+}
+
+// IsWASM returns true if this is a release of a WebAssembly extension
+func (r *Release) IsWASM() bool {
+	return r.OS == wasmOS && r.Arch == wasmArch
+}
+
+// AssetName returns the glob used to find the archive for this release
+// in a list of release assets. WebAssembly extensions are released as a
+// single .wasm file instead, which is used on every platform.
+func (r *Release) AssetName() string {
+	if r.IsWASM() {
+		return r.Name + "_*.wasm"
+	}
+	return r.Name + "_*_" + r.OS + "_" + r.Arch + ".tar.gz"
+}
+
+// ReleaseSource is a source that native extension releases can be
+// downloaded from.
+type ReleaseSource interface {
+	// Fetch returns the archive for the provided release along with the
+	// file name of the archive. The returned io.ReadCloser must be closed
+	// by the caller.
+	Fetch(ctx context.Context, rel *Release) (io.ReadCloser, string, error)
+}
+
+// NewReleaseSource returns the ReleaseSource for the extension with the
+// provided name (import path). When conf is nil, releases are fetched
+// from the Github repository of the extension. Relative directories of
+// dir sources are resolved against moduleDir, the directory of the
+// module that provides the extension, if set.
+func NewReleaseSource(name string, conf *configuration.ExtensionSource, moduleDir string,
+	log slogext.Logger) (ReleaseSource, error) {
+	typ, uri := SourceTypeGitHub, ""
+	if conf != nil {
+		typ, uri = conf.Type, conf.URL
+	}
+
+	// Infer the type from the scheme of the URL if one wasn't provided.
+	if typ == "" {
+		var err error
+		if typ, uri, err = sourceTypeFromURL(uri); err != nil {
+			return nil, err
+		}
+	}
+
+	switch typ {
+	case SourceTypeGitHub:
+		if uri == "" {
+			uri = "https://" + name
+		}
+		return &githubSource{uri, log}, nil
+	case SourceTypeGitLab:
+		return newGitLabSource(uri)
+	case SourceTypeHTTP:
+		if uri == "" {
+			return nil, fmt.Errorf("http extension source requires a url")
+		}
+		return &httpSource{uri, http.DefaultClient}, nil
+	case SourceTypeDir:
+		if uri == "" {
+			return nil, fmt.Errorf("dir extension source requires a url")
+		}
+		return &dirSource{strings.TrimPrefix(uri, "file://"), moduleDir}, nil
+	default:
+		return nil, fmt.Errorf("unknown extension source type %q", typ)
+	}
+}
+
+// sourceTypeFromURL infers the source type from the scheme of the
+// provided URL, returning the URL that should be used by the source.
+func sourceTypeFromURL(uri string) (typ, sourceURL string, err error) {
+	if uri == "" {
+		return SourceTypeGitHub, "", nil
+	}
+
+	scheme, rest, ok := strings.Cut(uri, "://")
+	if !ok {
+		// No scheme, assume a local path
+		return SourceTypeDir, uri, nil
+	}
+
+	switch scheme {
+	case "github":
+		return SourceTypeGitHub, "https://" + rest, nil
+	case "gitlab":
+		return SourceTypeGitLab, "https://" + rest, nil
+	case "http", "https":
+		return SourceTypeHTTP, uri, nil
+	case "file":
+		return SourceTypeDir, rest, nil
+	default:
+		return "", "", fmt.Errorf("unable to infer extension source type from url %q", uri)
+	}
+}
+
+// renderSourceTemplate renders a URL or path template for the provided
+// release.
+func renderSourceTemplate(tpl string, rel *Release) (string, error) {
+	t, err := template.New("source").Option("missingkey=error").Parse(tpl)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse extension source template %q: %w", tpl, err)
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, rel); err != nil {
+		return "", fmt.Errorf("failed to render extension source template %q: %w", tpl, err)
+	}
+	return buf.String(), nil
+}
+
+// httpGet performs a GET request against the provided URL with the
+// provided headers, returning the body if the request was successful.
+func httpGet(ctx context.Context, client *http.Client, u string, headers http.Header) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to get %s: %s", redactURL(u), resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+// redactURL removes any credentials from the provided URL, so that it
+// can be safely shown in errors.
+func redactURL(u string) string {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	return pu.Redacted()
+}
@@ -0,0 +1,60 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements a release source backed by Github
+// releases.
+
+package nativeext
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/getoutreach/gobox/pkg/cfg"
+	"github.com/getoutreach/gobox/pkg/cli/updater/release"
+	"go.rgst.io/stencil/internal/git/vcs/github"
+	"go.rgst.io/stencil/pkg/slogext"
+)
+
+// _ is a compile time assertion we implement the interface
+var _ ReleaseSource = &githubSource{}
+
+// githubSource fetches releases from Github releases
+type githubSource struct {
+	// repoURL is the URL of the repository, e.g.
+	// https://github.com/rgst-io/stencil-golang
+	repoURL string
+
+	log slogext.Logger
+}
+
+// Fetch implements ReleaseSource
+func (s *githubSource) Fetch(ctx context.Context, rel *Release) (io.ReadCloser, string, error) {
+	token, err := github.Token()
+	if err != nil {
+		s.log.WithError(err).Warn("Failed to get github token, falling back to anonymous")
+	}
+
+	a, archiveName, _, err := release.Fetch(ctx, cfg.SecretData(token), &release.FetchOptions{
+		AssetName: rel.AssetName(),
+		RepoURL:   s.repoURL,
+		Tag:       rel.Tag,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch release: %w", err)
+	}
+
+	return a, archiveName, nil
+}
@@ -0,0 +1,239 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements a release source backed by Gitlab
+// releases.
+
+package nativeext
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// _ is a compile time assertion we implement the interface
+var _ ReleaseSource = &gitlabSource{}
+
+// gitlabSource fetches releases from the releases API of a Gitlab
+// instance.
+type gitlabSource struct {
+	// baseURL is the URL of the Gitlab instance, e.g.
+	// https://gitlab.example.com, or https://example.com/gitlab for
+	// instances served from a path
+	baseURL string
+
+	// project is the full path of the project, e.g. group/project
+	project string
+
+	// resolved is true once it's known which part of the path of the
+	// project URL belongs to baseURL, see resolveProject
+	resolved bool
+
+	client *http.Client
+}
+
+// gitlabRelease is the subset of a Gitlab release that we use
+type gitlabRelease struct {
+	Assets struct {
+		Links []struct {
+			Name           string `json:"name"`
+			URL            string `json:"url"`
+			DirectAssetURL string `json:"direct_asset_url"`
+		} `json:"links"`
+	} `json:"assets"`
+}
+
+// newGitLabSource creates a gitlabSource from a project URL
+func newGitLabSource(projectURL string) (*gitlabSource, error) {
+	u, err := url.Parse(projectURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid gitlab project url %q", projectURL)
+	}
+
+	project := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	if project == "" {
+		return nil, fmt.Errorf("gitlab project url %q is missing the project path", projectURL)
+	}
+
+	return &gitlabSource{
+		baseURL: u.Scheme + "://" + u.Host,
+		project: project,
+		// Projects are at least in a group, so there's nothing to resolve
+		// when the path only has two elements
+		resolved: strings.Count(project, "/") < 2,
+		client:   &http.Client{CheckRedirect: dropGitLabTokensOnRedirect},
+	}, nil
+}
+
+// resolveProject splits the path of the project URL into the path of
+// the Gitlab instance, if it's served from one (e.g.
+// https://example.com/gitlab), and the path of the project. Since
+// projects can be in subgroups, the path of the project is looked up
+// through the API, starting with the longest one, until the instance
+// knows it.
+func (s *gitlabSource) resolveProject(ctx context.Context) error {
+	if s.resolved {
+		return nil
+	}
+
+	elems := strings.Split(s.project, "/")
+	for i := 0; i < len(elems)-1; i++ {
+		baseURL := strings.Join(append([]string{s.baseURL}, elems[:i]...), "/")
+		project := strings.Join(elems[i:], "/")
+
+		ok, err := s.projectExists(ctx, baseURL, project)
+		if err != nil {
+			return err
+		}
+		if ok {
+			s.baseURL, s.project = baseURL, project
+			break
+		}
+	}
+
+	// Fall back to the whole path being the project, so that errors
+	// mention it
+	s.resolved = true
+	return nil
+}
+
+// projectExists returns true if the Gitlab instance at baseURL has a
+// project at path project
+func (s *gitlabSource) projectExists(ctx context.Context, baseURL, project string) (bool, error) {
+	u := fmt.Sprintf("%s/api/v4/projects/%s", baseURL, url.PathEscape(project))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, http.NoBody)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range s.headers() {
+		req.Header[k] = v
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to get project %s: %w", project, err)
+	}
+	defer resp.Body.Close()
+
+	// Anything other than a project (e.g. a page of another application
+	// served at the root of the host) means it's not the project
+	var p struct {
+		ID int `json:"id"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&p) != nil {
+		return false, nil
+	}
+	return p.ID != 0, nil
+}
+
+// gitlabTokenHeaders are the headers that authenticate requests to the
+// Gitlab API, see gitlabSource.headers
+var gitlabTokenHeaders = []string{"PRIVATE-TOKEN", "JOB-TOKEN"}
+
+// dropGitLabTokensOnRedirect removes the Gitlab tokens from requests
+// that are redirected to another host (e.g. object storage serving
+// release assets). Unlike the Authorization header, net/http forwards
+// them as is.
+func dropGitLabTokensOnRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if !sameOrigin(req.URL, via[0].URL) {
+		for _, h := range gitlabTokenHeaders {
+			req.Header.Del(h)
+		}
+	}
+	return nil
+}
+
+// sameOrigin returns true if a and b have the same scheme and host
+func sameOrigin(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host)
+}
+
+// headers returns the authentication headers for the Gitlab API, if
+// a token is available in the environment.
+func (s *gitlabSource) headers() http.Header {
+	h := http.Header{}
+	if token := os.Getenv("GITLAB_TOKEN"); token != "" {
+		h.Set("PRIVATE-TOKEN", token)
+	} else if token := os.Getenv("CI_JOB_TOKEN"); token != "" {
+		h.Set("JOB-TOKEN", token)
+	}
+	return h
+}
+
+// assetHeaders returns the headers to download the asset at assetURL
+// with. Release links may point to any host, so the token is only sent
+// to the Gitlab instance itself.
+func (s *gitlabSource) assetHeaders(assetURL string) http.Header {
+	base, err := url.Parse(s.baseURL)
+	if err != nil {
+		return nil
+	}
+	u, err := url.Parse(assetURL)
+	if err != nil || !sameOrigin(u, base) {
+		return nil
+	}
+	return s.headers()
+}
+
+// Fetch implements ReleaseSource
+func (s *gitlabSource) Fetch(ctx context.Context, rel *Release) (io.ReadCloser, string, error) {
+	if err := s.resolveProject(ctx); err != nil {
+		return nil, "", err
+	}
+
+	releaseURL := fmt.Sprintf("%s/api/v4/projects/%s/releases/%s",
+		s.baseURL, url.PathEscape(s.project), url.PathEscape(rel.Tag))
+
+	body, err := httpGet(ctx, s.client, releaseURL, s.headers())
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get release %s for %s: %w", rel.Tag, s.project, err)
+	}
+	defer body.Close()
+
+	var r gitlabRelease
+	if err := json.NewDecoder(body).Decode(&r); err != nil {
+		return nil, "", fmt.Errorf("failed to decode release %s for %s: %w", rel.Tag, s.project, err)
+	}
+
+	assetName := rel.AssetName()
+	for _, l := range r.Assets.Links {
+		if ok, err := filepath.Match(assetName, l.Name); err != nil || !ok {
+			continue
+		}
+
+		assetURL := l.DirectAssetURL
+		if assetURL == "" {
+			assetURL = l.URL
+		}
+
+		a, err := httpGet(ctx, s.client, assetURL, s.assetHeaders(assetURL))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to download asset %q: %w", l.Name, err)
+		}
+		return a, l.Name, nil
+	}
+
+	return nil, "", fmt.Errorf("release %s for %s has no asset matching %q", rel.Tag, s.project, assetName)
+}
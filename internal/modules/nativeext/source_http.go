@@ -0,0 +1,105 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements release sources backed by plain
+// HTTP servers and local directories.
+
+package nativeext
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// _ is a compile time assertion we implement the interface
+var _ ReleaseSource = &httpSource{}
+
+// _ is a compile time assertion we implement the interface
+var _ ReleaseSource = &dirSource{}
+
+// httpSource fetches releases from a URL template, e.g.
+// https://artifacts.example.com/{{ .Name }}/{{ .Tag }}/{{ .Name }}_{{ .Version }}_{{ .OS }}_{{ .Arch }}.tar.gz
+type httpSource struct {
+	urlTemplate string
+	client      *http.Client
+}
+
+// Fetch implements ReleaseSource
+func (s *httpSource) Fetch(ctx context.Context, rel *Release) (io.ReadCloser, string, error) {
+	u, err := renderSourceTemplate(s.urlTemplate, rel)
+	if err != nil {
+		return nil, "", err
+	}
+
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse release url: %w", err)
+	}
+
+	a, err := httpGet(ctx, s.client, u, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download release: %w", err)
+	}
+
+	// The archive name is used to determine the archive type, so use
+	// the last element of the URL path.
+	return a, path.Base(pu.Path), nil
+}
+
+// dirSource reads releases from a directory on the local filesystem.
+// The directory may be a template, e.g. /srv/releases/{{ .Tag }}, and
+// must contain an archive matching Release.AssetName.
+type dirSource struct {
+	dirTemplate string
+
+	// baseDir is the directory relative directories are resolved
+	// against, the directory of the module that provides the extension.
+	// When empty, they're resolved against the current directory.
+	baseDir string
+}
+
+// Fetch implements ReleaseSource
+func (s *dirSource) Fetch(_ context.Context, rel *Release) (io.ReadCloser, string, error) {
+	dir, err := renderSourceTemplate(s.dirTemplate, rel)
+	if err != nil {
+		return nil, "", err
+	}
+	if !filepath.IsAbs(dir) && s.baseDir != "" {
+		dir = filepath.Join(s.baseDir, dir)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, rel.AssetName()))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to search %q for releases: %w", dir, err)
+	}
+	if len(matches) == 0 {
+		return nil, "", fmt.Errorf("no release matching %q found in %q", rel.AssetName(), dir)
+	}
+
+	// Be deterministic if multiple archives match.
+	sort.Strings(matches)
+
+	f, err := os.Open(matches[0])
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open release: %w", err)
+	}
+	return f, filepath.Base(matches[0]), nil
+}
@@ -0,0 +1,371 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nativeext
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"go.rgst.io/stencil/internal/modules/resolver"
+	"go.rgst.io/stencil/pkg/configuration"
+	"go.rgst.io/stencil/pkg/slogext"
+	"gotest.tools/v3/assert"
+)
+
+// newTestArchive returns a .tar.gz containing a single file with the
+// provided name and contents.
+func newTestArchive(t *testing.T, name, contents string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	assert.NilError(t, tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0o755,
+		Size:     int64(len(contents)),
+		Typeflag: tar.TypeReg,
+	}))
+	_, err := tw.Write([]byte(contents))
+	assert.NilError(t, err)
+	assert.NilError(t, tw.Close())
+	assert.NilError(t, gw.Close())
+	return buf.Bytes()
+}
+
+// testAssetName returns the archive name expected for the test release
+func testAssetName() string {
+	return fmt.Sprintf("plugin_1.0.0_%s_%s.tar.gz", runtime.GOOS, runtime.GOARCH)
+}
+
+// readRelease fetches the test release from rs and returns the archive
+// name and the contents of the plugin binary inside of it.
+func readRelease(t *testing.T, rs ReleaseSource) (string, string) {
+	h := &Host{log: slogext.NewTestLogger(t)}
+	t.Setenv("HOME", t.TempDir())
+
+	binPath, err := h.downloadFromRemote(context.Background(), "example.com/org/plugin",
+		&resolver.Version{Tag: "v1.0.0", Commit: "abc"}, rs, newRelease("plugin", "v1.0.0"))
+	assert.NilError(t, err, "failed to download release")
+
+	info, err := os.Stat(binPath)
+	assert.NilError(t, err)
+	assert.Equal(t, info.Mode(), os.FileMode(0o755), "expected binary to be executable")
+
+	b, err := os.ReadFile(binPath)
+	assert.NilError(t, err)
+
+	a, archiveName, err := rs.Fetch(context.Background(), newRelease("plugin", "v1.0.0"))
+	assert.NilError(t, err)
+	a.Close()
+
+	return archiveName, string(b)
+}
+
+// describeSource returns a string describing the type and location of
+// a ReleaseSource for comparison in tests.
+func describeSource(rs ReleaseSource) string {
+	switch s := rs.(type) {
+	case *githubSource:
+		return "github " + s.repoURL
+	case *gitlabSource:
+		return "gitlab " + s.baseURL + " " + s.project
+	case *httpSource:
+		return "http " + s.urlTemplate
+	case *dirSource:
+		return "dir " + s.dirTemplate
+	default:
+		return fmt.Sprintf("unknown %T", rs)
+	}
+}
+
+func TestNewReleaseSourceInfersType(t *testing.T) {
+	tests := []struct {
+		name string
+		conf *configuration.ExtensionSource
+		want string
+	}{
+		{
+			name: "defaults to github",
+			want: "github https://example.com/org/plugin",
+		},
+		{
+			name: "github scheme",
+			conf: &configuration.ExtensionSource{URL: "github://github.com/org/other"},
+			want: "github https://github.com/org/other",
+		},
+		{
+			name: "gitlab scheme",
+			conf: &configuration.ExtensionSource{URL: "gitlab://gitlab.example.com/group/sub/plugin"},
+			want: "gitlab https://gitlab.example.com group/sub/plugin",
+		},
+		{
+			name: "http scheme",
+			conf: &configuration.ExtensionSource{URL: "https://example.com/{{ .Tag }}.tar.gz"},
+			want: "http https://example.com/{{ .Tag }}.tar.gz",
+		},
+		{
+			name: "file scheme",
+			conf: &configuration.ExtensionSource{URL: "file:///srv/releases"},
+			want: "dir /srv/releases",
+		},
+		{
+			name: "explicit type",
+			conf: &configuration.ExtensionSource{Type: SourceTypeDir, URL: "releases/{{ .Tag }}"},
+			want: "dir releases/{{ .Tag }}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewReleaseSource("example.com/org/plugin", tt.conf, "", slogext.NewTestLogger(t))
+			assert.NilError(t, err)
+			assert.Equal(t, describeSource(got), tt.want)
+		})
+	}
+}
+
+func TestNewReleaseSourceErrors(t *testing.T) {
+	log := slogext.NewTestLogger(t)
+	_, err := NewReleaseSource("example.com/org/plugin", &configuration.ExtensionSource{URL: "s3://bucket"}, "", log)
+	assert.ErrorContains(t, err, "unable to infer extension source type")
+
+	_, err = NewReleaseSource("example.com/org/plugin", &configuration.ExtensionSource{Type: "ftp"}, "", log)
+	assert.ErrorContains(t, err, `unknown extension source type "ftp"`)
+
+	_, err = NewReleaseSource("example.com/org/plugin", &configuration.ExtensionSource{Type: SourceTypeHTTP}, "", log)
+	assert.ErrorContains(t, err, "requires a url")
+}
+
+func TestHTTPReleaseSource(t *testing.T) {
+	archive := newTestArchive(t, "plugin", "http-binary")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/plugin/v1.0.0/"+testAssetName() {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(archive)
+	}))
+	defer srv.Close()
+
+	rs, err := NewReleaseSource("example.com/org/plugin", &configuration.ExtensionSource{
+		URL: srv.URL + "/{{ .Name }}/{{ .Tag }}/{{ .Name }}_{{ .Version }}_{{ .OS }}_{{ .Arch }}.tar.gz",
+	}, "", slogext.NewTestLogger(t))
+	assert.NilError(t, err)
+
+	archiveName, contents := readRelease(t, rs)
+	assert.Equal(t, archiveName, testAssetName())
+	assert.Equal(t, contents, "http-binary")
+}
+
+func TestHTTPReleaseSourceNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	rs := &httpSource{srv.URL + "/{{ .Tag }}.tar.gz", srv.Client()}
+	_, _, err := rs.Fetch(context.Background(), newRelease("plugin", "v1.0.0"))
+	assert.ErrorContains(t, err, "404 Not Found")
+}
+
+func TestGitLabReleaseSource(t *testing.T) {
+	t.Setenv("GITLAB_TOKEN", "secret")
+
+	archive := newTestArchive(t, "plugin", "gitlab-binary")
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.RequestURI() {
+		case "/api/v4/projects/group%2Fplugin/releases/v1.0.0":
+			fmt.Fprintf(w, `{"assets":{"links":[
+				{"name":"checksums.txt","url":"%[1]s/checksums.txt"},
+				{"name":%[2]q,"url":"%[1]s/wrong","direct_asset_url":"%[1]s/download/%[2]s"}
+			]}}`, srv.URL, testAssetName())
+		case "/download/" + testAssetName():
+			w.Write(archive)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	rs, err := NewReleaseSource("example.com/org/plugin", &configuration.ExtensionSource{
+		Type: SourceTypeGitLab,
+		URL:  srv.URL + "/group/plugin.git",
+	}, "", slogext.NewTestLogger(t))
+	assert.NilError(t, err)
+
+	archiveName, contents := readRelease(t, rs)
+	assert.Equal(t, archiveName, testAssetName())
+	assert.Equal(t, contents, "gitlab-binary")
+}
+
+func TestGitLabReleaseSourceServedFromPath(t *testing.T) {
+	archive := newTestArchive(t, "plugin", "gitlab-binary")
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.RequestURI() {
+		case "/gitlab/api/v4/projects/group%2Fsub%2Fplugin":
+			io.WriteString(w, `{"id":1}`)
+		case "/gitlab/api/v4/projects/group%2Fsub%2Fplugin/releases/v1.0.0":
+			fmt.Fprintf(w, `{"assets":{"links":[{"name":%q,"url":"%s/gitlab/download"}]}}`, testAssetName(), srv.URL)
+		case "/gitlab/download":
+			w.Write(archive)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	rs, err := newGitLabSource(srv.URL + "/gitlab/group/sub/plugin")
+	assert.NilError(t, err)
+
+	_, contents := readRelease(t, rs)
+	assert.Equal(t, contents, "gitlab-binary")
+	assert.Equal(t, describeSource(rs), "gitlab "+srv.URL+"/gitlab group/sub/plugin")
+}
+
+func TestGitLabReleaseSourceDoesNotLeakToken(t *testing.T) {
+	t.Setenv("GITLAB_TOKEN", "secret")
+
+	archive := newTestArchive(t, "plugin", "gitlab-binary")
+	assets := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range []string{"PRIVATE-TOKEN", "JOB-TOKEN"} {
+			if r.Header.Get(h) != "" {
+				t.Errorf("expected no %s header to be sent to another host", h)
+			}
+		}
+		w.Write(archive)
+	}))
+	defer assets.Close()
+
+	tests := []struct {
+		name string
+
+		// link returns the URL of the asset link, srv is the Gitlab
+		// instance
+		link func(srv string) string
+	}{
+		{
+			name: "asset on another host",
+			link: func(string) string { return assets.URL + "/" + testAssetName() },
+		},
+		{
+			name: "asset redirecting to another host",
+			link: func(srv string) string { return srv + "/redirect" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var srv *httptest.Server
+			srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/redirect":
+					http.Redirect(w, r, assets.URL+"/"+testAssetName(), http.StatusFound)
+				default:
+					fmt.Fprintf(w, `{"assets":{"links":[{"name":%q,"url":%q}]}}`, testAssetName(), tt.link(srv.URL))
+				}
+			}))
+			defer srv.Close()
+
+			rs, err := newGitLabSource(srv.URL + "/group/plugin")
+			assert.NilError(t, err)
+
+			_, contents := readRelease(t, rs)
+			assert.Equal(t, contents, "gitlab-binary")
+		})
+	}
+}
+
+func TestGitLabReleaseSourceMissingAsset(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, `{"assets":{"links":[]}}`)
+	}))
+	defer srv.Close()
+
+	rs, err := newGitLabSource(srv.URL + "/group/plugin")
+	assert.NilError(t, err)
+
+	_, _, err = rs.Fetch(context.Background(), newRelease("plugin", "v1.0.0"))
+	assert.ErrorContains(t, err, "has no asset matching")
+}
+
+func TestWASMReleasesAreNotArchived(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir, "v1.0.0"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "v1.0.0", "plugin_1.0.0.wasm"), []byte("wasm-binary"), 0o644))
+
+	rs, err := NewReleaseSource("example.com/org/plugin", &configuration.ExtensionSource{
+		URL: "file://" + dir + "/{{ .Tag }}",
+	}, "", slogext.NewTestLogger(t))
+	assert.NilError(t, err)
+
+	h := &Host{log: slogext.NewTestLogger(t)}
+	t.Setenv("HOME", t.TempDir())
+	binPath, err := h.downloadFromRemote(context.Background(), "example.com/org/plugin",
+		&resolver.Version{Tag: "v1.0.0", Commit: "abc"}, rs, newWASMRelease("plugin", "v1.0.0"))
+	assert.NilError(t, err, "failed to download release")
+	assert.Assert(t, strings.HasSuffix(binPath, ".wasm"), binPath)
+
+	b, err := os.ReadFile(binPath)
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), "wasm-binary")
+}
+
+func TestDirReleaseSource(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir, "v1.0.0"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "v1.0.0", testAssetName()),
+		newTestArchive(t, "plugin", "dir-binary"), 0o644))
+
+	rs, err := NewReleaseSource("example.com/org/plugin", &configuration.ExtensionSource{
+		URL: "file://" + dir + "/{{ .Tag }}",
+	}, "", slogext.NewTestLogger(t))
+	assert.NilError(t, err)
+
+	archiveName, contents := readRelease(t, rs)
+	assert.Equal(t, archiveName, testAssetName())
+	assert.Equal(t, contents, "dir-binary")
+
+	_, _, err = rs.Fetch(context.Background(), newRelease("plugin", "v2.0.0"))
+	assert.ErrorContains(t, err, "no release matching")
+}
+
+func TestDirReleaseSourceIsRelativeToModule(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir, "releases", "v1.0.0"), 0o755))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "releases", "v1.0.0", testAssetName()),
+		newTestArchive(t, "plugin", "dir-binary"), 0o644))
+
+	rs, err := NewReleaseSource("example.com/org/plugin", &configuration.ExtensionSource{
+		Type: SourceTypeDir,
+		URL:  "releases/{{ .Tag }}",
+	}, dir, slogext.NewTestLogger(t))
+	assert.NilError(t, err)
+
+	_, contents := readRelease(t, rs)
+	assert.Equal(t, contents, "dir-binary")
+}
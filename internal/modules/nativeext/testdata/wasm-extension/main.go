@@ -0,0 +1,77 @@
+// Package main implements a WebAssembly extension used by the
+// nativeext tests. It's compiled with GOOS=wasip1 GOARCH=wasm.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go.rgst.io/stencil/pkg/extensions/apiv1"
+)
+
+// _ is a compile time assertion we implement the interface
+var _ apiv1.Implementation = &extension{}
+
+// extension is a test extension
+type extension struct{}
+
+// GetConfig implements apiv1.Implementation
+func (*extension) GetConfig() (*apiv1.Config, error) {
+	return &apiv1.Config{}, nil
+}
+
+// GetTemplateFunctions implements apiv1.Implementation
+func (*extension) GetTemplateFunctions() ([]*apiv1.TemplateFunction, error) {
+	return []*apiv1.TemplateFunction{
+		{Name: "Echo", NumberOfArguments: 1},
+		{Name: "ReadFile", NumberOfArguments: 1},
+		{Name: "Env", NumberOfArguments: 1},
+		{Name: "Loop"},
+		{Name: "Alloc", NumberOfArguments: 1},
+		{Name: "Print", NumberOfArguments: 1},
+	}, nil
+}
+
+// ExecuteTemplateFunction implements apiv1.Implementation
+func (*extension) ExecuteTemplateFunction(t *apiv1.TemplateFunctionExec) (interface{}, error) {
+	fmt.Fprintln(os.Stderr, "executing", t.Name)
+
+	switch t.Name {
+	case "Echo":
+		return map[string]any{"echo": t.Arguments[0]}, nil
+	case "ReadFile":
+		b, err := os.ReadFile(t.Arguments[0].(string))
+		return string(b), err
+	case "Env":
+		return os.Getenv(t.Arguments[0].(string)), nil
+	case "Loop":
+		for {
+			looped++
+		}
+	case "Alloc":
+		b := make([]byte, int(t.Arguments[0].(float64)))
+		for i := range b {
+			b[i] = 1
+		}
+		return len(b), nil
+	case "Print":
+		line := strings.Repeat("a", 1023) + "\n"
+		for range int(t.Arguments[0].(float64)) / len(line) {
+			fmt.Fprint(os.Stderr, line)
+		}
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("unknown function %q", t.Name)
+}
+
+// looped is incremented by Loop, so that the loop isn't optimized away
+var looped int
+
+func main() {
+	if err := apiv1.NewWASMExtensionImplementation(&extension{}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
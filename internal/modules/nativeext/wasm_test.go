@@ -0,0 +1,196 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nativeext_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.rgst.io/stencil/internal/modules/nativeext"
+	"go.rgst.io/stencil/internal/modules/resolver"
+	"go.rgst.io/stencil/pkg/slogext"
+	"gotest.tools/v3/assert"
+)
+
+// buildWASMExtension compiles testdata/wasm-extension into a module
+// directory as bin/plugin.wasm and returns the path to the module.
+func buildWASMExtension(t *testing.T) string {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not found, unable to build WebAssembly extension")
+	}
+
+	dir := t.TempDir()
+	cmd := exec.Command("go", "build", "-o", filepath.Join(dir, "bin", "plugin.wasm"), "./testdata/wasm-extension")
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	out, err := cmd.CombinedOutput()
+	assert.NilError(t, err, "failed to build WebAssembly extension: %s", string(out))
+
+	return dir
+}
+
+func TestCanUseWASMExtension(t *testing.T) {
+	ctx := context.Background()
+	dir := buildWASMExtension(t)
+
+	ext := nativeext.NewHost(slogext.NewTestLogger(t))
+	defer ext.Close()
+
+	err := ext.RegisterExtension(ctx, "file://"+dir, "test", &resolver.Version{Virtual: "local"},
+		&nativeext.RegisterExtensionOpts{WASM: true})
+	assert.NilError(t, err, "failed to register extension")
+
+	caller, err := ext.GetExtensionCaller(ctx)
+	assert.NilError(t, err, "failed to get extension caller")
+
+	resp, err := caller.Call("test.Echo", "hello")
+	assert.NilError(t, err, "failed to call extension")
+	assert.DeepEqual(t, resp, map[string]any{"echo": "hello"})
+
+	// Extensions are executed once per call, so ensure that multiple
+	// calls work.
+	resp, err = caller.Call("test.Echo", []any{"a", 1.0})
+	assert.NilError(t, err, "failed to call extension")
+	assert.DeepEqual(t, resp, map[string]any{"echo": []any{"a", 1.0}})
+}
+
+func TestWASMExtensionIsSandboxed(t *testing.T) {
+	ctx := context.Background()
+	dir := buildWASMExtension(t)
+	t.Setenv("STENCIL_TEST_SECRET", "secret")
+
+	ext := nativeext.NewHost(slogext.NewTestLogger(t))
+	defer ext.Close()
+
+	err := ext.RegisterExtension(ctx, "file://"+dir, "test", &resolver.Version{Virtual: "local"},
+		&nativeext.RegisterExtensionOpts{WASM: true})
+	assert.NilError(t, err, "failed to register extension")
+
+	caller, err := ext.GetExtensionCaller(ctx)
+	assert.NilError(t, err, "failed to get extension caller")
+
+	// No filesystem is mounted into the extension.
+	_, err = caller.Call("test.ReadFile", filepath.Join(dir, "bin", "plugin.wasm"))
+	assert.ErrorContains(t, err, "failed to execute template function \"test.ReadFile\"")
+
+	// No environment variables are passed to the extension.
+	resp, err := caller.Call("test.Env", "STENCIL_TEST_SECRET")
+	assert.NilError(t, err, "failed to call extension")
+	assert.Equal(t, resp, "")
+}
+
+func TestCanInspectExtensions(t *testing.T) {
+	ctx := context.Background()
+	dir := buildWASMExtension(t)
+
+	ext := nativeext.NewHost(slogext.NewTestLogger(t))
+	defer ext.Close()
+
+	version := &resolver.Version{Virtual: "local"}
+	err := ext.RegisterExtension(ctx, "file://"+dir, "test", version,
+		&nativeext.RegisterExtensionOpts{WASM: true})
+	assert.NilError(t, err, "failed to register extension")
+
+	exts := ext.Extensions()
+	assert.Equal(t, len(exts), 1)
+	assert.Equal(t, exts[0].Name, "test")
+	assert.Equal(t, exts[0].Version, version)
+	assert.Equal(t, exts[0].Path, filepath.Join(dir, "bin", "plugin.wasm"))
+	assert.Equal(t, exts[0].WASM, true)
+	assert.Equal(t, len(exts[0].Checksum), 64)
+
+	funcs, err := ext.GetTemplateFunctions("test")
+	assert.NilError(t, err, "failed to get template functions")
+
+	args := make(map[string]int, len(funcs))
+	names := make([]string, 0, len(funcs))
+	for _, f := range funcs {
+		names = append(names, f.Name)
+		args[f.Name] = f.NumberOfArguments
+	}
+	assert.DeepEqual(t, names, []string{"Alloc", "Echo", "Env", "Loop", "Print", "ReadFile"})
+	assert.DeepEqual(t, args, map[string]int{"Alloc": 1, "Echo": 1, "Env": 1, "Loop": 0, "Print": 1, "ReadFile": 1})
+
+	_, err = ext.GetTemplateFunctions("unknown")
+	assert.ErrorContains(t, err, "unknown")
+}
+
+func TestWASMExtensionCanBeStopped(t *testing.T) {
+	dir := buildWASMExtension(t)
+
+	ext := nativeext.NewHost(slogext.NewTestLogger(t))
+	defer ext.Close()
+
+	err := ext.RegisterExtension(context.Background(), "file://"+dir, "test", &resolver.Version{Virtual: "local"},
+		&nativeext.RegisterExtensionOpts{WASM: true})
+	assert.NilError(t, err, "failed to register extension")
+
+	// Calls are stopped through the context of the caller (i.e. of the
+	// render), not the one the extension was registered with
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	caller, err := ext.GetExtensionCaller(ctx)
+	assert.NilError(t, err, "failed to get extension caller")
+
+	time.AfterFunc(100*time.Millisecond, cancel)
+	_, err = caller.Call("test.Loop")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestWASMExtensionMemoryIsLimited(t *testing.T) {
+	ctx := context.Background()
+	dir := buildWASMExtension(t)
+
+	ext := nativeext.NewHost(slogext.NewTestLogger(t))
+	defer ext.Close()
+
+	err := ext.RegisterExtension(ctx, "file://"+dir, "test", &resolver.Version{Virtual: "local"},
+		&nativeext.RegisterExtensionOpts{WASM: true})
+	assert.NilError(t, err, "failed to register extension")
+
+	caller, err := ext.GetExtensionCaller(ctx)
+	assert.NilError(t, err, "failed to get extension caller")
+
+	resp, err := caller.Call("test.Alloc", float64(1<<20))
+	assert.NilError(t, err, "expected a small allocation to succeed")
+	assert.Equal(t, resp, float64(1<<20))
+
+	_, err = caller.Call("test.Alloc", float64(1<<30))
+	assert.ErrorContains(t, err, "failed to execute extension")
+}
+
+func TestWASMExtensionOutputIsLimited(t *testing.T) {
+	ctx := context.Background()
+	dir := buildWASMExtension(t)
+
+	ext := nativeext.NewHost(slogext.NewTestLogger(t))
+	defer ext.Close()
+
+	err := ext.RegisterExtension(ctx, "file://"+dir, "test", &resolver.Version{Virtual: "local"},
+		&nativeext.RegisterExtensionOpts{WASM: true})
+	assert.NilError(t, err, "failed to register extension")
+
+	caller, err := ext.GetExtensionCaller(ctx)
+	assert.NilError(t, err, "failed to get extension caller")
+
+	_, err = caller.Call("test.Print", float64(1<<20))
+	assert.NilError(t, err, "expected a small amount of output to be allowed")
+
+	_, err = caller.Call("test.Print", float64(1<<30))
+	assert.ErrorContains(t, err, "it wrote more than 16777216 bytes of output")
+}
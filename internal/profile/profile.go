@@ -0,0 +1,202 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements recording how long the steps of a
+// stencil run take.
+
+// Package profile implements recording how long the steps of a stencil
+// run take, and reporting them as a table or as a Chrome trace.
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Profiler records how long the steps of a stencil run take. A nil
+// Profiler records nothing, so that callers don't need to check if
+// profiling is enabled.
+type Profiler struct {
+	// start is when the profiler was created, events are relative to it
+	start time.Time
+
+	// mu protects events and lanes
+	mu sync.Mutex
+
+	// events are the recorded events, in the order they finished
+	events []event
+
+	// lanes denotes which lanes are in use by a running step, steps that
+	// run at the same time are shown on separate lanes in traces
+	lanes []bool
+}
+
+// event is a step of a run that finished
+type event struct {
+	// category is the kind of step (e.g., "render")
+	category string
+
+	// name identifies the step within its category
+	name string
+
+	// start is when the step started
+	start time.Time
+
+	// duration is how long the step took
+	duration time.Duration
+
+	// lane is the lane the step ran on, see Profiler.lanes
+	lane int
+}
+
+// New returns a new Profiler, events are recorded relative to the time
+// it was created
+func New() *Profiler {
+	return &Profiler{start: time.Now()}
+}
+
+// Start records the start of a step with the provided category and
+// name. The returned function must be called once the step finished.
+func (p *Profiler) Start(category, name string) func() {
+	if p == nil {
+		return func() {}
+	}
+
+	p.mu.Lock()
+	lane := len(p.lanes)
+	for i, used := range p.lanes {
+		if !used {
+			lane = i
+			break
+		}
+	}
+	if lane == len(p.lanes) {
+		p.lanes = append(p.lanes, true)
+	} else {
+		p.lanes[lane] = true
+	}
+	p.mu.Unlock()
+
+	start := time.Now()
+	return func() {
+		d := time.Since(start)
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.lanes[lane] = false
+		p.events = append(p.events, event{category, name, start, d, lane})
+	}
+}
+
+// summary is the total duration of the steps with the same category
+// and name
+type summary struct {
+	category string
+	name     string
+	calls    int
+	total    time.Duration
+	max      time.Duration
+}
+
+// WriteTable writes a table of how long each step took to w, slowest
+// first. Steps with the same category and name, like calls of the same
+// extension function, are summed up.
+func (p *Profiler) WriteTable(w io.Writer) error {
+	p.mu.Lock()
+	byName := make(map[[2]string]*summary)
+	for _, e := range p.events {
+		k := [2]string{e.category, e.name}
+		s := byName[k]
+		if s == nil {
+			s = &summary{category: e.category, name: e.name}
+			byName[k] = s
+		}
+		s.calls++
+		s.total += e.duration
+		s.max = max(s.max, e.duration)
+	}
+	p.mu.Unlock()
+
+	summaries := make([]*summary, 0, len(byName))
+	for _, s := range byName {
+		summaries = append(summaries, s)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].total != summaries[j].total {
+			return summaries[i].total > summaries[j].total
+		}
+		if summaries[i].category != summaries[j].category {
+			return summaries[i].category < summaries[j].category
+		}
+		return summaries[i].name < summaries[j].name
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CATEGORY\tNAME\tCALLS\tTOTAL\tMAX")
+	for _, s := range summaries {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", s.category, s.name, s.calls,
+			s.total.Round(time.Microsecond), s.max.Round(time.Microsecond))
+	}
+	return tw.Flush()
+}
+
+// traceEvent is an event of the Chrome trace event format, see
+// https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+type traceEvent struct {
+	Name     string            `json:"name"`
+	Category string            `json:"cat,omitempty"`
+	Phase    string            `json:"ph"`
+	Time     float64           `json:"ts"`
+	Duration float64           `json:"dur,omitempty"`
+	PID      int               `json:"pid"`
+	TID      int               `json:"tid"`
+	Args     map[string]string `json:"args,omitempty"`
+}
+
+// WriteTrace writes the recorded steps to w as a Chrome trace, which
+// can be viewed with Perfetto (https://ui.perfetto.dev) or
+// chrome://tracing.
+func (p *Profiler) WriteTrace(w io.Writer) error {
+	p.mu.Lock()
+	events := make([]traceEvent, 0, len(p.events)+1)
+	events = append(events, traceEvent{
+		Name:  "process_name",
+		Phase: "M",
+		PID:   1,
+		Args:  map[string]string{"name": "stencil"},
+	})
+	for _, e := range p.events {
+		events = append(events, traceEvent{
+			Name:     e.name,
+			Category: e.category,
+			Phase:    "X",
+			Time:     float64(e.start.Sub(p.start).Nanoseconds()) / 1e3,
+			Duration: float64(e.duration.Nanoseconds()) / 1e3,
+			PID:      1,
+			TID:      e.lane + 1,
+		})
+	}
+	p.mu.Unlock()
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time < events[j].Time })
+	return json.NewEncoder(w).Encode(map[string]any{
+		"traceEvents":     events,
+		"displayTimeUnit": "ms",
+	})
+}
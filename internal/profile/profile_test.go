@@ -0,0 +1,79 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package profile
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestNilProfilerRecordsNothing(t *testing.T) {
+	var p *Profiler
+	p.Start("render", "a.tpl")()
+}
+
+func TestWriteTableSumsUpSteps(t *testing.T) {
+	p := New()
+	p.Start("extension", "ext.fn")()
+	p.Start("extension", "ext.fn")()
+	p.Start("render", "a.tpl (pass 1)")()
+
+	var buf bytes.Buffer
+	assert.NilError(t, p.WriteTable(&buf))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, len(lines), 3)
+	assert.Assert(t, strings.HasPrefix(lines[0], "CATEGORY"))
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "extension":
+			assert.Equal(t, fields[2], "2", "expected the calls of ext.fn to be summed up")
+		case "render":
+			assert.DeepEqual(t, fields[1:4], []string{"a.tpl", "(pass", "1)"})
+		default:
+			t.Fatalf("unexpected line %q", line)
+		}
+	}
+}
+
+func TestWriteTraceUsesLanesForConcurrentSteps(t *testing.T) {
+	p := New()
+	doneA := p.Start("render", "a.tpl")
+	doneB := p.Start("render", "b.tpl")
+	doneB()
+	doneA()
+	p.Start("render", "c.tpl")()
+
+	var buf bytes.Buffer
+	assert.NilError(t, p.WriteTrace(&buf))
+
+	var trace struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	assert.NilError(t, json.Unmarshal(buf.Bytes(), &trace))
+
+	lanes := make(map[string]int)
+	for _, e := range trace.TraceEvents {
+		if e.Phase == "X" {
+			lanes[e.Name] = e.TID
+		}
+	}
+	assert.DeepEqual(t, lanes, map[string]int{"a.tpl": 1, "b.tpl": 2, "c.tpl": 1})
+}
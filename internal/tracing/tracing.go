@@ -0,0 +1,184 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements tracing stencil runs with
+// OpenTelemetry.
+
+// Package tracing implements tracing stencil runs with OpenTelemetry.
+// Spans are created through the global tracer provider, so nothing is
+// recorded unless Setup was called.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.rgst.io/stencil/internal/version"
+)
+
+// Attributes set on spans
+const (
+	// ProjectKey is the name of the project being rendered
+	ProjectKey = attribute.Key("stencil.project")
+
+	// ModuleKey is the import path of a module
+	ModuleKey = attribute.Key("stencil.module")
+
+	// VersionKey is the version of a module
+	VersionKey = attribute.Key("stencil.module.version")
+
+	// TemplateKey is the path of a template within its module
+	TemplateKey = attribute.Key("stencil.template")
+
+	// PassKey is the render pass, see codegen.Stencil.Render
+	PassKey = attribute.Key("stencil.pass")
+)
+
+// tracerName is the name of the tracer spans are created with
+const tracerName = "go.rgst.io/stencil"
+
+// Options configures where spans are exported to, see Setup
+type Options struct {
+	// File is the path of the file spans are written to as JSON when no
+	// OTLP endpoint is configured. DefaultFile is used when empty.
+	File string
+}
+
+// DefaultFile returns the path of the file spans are written to when
+// neither an OTLP endpoint nor Options.File is set, which is replaced on
+// every run. It's stored in the user's cache directory so that it
+// doesn't end up in the project.
+func DefaultFile() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "stencil", "trace.json"), nil
+}
+
+// Setup configures the global tracer provider to export spans. Spans
+// are exported via OTLP when an endpoint is configured through the
+// standard OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT environment variables, and are
+// written to opts.File, or DefaultFile, otherwise. Tracing is disabled
+// when the default file can't be created, e.g. because there is no user
+// cache directory.
+//
+// The returned function flushes the spans that weren't exported yet
+// and must be called before exiting.
+func Setup(ctx context.Context, opts *Options) (func(context.Context) error, error) {
+	var exp sdktrace.SpanExporter
+	var closer func() error
+	switch {
+	case otlpConfigured():
+		var err error
+		if exp, err = otlptracehttp.New(ctx); err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+	default:
+		f, err := createFile(opts.File)
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			return func(context.Context) error { return nil }, nil
+		}
+		if exp, err = stdouttrace.New(stdouttrace.WithWriter(f)); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to create file exporter: %w", err)
+		}
+		closer = f.Close
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("stencil"),
+		semconv.ServiceVersion(version.Version),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer())
+		}
+		return err
+	}, nil
+}
+
+// createFile creates the file spans are written to, path or DefaultFile
+// when it's empty. Since the default file is only written on a best
+// effort basis, nil is returned when it can't be created.
+func createFile(path string) (*os.File, error) {
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create trace file: %w", err)
+		}
+		return f, nil
+	}
+
+	path, err := DefaultFile()
+	if err != nil {
+		return nil, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// otlpConfigured returns true if an OTLP endpoint is configured through
+// the environment
+func otlpConfigured() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Start starts a span with the provided name and attributes, as a child
+// of the span in ctx if there is one. The span must be ended, see End.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, marking it as failed with err if it's set
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
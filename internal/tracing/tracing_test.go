@@ -0,0 +1,56 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestSetupWritesSpansToFile(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "trace.json")
+	shutdown, err := Setup(ctx, &Options{File: path})
+	assert.NilError(t, err)
+
+	ctx, parent := Start(ctx, "parent", ModuleKey.String("testing"))
+	_, child := Start(ctx, "child")
+	End(child, errors.New("boom"))
+	End(parent, nil)
+	assert.NilError(t, shutdown(context.Background()))
+
+	b, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	trace := string(b)
+	for _, want := range []string{`"Name":"parent"`, `"Name":"child"`, `"stencil.module"`, `"boom"`} {
+		assert.Assert(t, strings.Contains(trace, want), "expected trace to contain %s:\n%s", want, trace)
+	}
+}
+
+func TestSetupWritesSpansToDefaultFile(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	ctx := context.Background()
+	shutdown, err := Setup(ctx, &Options{})
+	assert.NilError(t, err)
+
+	_, span := Start(ctx, "run")
+	End(span, nil)
+	assert.NilError(t, shutdown(context.Background()))
+
+	path, err := DefaultFile()
+	assert.NilError(t, err)
+	assert.Equal(t, path, filepath.Join(os.Getenv("XDG_CACHE_HOME"), "stencil", "trace.json"))
+	b, err := os.ReadFile(path)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(b), `"Name":"run"`), string(b))
+}
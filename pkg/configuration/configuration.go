@@ -9,6 +9,7 @@ package configuration
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
 
 	"gopkg.in/yaml.v3"
@@ -41,10 +42,16 @@ func NewManifest(path string) (*Manifest, error) {
 // NewDefaultManifest returns a parsed project manifest
 // from a set default path on disk.
 func NewDefaultManifest() (*Manifest, error) {
+	return NewDefaultManifestFromDir(".")
+}
+
+// NewDefaultManifestFromDir returns a parsed project manifest from a
+// set default path in the provided directory.
+func NewDefaultManifestFromDir(dir string) (*Manifest, error) {
 	manifestFiles := []string{"stencil.yaml", "service.yaml"}
 	for _, file := range manifestFiles {
-		if _, err := os.Stat(file); err == nil {
-			return NewManifest(file)
+		if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+			return NewManifest(filepath.Join(dir, file))
 		}
 	}
 
@@ -73,8 +80,31 @@ type Manifest struct {
 	// - local file: file://path/to/module
 	// - remote file: https://github.com/getoutreach/stencil-base
 	Replacements map[string]string `yaml:"replacements,omitempty"`
+
+	// OrphanedBlocks controls what happens to the contents of blocks that
+	// exist in a file, but are no longer rendered by its template (e.g.
+	// because the block was removed or renamed). Defaults to "warn".
+	OrphanedBlocks OrphanedBlocksPolicy `yaml:"orphanedBlocks,omitempty" jsonschema:"enum=preserve,enum=warn,enum=fail"`
 }
 
+// OrphanedBlocksPolicy is a policy for handling the contents of blocks
+// that are no longer rendered by their template.
+type OrphanedBlocksPolicy string
+
+// This block contains all of the valid OrphanedBlocksPolicy values.
+const (
+	// OrphanedBlocksPreserve writes the contents of orphaned blocks to a
+	// sidecar file next to the file they were removed from, and warns.
+	OrphanedBlocksPreserve OrphanedBlocksPolicy = "preserve"
+
+	// OrphanedBlocksWarn warns that the contents of orphaned blocks will
+	// be lost.
+	OrphanedBlocksWarn OrphanedBlocksPolicy = "warn"
+
+	// OrphanedBlocksFail fails rendering when a block is orphaned.
+	OrphanedBlocksFail OrphanedBlocksPolicy = "fail"
+)
+
 // TemplateRepository is a repository of template files.
 type TemplateRepository struct {
 	// Name is the name of this module. This should be a valid go import path
@@ -115,6 +145,68 @@ type TemplateRepositoryManifest struct {
 
 	// DirReplacements is a list of directory name replacement templates to render
 	DirReplacements map[string]string `yaml:"dirReplacements,omitempty"`
+
+	// ExtensionSource configures where releases of the native extension
+	// provided by this module are downloaded from. When not set, releases
+	// are downloaded from the Github repository of the module.
+	ExtensionSource *ExtensionSource `yaml:"extensionSource,omitempty"`
+
+	// BlockCommentStyles declares additional comment styles that blocks
+	// can be written in, keyed by the extension (e.g. ".bat") or the name
+	// (e.g. "Justfile") of the files they apply to. They are used in
+	// addition to the built-in comment styles.
+	BlockCommentStyles map[string][]*BlockCommentStyle `yaml:"blockCommentStyles,omitempty"`
+
+	// Files are rules that control which of the static files in the
+	// files/ directory of this module are copied into projects. Every
+	// static file is copied unless a rule that matches it says otherwise.
+	Files []*FileRule `yaml:"files,omitempty"`
+}
+
+// FileRule is a rule that controls if the static files matching it are
+// copied into a project.
+type FileRule struct {
+	// Glob matches the paths, relative to the files/ directory, of the
+	// files this rule applies to. In addition to the syntax supported by
+	// path.Match, ** matches any number of directories.
+	Glob string `yaml:"glob" jsonschema:"required"`
+
+	// If is a template, with the same functions and values that are
+	// available to templates, that must render to "true" for the files
+	// to be copied, e.g. '{{ stencil.Arg "docs" }}'.
+	If string `yaml:"if,omitempty"`
+}
+
+// BlockCommentStyle is a style of comment that block markers, like
+// <<Stencil::Block(name)>>, can be written in.
+type BlockCommentStyle struct {
+	// Prefix is the string that starts the comment, e.g. "REM"
+	Prefix string `yaml:"prefix" jsonschema:"required"`
+
+	// Suffix is the string that ends the comment, e.g. "*)". When set,
+	// it must be the last thing on the line of a block marker.
+	Suffix string `yaml:"suffix,omitempty"`
+}
+
+// ExtensionSource configures where the releases of a native extension
+// are downloaded from.
+type ExtensionSource struct {
+	// Type is the type of source to download releases from. Supported
+	// values are "github", "gitlab", "http" and "dir". When not set, the
+	// type is inferred from the scheme of URL (github://, gitlab://,
+	// http(s)://, file://), defaulting to "github".
+	Type string `yaml:"type,omitempty" jsonschema:"enum=github,enum=gitlab,enum=http,enum=dir"`
+
+	// URL is the location of the releases, its meaning depends on Type:
+	//  - github: the repository URL, defaults to https://<name>
+	//  - gitlab: the project URL, e.g. https://gitlab.example.com/group/project,
+	//    the instance may be served from a path (https://example.com/gitlab/group/project)
+	//  - http: a URL template that points to a release archive
+	//  - dir: a directory template that contains release archives, relative
+	//    to the directory of the module
+	//
+	// Templates have access to .Name, .Tag, .Version, .OS and .Arch.
+	URL string `yaml:"url,omitempty"`
 }
 
 // PostRunCommandSpec is the spec of a command to be ran and its
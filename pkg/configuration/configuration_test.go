@@ -68,3 +68,10 @@ func TestShouldUseStencilOverServiceYaml(t *testing.T) {
 
 	assert.Equal(t, sm.Name, "stencil")
 }
+
+func TestNewDefaultManifestFromDir(t *testing.T) {
+	sm, err := configuration.NewDefaultManifestFromDir("testdata/interop/stencil-over-service")
+	assert.NilError(t, err)
+
+	assert.Equal(t, sm.Name, "stencil")
+}
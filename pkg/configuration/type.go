@@ -21,6 +21,12 @@ const (
 	// this type is used together with the TemplateRepositoryTypeTemplates.
 	TemplateRepositoryTypeExt TemplateRepositoryType = "extension"
 
+	// TemplateRepositoryTypeWASMExt denotes a repository as being a
+	// WebAssembly extension repository. This means that it contains an
+	// extension compiled to WebAssembly (GOOS=wasip1 GOARCH=wasm) that
+	// is executed by an embedded runtime instead of go-plugin.
+	TemplateRepositoryTypeWASMExt TemplateRepositoryType = "wasm-extension"
+
 	// TemplateRepositoryTypeTemplates denotes a repository as being a standard template repository.
 	// When the same module/repo serves more than one type, join this explicit value with other
 	// types, e.g. "templates,extension".
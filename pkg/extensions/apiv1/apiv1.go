@@ -25,6 +25,18 @@ const (
 	CookieValue = apiv1.CookieValue
 )
 
+// This block contains the types that can be used for arguments (and
+// return values) of a template function.
+const (
+	ArgumentTypeAny    = apiv1.ArgumentTypeAny
+	ArgumentTypeString = apiv1.ArgumentTypeString
+	ArgumentTypeBool   = apiv1.ArgumentTypeBool
+	ArgumentTypeInt    = apiv1.ArgumentTypeInt
+	ArgumentTypeFloat  = apiv1.ArgumentTypeFloat
+	ArgumentTypeList   = apiv1.ArgumentTypeList
+	ArgumentTypeMap    = apiv1.ArgumentTypeMap
+)
+
 // Implementation is the interface that must be implemented by a native
 // extension.
 type Implementation = apiv1.Implementation
@@ -32,6 +44,10 @@ type Implementation = apiv1.Implementation
 // TemplateFunction is a request to create a new template function.
 type TemplateFunction = apiv1.TemplateFunction
 
+// TemplateFunctionArgument describes an argument of a template
+// function.
+type TemplateFunctionArgument = apiv1.TemplateFunctionArgument
+
 // TemplateFunctionExec executes a template function
 type TemplateFunctionExec = apiv1.TemplateFunctionExec
 
@@ -39,4 +55,7 @@ type TemplateFunctionExec = apiv1.TemplateFunctionExec
 // host.
 type Config = apiv1.Config
 
-var NewExtensionImplementation = apiv1.NewExtensionImplementation
+// NewWASMExtensionImplementation serves an Implementation as a
+// WebAssembly (WASI) extension. See apiv1.NewWASMExtensionImplementation
+// for more information.
+var NewWASMExtensionImplementation = apiv1.NewWASMExtensionImplementation
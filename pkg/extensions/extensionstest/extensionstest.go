@@ -0,0 +1,245 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package extensionstest contains helpers for testing native
+// extensions. Extensions can either be tested end to end, by building
+// and launching them the same way stencil does, or in-process through a
+// fake host that serializes data the same way the real transport does.
+package extensionstest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"go.rgst.io/stencil/internal/codegen"
+	"go.rgst.io/stencil/internal/modules"
+	"go.rgst.io/stencil/internal/modules/modulestest"
+	"go.rgst.io/stencil/internal/modules/nativeext"
+	iapiv1 "go.rgst.io/stencil/internal/modules/nativeext/apiv1"
+	"go.rgst.io/stencil/internal/modules/resolver"
+	"go.rgst.io/stencil/pkg/configuration"
+	"go.rgst.io/stencil/pkg/extensions/apiv1"
+	"go.rgst.io/stencil/pkg/slogext"
+	"gotest.tools/v3/assert"
+)
+
+// moduleName is the name of the module used to render templates
+const moduleName = "extensionstest"
+
+// Extension is a native extension under test. Create one with Build,
+// BuildWASM, NewFromBinary or NewInproc.
+type Extension struct {
+	t    *testing.T
+	log  slogext.Logger
+	name string
+
+	// dir is the module directory containing the extension binary at
+	// bin/plugin (or bin/plugin.wasm), empty for in-process extensions.
+	dir string
+
+	// wasm denotes the extension as a WebAssembly extension
+	wasm bool
+
+	// impl is the implementation of an in-process extension
+	impl apiv1.Implementation
+
+	// caller is used to call functions of the extension
+	caller *nativeext.ExtensionCaller
+}
+
+// Build compiles the Go package at pkg (e.g. ./plugin) into a native
+// extension and launches it with go-plugin, like stencil does. The
+// extension is registered as name, which is usually the import path of
+// the extension's module.
+func Build(t *testing.T, name, pkg string) *Extension {
+	t.Helper()
+
+	dir := t.TempDir()
+	goBuild(t, filepath.Join(dir, "bin", "plugin"), pkg)
+	return newFromDir(t, name, dir, false)
+}
+
+// BuildWASM compiles the Go package at pkg (e.g. ./plugin) into a
+// WebAssembly extension and runs it with the embedded runtime, like
+// stencil does. See Build.
+func BuildWASM(t *testing.T, name, pkg string) *Extension {
+	t.Helper()
+
+	dir := t.TempDir()
+	goBuild(t, filepath.Join(dir, "bin", "plugin.wasm"), pkg, "GOOS=wasip1", "GOARCH=wasm")
+	return newFromDir(t, name, dir, true)
+}
+
+// NewFromBinary launches an already built native extension. Paths ending
+// in .wasm are treated as WebAssembly extensions. See Build.
+func NewFromBinary(t *testing.T, name, path string) *Extension {
+	t.Helper()
+
+	path, err := filepath.Abs(path)
+	assert.NilError(t, err, "failed to get absolute path of extension")
+
+	wasm := strings.HasSuffix(path, ".wasm")
+	binName := "plugin"
+	if wasm {
+		binName += ".wasm"
+	}
+
+	dir := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir, "bin"), 0o755), "failed to create bin directory")
+	assert.NilError(t, os.Symlink(path, filepath.Join(dir, "bin", binName)), "failed to link extension")
+	return newFromDir(t, name, dir, wasm)
+}
+
+// NewInproc creates an extension backed by impl, which is called within
+// the same process by a fake host. Requests and responses are still
+// serialized the same way as they would be when impl is served over
+// go-plugin, so arguments and return values behave as they would in
+// stencil.
+func NewInproc(t *testing.T, name string, impl apiv1.Implementation) *Extension {
+	t.Helper()
+
+	e := &Extension{t: t, log: slogext.NewTestLogger(t), name: name, impl: impl}
+	e.init()
+	return e
+}
+
+// newFromDir creates an extension from a module directory that contains
+// the extension binary.
+func newFromDir(t *testing.T, name, dir string, wasm bool) *Extension {
+	t.Helper()
+
+	e := &Extension{t: t, log: slogext.NewTestLogger(t), name: name, dir: dir, wasm: wasm}
+	e.init()
+	return e
+}
+
+// goBuild builds the Go package at pkg into out with the provided
+// additional environment variables.
+func goBuild(t *testing.T, out, pkg string, env ...string) {
+	t.Helper()
+
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Fatalf("go toolchain not found, unable to build extension: %v", err)
+	}
+
+	cmd := exec.Command("go", "build", "-o", out, pkg)
+	cmd.Env = append(os.Environ(), env...)
+	b, err := cmd.CombinedOutput()
+	assert.NilError(t, err, "failed to build extension: %s", string(b))
+}
+
+// init registers the extension with a host used for Call
+func (e *Extension) init() {
+	e.t.Helper()
+
+	h := nativeext.NewHost(e.log)
+	e.t.Cleanup(func() { h.Close() })
+
+	if e.impl != nil {
+		h.RegisterInprocExtension(e.name, iapiv1.NewInprocExtensionClient(e.impl))
+	} else {
+		err := h.RegisterExtension(context.Background(), "file://"+e.dir, e.name, e.version(), e.registerOpts())
+		assert.NilError(e.t, err, "failed to register extension")
+	}
+
+	var err error
+	e.caller, err = h.GetExtensionCaller(context.Background())
+	assert.NilError(e.t, err, "failed to get extension caller")
+}
+
+// version returns the version the extension is registered with
+func (e *Extension) version() *resolver.Version {
+	return &resolver.Version{Virtual: "local"}
+}
+
+// registerOpts returns the options used to register the extension
+func (e *Extension) registerOpts() *nativeext.RegisterExtensionOpts {
+	return &nativeext.RegisterExtensionOpts{WASM: e.wasm}
+}
+
+// Call calls the function fn (without the extension name, e.g.
+// ParseGoMod) of the extension with the provided arguments.
+func (e *Extension) Call(fn string, args ...any) (any, error) {
+	return e.caller.Call(append([]any{e.name + "." + fn}, args...)...)
+}
+
+// Render renders the provided template contents with stencil, with the
+// extension registered, and returns the rendered output. The template
+// is rendered as the only template of a module, so it may use any
+// template function provided by stencil.
+func (e *Extension) Render(tpl string) (string, error) {
+	ctx := context.Background()
+
+	fs := memfs.New()
+	if err := util.WriteFile(fs, "manifest.yaml", []byte("name: "+moduleName+"\n"), 0o644); err != nil {
+		return "", err
+	}
+	if err := util.WriteFile(fs, "templates/test.tpl", []byte(tpl), 0o644); err != nil {
+		return "", err
+	}
+
+	m, err := modulestest.NewWithFS(ctx, moduleName, fs)
+	if err != nil {
+		return "", err
+	}
+
+	st := codegen.NewStencil(&configuration.Manifest{
+		Name:      moduleName,
+		Arguments: map[string]any{},
+	}, []*modules.Module{m}, e.log)
+	defer st.Close()
+
+	if e.impl != nil {
+		st.RegisterInprocExtensions(e.name, iapiv1.NewInprocExtensionClient(e.impl))
+	} else if err := st.RegisterExtension(ctx, "file://"+e.dir, e.name, e.version(), e.registerOpts()); err != nil {
+		return "", err
+	}
+
+	tpls, err := st.Render(ctx, e.log)
+	if err != nil {
+		return "", err
+	}
+
+	if len(tpls) != 1 || len(tpls[0].Files) != 1 {
+		return "", fmt.Errorf("expected template to render a single file")
+	}
+	return tpls[0].Files[0].String(), nil
+}
+
+// AssertCall calls the function fn of the extension and asserts that
+// it returns expected. See Call.
+func (e *Extension) AssertCall(expected any, fn string, args ...any) {
+	e.t.Helper()
+
+	resp, err := e.Call(fn, args...)
+	assert.NilError(e.t, err, "failed to call %s.%s", e.name, fn)
+	assert.DeepEqual(e.t, resp, expected)
+}
+
+// AssertRender renders tpl and asserts that the output is expected.
+// See Render.
+func (e *Extension) AssertRender(tpl, expected string) {
+	e.t.Helper()
+
+	out, err := e.Render(tpl)
+	assert.NilError(e.t, err, "failed to render template")
+	assert.Equal(e.t, out, expected)
+}
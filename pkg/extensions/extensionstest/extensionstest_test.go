@@ -0,0 +1,75 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package extensionstest_test
+
+import (
+	"testing"
+
+	"go.rgst.io/stencil/pkg/extensions/apiv1"
+	"go.rgst.io/stencil/pkg/extensions/extensionstest"
+	"gotest.tools/v3/assert"
+)
+
+// extension is an in-process test extension
+type extension struct{}
+
+// result is a value returned by the Struct function
+type result struct {
+	Name  string
+	Count int
+}
+
+// GetConfig implements apiv1.Implementation
+func (*extension) GetConfig() (*apiv1.Config, error) {
+	return &apiv1.Config{}, nil
+}
+
+// GetTemplateFunctions implements apiv1.Implementation
+func (*extension) GetTemplateFunctions() ([]*apiv1.TemplateFunction, error) {
+	return []*apiv1.TemplateFunction{
+		{Name: "Struct", NumberOfArguments: 1},
+	}, nil
+}
+
+// ExecuteTemplateFunction implements apiv1.Implementation
+func (*extension) ExecuteTemplateFunction(t *apiv1.TemplateFunctionExec) (interface{}, error) {
+	return result{Name: t.Arguments[0].(string), Count: 1}, nil
+}
+
+func TestInprocExtensionSerializesResponses(t *testing.T) {
+	ext := extensionstest.NewInproc(t, "test", &extension{})
+
+	// The response is returned as JSON would decode it, not as the
+	// struct returned by the extension.
+	ext.AssertCall(map[string]any{"Name": "a", "Count": 1.0}, "Struct", "a")
+	ext.AssertRender(`{{ (extensions.Call "test.Struct" "a").Name }}`, "a")
+}
+
+func TestInprocExtensionReportsErrors(t *testing.T) {
+	ext := extensionstest.NewInproc(t, "test", &extension{})
+
+	_, err := ext.Call("Struct", "a", "b")
+	assert.ErrorContains(t, err, "too many arguments")
+
+	_, err = ext.Render(`{{ extensions.Call "test.Unknown" }}`)
+	assert.ErrorContains(t, err, "extension 'test' doesn't provide function 'Unknown'")
+}
+
+func TestCanBuildExtension(t *testing.T) {
+	ext := extensionstest.Build(t, "test", "./testdata/plugin")
+
+	ext.AssertCall("HELLO", "Upper", "hello")
+	ext.AssertRender(`{{ extensions.Call "test.Upper" "hello" }}`, "HELLO")
+}
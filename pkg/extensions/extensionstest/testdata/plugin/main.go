@@ -0,0 +1,58 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package main implements a native extension used to test the
+// extensionstest package.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go.rgst.io/stencil/pkg/extensions/apiv1"
+	"go.rgst.io/stencil/pkg/slogext"
+)
+
+// extension is a test extension
+type extension struct{}
+
+// GetConfig implements apiv1.Implementation
+func (*extension) GetConfig() (*apiv1.Config, error) {
+	return &apiv1.Config{}, nil
+}
+
+// GetTemplateFunctions implements apiv1.Implementation
+func (*extension) GetTemplateFunctions() ([]*apiv1.TemplateFunction, error) {
+	return []*apiv1.TemplateFunction{{
+		Name:       "Upper",
+		Arguments:  []apiv1.TemplateFunctionArgument{{Name: "s", Type: apiv1.ArgumentTypeString}},
+		ReturnType: apiv1.ArgumentTypeString,
+	}}, nil
+}
+
+// ExecuteTemplateFunction implements apiv1.Implementation
+func (*extension) ExecuteTemplateFunction(t *apiv1.TemplateFunctionExec) (interface{}, error) {
+	if t.Name == "Upper" {
+		return strings.ToUpper(t.Arguments[0].(string)), nil
+	}
+	return nil, fmt.Errorf("unknown function %q", t.Name)
+}
+
+func main() {
+	if err := apiv1.NewExtensionImplementation(&extension{}, slogext.New()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
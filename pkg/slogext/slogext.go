@@ -19,6 +19,7 @@ package slogext
 
 import (
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 
@@ -59,6 +60,13 @@ func New() Logger {
 	return &logger{slog.New(handler), handler}
 }
 
+// NewWithWriter creates a new logger, like New, that writes to w
+// instead of stdout. Use io.Discard to discard all logs.
+func NewWithWriter(w io.Writer) Logger {
+	handler := charmlog.New(w)
+	return &logger{slog.New(handler), handler}
+}
+
 // logger is a simple wrapper around the slog.Logger interface. Use
 // [Logger] when passing around loggers in the stencil codebase.
 type logger struct {
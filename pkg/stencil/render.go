@@ -0,0 +1,267 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Description: This file implements rendering a project without the
+// stencil command, to and from a billy.Filesystem.
+
+package stencil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"go.rgst.io/stencil/internal/codegen"
+	"go.rgst.io/stencil/internal/modules"
+	"go.rgst.io/stencil/internal/modules/resolver"
+	"go.rgst.io/stencil/pkg/configuration"
+	"go.rgst.io/stencil/pkg/slogext"
+	"gopkg.in/yaml.v3"
+)
+
+// RenderOptions are options for Render
+type RenderOptions struct {
+	// Manifest is the manifest of the project to render, this is
+	// required.
+	Manifest *configuration.Manifest
+
+	// Modules are the contents of modules, by their import path, that
+	// are used instead of fetching them. Only modules listed in the
+	// manifest, or depended on by them, are rendered. Modules that are
+	// not provided are fetched like the stencil command does.
+	Modules map[string]billy.Filesystem
+
+	// FS is the filesystem of the project, existing files (e.g. to read
+	// blocks from) and the lockfile are read from it. When not set, the
+	// project is rendered as if it was empty.
+	FS billy.Filesystem
+
+	// Dir is the directory of the project on disk, information about
+	// its git repository (e.g. the current branch) is read from it. When
+	// not set, no git information is available to templates.
+	Dir string
+
+	// Log is the logger to use, when not set logs are discarded.
+	Log slogext.Logger
+}
+
+// RenderedFile is a file rendered by Render
+type RenderedFile struct {
+	// Name is the path of the file, relative to the root of the project
+	Name string
+
+	// Contents are the contents of the file
+	Contents []byte
+
+	// Mode is the mode of the file, it contains os.ModeSymlink if the
+	// file is a symlink
+	Mode os.FileMode
+
+	// Symlink is the target of the file, if it's a symlink
+	Symlink string
+
+	// Deleted denotes the file as deleted by its template, it should be
+	// removed from the project. Directories removed by file.RemoveAll
+	// are deleted files as well.
+	Deleted bool
+
+	// Template is the path of the template that rendered the file, or
+	// empty if the file wasn't rendered by a template (e.g. the sidecar
+	// file that preserves orphaned blocks).
+	Template string
+
+	// Module is the import path of the module the template belongs to
+	Module string
+
+	// Warnings are the warnings created while rendering the file
+	Warnings []string
+}
+
+// RenderResult is the result of Render
+type RenderResult struct {
+	// Files are the rendered files, sorted by name. Files skipped by
+	// their template are not included.
+	Files []*RenderedFile
+
+	// Lockfile is the lockfile of this render
+	Lockfile *Lockfile
+}
+
+// Render renders the project described by opts without the stencil
+// command, and without touching the current directory. Files are only
+// read from opts.FS, changes to it are made by RenderResult.Apply. Native
+// extensions are loaded as usual, but post-run commands are never
+// executed.
+func Render(ctx context.Context, opts *RenderOptions) (*RenderResult, error) {
+	if opts == nil || opts.Manifest == nil {
+		return nil, fmt.Errorf("a manifest is required")
+	}
+
+	log := opts.Log
+	if log == nil {
+		log = slogext.NewWithWriter(io.Discard)
+	}
+
+	fs := opts.FS
+	if fs == nil {
+		fs = memfs.New()
+	}
+
+	lock, err := LoadLockfileFS(fs)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load lockfile: %w", err)
+	}
+
+	replacements := make(map[string]*modules.Module, len(opts.Modules))
+	for name, mfs := range opts.Modules {
+		m, err := modules.New(ctx, "vfs://"+name, modules.NewModuleOpts{
+			ImportPath: name,
+			Version:    &resolver.Version{Virtual: "in-memory"},
+			FS:         mfs,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create module %q: %w", name, err)
+		}
+		replacements[name] = m
+	}
+
+	mods, err := modules.FetchModules(ctx, &modules.ModuleResolveOptions{
+		Manifest:     opts.Manifest,
+		Log:          log,
+		Replacements: replacements,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	st := codegen.NewStencil(opts.Manifest, mods, log)
+	defer st.Close()
+	st.SetProjectFS(fs)
+	st.SetGitDir(opts.Dir)
+	st.SetLockfile(lock)
+
+	if err := st.RegisterExtensions(ctx); err != nil {
+		return nil, err
+	}
+
+	tpls, err := st.Render(ctx, log)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := renderedFiles(fs, opts.Manifest, tpls)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RenderResult{Files: files, Lockfile: st.GenerateLockfile(tpls)}, nil
+}
+
+// renderedFiles converts the files rendered by tpls into RenderedFiles,
+// including the sidecar files that preserve orphaned blocks, which are
+// appended to their existing contents in fs.
+func renderedFiles(fs billy.Filesystem, m *configuration.Manifest, tpls []*codegen.Template) ([]*RenderedFile, error) {
+	preserveOrphans := m.OrphanedBlocks == configuration.OrphanedBlocksPreserve
+
+	var files []*RenderedFile
+	for _, tpl := range tpls {
+		for _, f := range tpl.Files {
+			if f.Skipped {
+				continue
+			}
+
+			files = append(files, &RenderedFile{
+				Name:     f.Name(),
+				Contents: f.Bytes(),
+				Mode:     f.Mode(),
+				Symlink:  f.SymlinkTarget(),
+				Deleted:  f.Deleted,
+				Template: tpl.Path,
+				Module:   tpl.Module.Name,
+				Warnings: f.Warnings,
+			})
+
+			if f.Deleted || !preserveOrphans {
+				continue
+			}
+
+			sidecar, contents, err := f.OrphanedBlocksSidecarFile(fs)
+			if err != nil {
+				return nil, err
+			}
+			if sidecar != "" {
+				files = append(files, &RenderedFile{Name: sidecar, Contents: contents, Mode: 0o644})
+			}
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	return files, nil
+}
+
+// Filesystem returns a new in-memory filesystem that contains the
+// rendered files and the lockfile. Deleted files are not included.
+func (r *RenderResult) Filesystem() (billy.Filesystem, error) {
+	fs := memfs.New()
+	if err := r.Apply(fs); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// Apply writes the rendered files, and the lockfile, to fs, which
+// usually is the filesystem of the project that was rendered. Existing
+// files are replaced and deleted files, or directories, are removed
+// before any file is written.
+func (r *RenderResult) Apply(fs billy.Filesystem) error {
+	for _, deleted := range []bool{true, false} {
+		for _, f := range r.Files {
+			if f.Deleted != deleted {
+				continue
+			}
+			if err := applyFile(fs, f); err != nil {
+				return err
+			}
+		}
+	}
+
+	b, err := yaml.Marshal(r.Lockfile)
+	if err != nil {
+		return fmt.Errorf("failed to encode lockfile: %w", err)
+	}
+	if err := util.WriteFile(fs, LockfileName, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write lockfile: %w", err)
+	}
+	return nil
+}
+
+// applyFile writes f to fs, see RenderResult.Apply
+func applyFile(fs billy.Filesystem, f *RenderedFile) error {
+	if !f.Deleted {
+		return codegen.WriteFile(fs, f.Name, f.Contents, f.Mode, f.Symlink)
+	}
+
+	if err := util.RemoveAll(fs, f.Name); err != nil {
+		return fmt.Errorf("failed to remove %q: %w", f.Name, err)
+	}
+	return nil
+}
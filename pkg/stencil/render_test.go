@@ -0,0 +1,127 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"go.rgst.io/stencil/pkg/configuration"
+	"go.rgst.io/stencil/pkg/slogext"
+	"go.rgst.io/stencil/pkg/stencil"
+	"gotest.tools/v3/assert"
+)
+
+func TestRenderInMemory(t *testing.T) {
+	mod := memfs.New()
+	assert.NilError(t, util.WriteFile(mod, "manifest.yaml", []byte("name: testing\n"), 0o644))
+	assert.NilError(t, util.WriteFile(mod, "templates/hello.txt.tpl", []byte("Hello, {{ .Config.Name }}!\n"+
+		"## <<Stencil::Block(custom)>>\n{{ file.Block \"custom\" }}\n## <</Stencil::Block>>\n"), 0o644))
+	assert.NilError(t, util.WriteFile(mod, "templates/run.sh.tpl", []byte("{{ file.SetExecutable }}#!/bin/sh\n"), 0o644))
+	assert.NilError(t, util.WriteFile(mod, "templates/link.tpl", []byte(`{{ file.Symlink "hello.txt" }}`), 0o644))
+
+	project := memfs.New()
+	assert.NilError(t, util.WriteFile(project, "hello.txt", []byte("Hello, old!\n"+
+		"## <<Stencil::Block(custom)>>\nkept\n## <</Stencil::Block>>\n"), 0o644))
+
+	res, err := stencil.Render(context.Background(), &stencil.RenderOptions{
+		Manifest: &configuration.Manifest{
+			Name:    "test",
+			Modules: []*configuration.TemplateRepository{{Name: "testing"}},
+		},
+		Modules: map[string]billy.Filesystem{"testing": mod},
+		FS:      project,
+		Log:     slogext.NewTestLogger(t),
+	})
+	assert.NilError(t, err, "expected Render() to not fail")
+
+	assert.Equal(t, len(res.Files), 3)
+	assert.Equal(t, res.Files[0].Name, "hello.txt")
+	assert.Equal(t, string(res.Files[0].Contents),
+		"Hello, test!\n## <<Stencil::Block(custom)>>\nkept\n## <</Stencil::Block>>\n")
+	assert.Equal(t, res.Files[1].Name, "link")
+	assert.Equal(t, res.Files[1].Symlink, "hello.txt")
+	assert.Equal(t, res.Files[2].Name, "run.sh")
+	assert.Equal(t, res.Files[2].Mode.Perm(), os.FileMode(0o644|0o111))
+	assert.Equal(t, len(res.Lockfile.Files), 3)
+
+	// The project itself must not have been modified
+	b, err := util.ReadFile(project, "hello.txt")
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), "Hello, old!\n## <<Stencil::Block(custom)>>\nkept\n## <</Stencil::Block>>\n")
+
+	out, err := res.Filesystem()
+	assert.NilError(t, err, "expected Filesystem() to not fail")
+	b, err = util.ReadFile(out, "hello.txt")
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), string(res.Files[0].Contents))
+	target, err := out.Readlink("link")
+	assert.NilError(t, err)
+	assert.Equal(t, target, "hello.txt")
+	_, err = out.Stat(stencil.LockfileName)
+	assert.NilError(t, err, "expected lockfile to be written")
+
+	assert.NilError(t, res.Apply(project), "expected Apply() to not fail")
+	b, err = util.ReadFile(project, "hello.txt")
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), string(res.Files[0].Contents))
+}
+
+func TestRenderDefersRemoveAllToApply(t *testing.T) {
+	mod := memfs.New()
+	assert.NilError(t, util.WriteFile(mod, "manifest.yaml", []byte("name: testing\n"), 0o644))
+	assert.NilError(t, util.WriteFile(mod, "templates/new.txt.tpl", []byte(
+		`{{- $_ := file.RemoveAll "old" }}{{ file.SetPath "old/new.txt" }}new`), 0o644))
+
+	project := memfs.New()
+	assert.NilError(t, util.WriteFile(project, "old/file.txt", []byte("remove me"), 0o644))
+
+	res, err := stencil.Render(context.Background(), &stencil.RenderOptions{
+		Manifest: &configuration.Manifest{
+			Name:    "test",
+			Modules: []*configuration.TemplateRepository{{Name: "testing"}},
+		},
+		Modules: map[string]billy.Filesystem{"testing": mod},
+		FS:      project,
+		Log:     slogext.NewTestLogger(t),
+	})
+	assert.NilError(t, err, "expected Render() to not fail")
+
+	assert.Equal(t, len(res.Files), 2)
+	assert.Equal(t, res.Files[0].Name, "old")
+	assert.Assert(t, res.Files[0].Deleted)
+	assert.Equal(t, res.Files[1].Name, "old/new.txt")
+
+	_, err = project.Stat("old/file.txt")
+	assert.NilError(t, err, "expected Render() to not remove files")
+
+	// The directory is removed before the files are written into it
+	assert.NilError(t, res.Apply(project), "expected Apply() to not fail")
+	_, err = project.Stat("old/file.txt")
+	assert.Assert(t, errors.Is(err, os.ErrNotExist), "expected Apply() to remove the directory")
+	b, err := util.ReadFile(project, "old/new.txt")
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), "new")
+}
+
+func TestRenderRequiresManifest(t *testing.T) {
+	_, err := stencil.Render(context.Background(), &stencil.RenderOptions{})
+	assert.Error(t, err, "a manifest is required")
+}
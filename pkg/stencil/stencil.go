@@ -6,72 +6,45 @@
 package stencil
 
 import (
-	"os"
-	"path/filepath"
-
-	"go.rgst.io/stencil/internal/modules/resolver"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"go.rgst.io/stencil/internal/lockfile"
 	"gopkg.in/yaml.v3"
 )
 
 // This block contains constants of the lockfiles
 const (
 	// LockfileName is the name of the lockfile used by stencil
-	LockfileName = "stencil.lock"
+	LockfileName = lockfile.Name
+
+	// LockfileDefaultFileMode is the mode of files whose lockfile entry
+	// doesn't have one
+	LockfileDefaultFileMode = lockfile.DefaultFileMode
 )
 
 // LockfileModuleEntry is an entry in the lockfile for a module
 // that was used during the last run of stencil.
-type LockfileModuleEntry struct {
-	// Name is the name of the module. This usually comes from
-	// the TemplateManifest entry, but is up to the module
-	// package.
-	Name string
-
-	// URL is the url of the module that was used.
-	URL string
-
-	// Version is the version of the module that was
-	// downloaded at the time.
-	Version *resolver.Version
-}
+type LockfileModuleEntry = lockfile.ModuleEntry
 
 // LockfileFileEntry is an entry in the lockfile for a file
 // that was generated by stencil. This contains metadata on what
 // generated it among other future information
-type LockfileFileEntry struct {
-	// Name is the relative file path, to the invocation of stencil,
-	// of the generated file
-	Name string
-
-	// Template is the template that generated this file in the given
-	// module.
-	Template string
-
-	// Module is the URL of the module that generated this file.
-	Module string
-}
+type LockfileFileEntry = lockfile.FileEntry
 
 // Lockfile is generated by stencil on a ran to store version
 // information.
-type Lockfile struct {
-	// Version correlates to the version of bootstrap
-	// that generated this file.
-	Version string `yaml:"version"`
-
-	// Modules is a list of modules and their versions that was
-	// used the last time stencil was ran.
-	// Note: This is only set in stencil.lock
-	Modules []*LockfileModuleEntry `yaml:"modules"`
-
-	// Files is a list of files and metadata about them that were
-	// generated by stencil
-	Files []*LockfileFileEntry `yaml:"files"`
-}
+type Lockfile = lockfile.Lockfile
 
 // LoadLockfile loads a lockfile from a bootstrap
 // repository path
 func LoadLockfile(path string) (*Lockfile, error) {
-	f, err := os.Open(filepath.Join(path, LockfileName))
+	return LoadLockfileFS(osfs.New(path))
+}
+
+// LoadLockfileFS loads a lockfile from the root of a repository
+// filesystem
+func LoadLockfileFS(fs billy.Filesystem) (*Lockfile, error) {
+	f, err := fs.Open(LockfileName)
 	if err != nil {
 		return nil, err
 	}
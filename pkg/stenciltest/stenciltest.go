@@ -0,0 +1,247 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package stenciltest contains code for testing the templates of a
+// template module. Rendered files are compared against snapshots stored
+// in the testdata directory of the package under test, which are
+// (re)generated by running `go test` with the -update flag.
+package stenciltest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"go.rgst.io/stencil/internal/codegen"
+	"go.rgst.io/stencil/internal/modules"
+	"go.rgst.io/stencil/internal/modules/modulestest"
+	iapiv1 "go.rgst.io/stencil/internal/modules/nativeext/apiv1"
+	"go.rgst.io/stencil/pkg/configuration"
+	"go.rgst.io/stencil/pkg/extensions/apiv1"
+	"go.rgst.io/stencil/pkg/slogext"
+	"gopkg.in/yaml.v3"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/golden"
+)
+
+// manifestFile is the name of the manifest of a template module
+const manifestFile = "manifest.yaml"
+
+// Template is a template that is being tested by the stenciltest
+// framework.
+type Template struct {
+	t   *testing.T
+	log slogext.Logger
+
+	// path is the path to the template under test, relative to the
+	// current directory.
+	path string
+
+	// additionalTemplates is a list of templates, relative to the current
+	// directory, that are rendered alongside the template under test but
+	// not snapshotted.
+	additionalTemplates []string
+
+	// args are the arguments passed to the templates
+	args map[string]any
+
+	// exts are the mocked extensions available to the templates
+	exts map[string]apiv1.Implementation
+
+	// errStr is the error that rendering is expected to fail with
+	errStr string
+}
+
+// New creates a new test for the template at templatePath, which is
+// relative to the current directory (e.g. README.md.tpl for a test in
+// the templates directory). The module the template belongs to is found
+// by searching the current directory and its parents for manifest.yaml.
+// Any additional templates are rendered as well, but only the files
+// created by templatePath are compared against snapshots.
+func New(t *testing.T, templatePath string, additionalTemplates ...string) *Template {
+	return &Template{
+		t:                   t,
+		log:                 slogext.NewTestLogger(t),
+		path:                templatePath,
+		additionalTemplates: additionalTemplates,
+		args:                map[string]any{},
+		exts:                map[string]apiv1.Implementation{},
+	}
+}
+
+// Args sets the arguments passed to the templates
+func (t *Template) Args(args map[string]any) *Template {
+	t.args = args
+	return t
+}
+
+// Ext registers a mocked native extension with the provided name (e.g.
+// github.com/rgst-io/stencil-golang). Arguments and return values are
+// serialized the same way they would be for a real extension.
+func (t *Template) Ext(name string, ext apiv1.Implementation) *Template {
+	t.exts[name] = ext
+	return t
+}
+
+// ErrorContains runs the test and asserts that rendering fails with an
+// error containing msg.
+func (t *Template) ErrorContains(msg string) {
+	t.t.Helper()
+
+	t.errStr = msg
+	t.Run()
+}
+
+// Run renders the template and compares every file it created against
+// its snapshot in testdata. Snapshots are updated instead when the -update
+// flag is passed to `go test`.
+func (t *Template) Run() {
+	t.t.Helper()
+	ctx := context.Background()
+
+	m, tplPath, err := t.newModule(ctx)
+	assert.NilError(t.t, err, "failed to create module")
+
+	st := codegen.NewStencil(&configuration.Manifest{
+		Name:      "testing",
+		Arguments: t.args,
+	}, []*modules.Module{m}, t.log)
+	defer st.Close()
+
+	// Render a new project, so that files next to the test aren't used
+	// as existing files.
+	st.SetProjectFS(memfs.New())
+
+	for name, ext := range t.exts {
+		st.RegisterInprocExtensions(name, iapiv1.NewInprocExtensionClient(ext))
+	}
+
+	tpls, err := st.Render(ctx, t.log)
+	if t.errStr != "" {
+		assert.ErrorContains(t.t, err, t.errStr, "expected render to fail")
+		return
+	}
+	assert.NilError(t.t, err, "failed to render templates")
+
+	for _, tpl := range tpls {
+		if tpl.Path != tplPath {
+			continue
+		}
+
+		for _, f := range tpl.Files {
+			if f.Deleted || f.Skipped {
+				continue
+			}
+			golden.Assert(t.t, f.String(), snapshotName(t.t.Name(), f.Name()))
+		}
+	}
+}
+
+// newModule creates a module containing the manifest and the templates
+// of the module under test. The path of the template under test,
+// relative to the templates directory, is returned as well.
+func (t *Template) newModule(ctx context.Context) (*modules.Module, string, error) {
+	root, err := findModuleRoot()
+	if err != nil {
+		return nil, "", err
+	}
+
+	b, err := os.ReadFile(filepath.Join(root, manifestFile))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var mf configuration.TemplateRepositoryManifest
+	if err := yaml.Unmarshal(b, &mf); err != nil {
+		return nil, "", fmt.Errorf("failed to parse %s: %w", manifestFile, err)
+	}
+
+	fs := memfs.New()
+	if err := util.WriteFile(fs, manifestFile, b, 0o644); err != nil {
+		return nil, "", err
+	}
+
+	var tplPath string
+	for i, tpl := range append([]string{t.path}, t.additionalTemplates...) {
+		rel, err := copyToFS(fs, root, tpl)
+		if err != nil {
+			return nil, "", err
+		}
+		if i == 0 {
+			tplPath = strings.TrimPrefix(rel, "templates/")
+		}
+	}
+
+	m, err := modulestest.NewWithFS(ctx, mf.Name, fs)
+	return m, tplPath, err
+}
+
+// copyToFS copies the template at path into fs, at the same path
+// relative to root, and returns that path.
+func copyToFS(fs billy.Filesystem, root, path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return "", err
+	}
+	rel = filepath.ToSlash(rel)
+	if !strings.HasPrefix(rel, "templates/") {
+		return "", fmt.Errorf("template %q is not inside the templates directory of the module", path)
+	}
+
+	b, err := os.ReadFile(abs)
+	if err != nil {
+		return "", fmt.Errorf("failed to read template: %w", err)
+	}
+	return rel, util.WriteFile(fs, rel, b, 0o644)
+}
+
+// findModuleRoot returns the closest directory, starting at the current
+// directory, that contains a module manifest.
+func findModuleRoot() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		if _, err := os.Stat(filepath.Join(dir, manifestFile)); err == nil {
+			return dir, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("failed to find module root, no " + manifestFile + " in current or parent directories")
+		}
+		dir = parent
+	}
+}
+
+// snapshotName returns the name of the snapshot for the provided file
+// created in the provided test.
+func snapshotName(testName, file string) string {
+	r := strings.NewReplacer("/", "_", "\\", "_", " ", "_")
+	return r.Replace(testName) + "-" + r.Replace(file) + ".snapshot"
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stenciltest_test
+
+import (
+	"os"
+	"testing"
+
+	"go.rgst.io/stencil/pkg/extensions/apiv1"
+	"go.rgst.io/stencil/pkg/stenciltest"
+	"gotest.tools/v3/assert"
+)
+
+// extension is a mocked native extension
+type extension struct{}
+
+// GetConfig implements apiv1.Implementation
+func (*extension) GetConfig() (*apiv1.Config, error) {
+	return &apiv1.Config{}, nil
+}
+
+// GetTemplateFunctions implements apiv1.Implementation
+func (*extension) GetTemplateFunctions() ([]*apiv1.TemplateFunction, error) {
+	return []*apiv1.TemplateFunction{{Name: "Greeting", NumberOfArguments: 1}}, nil
+}
+
+// ExecuteTemplateFunction implements apiv1.Implementation
+func (*extension) ExecuteTemplateFunction(t *apiv1.TemplateFunctionExec) (interface{}, error) {
+	return "Nice to meet you, " + t.Arguments[0].(string) + ".", nil
+}
+
+// chdir changes the current directory to the test module for the
+// duration of the test.
+func chdir(t *testing.T) {
+	wd, err := os.Getwd()
+	assert.NilError(t, err)
+	assert.NilError(t, os.Chdir("testdata/module"))
+	t.Cleanup(func() { assert.NilError(t, os.Chdir(wd)) })
+}
+
+func TestCanSnapshotTemplate(t *testing.T) {
+	chdir(t)
+
+	stenciltest.New(t, "templates/hello.txt.tpl").
+		Args(map[string]any{"name": "stencil"}).
+		Ext("test", &extension{}).
+		Run()
+}
+
+func TestCanSnapshotMultipleFiles(t *testing.T) {
+	chdir(t)
+
+	stenciltest.New(t, "templates/files.tpl", "templates/library.library.tpl").Run()
+}
+
+func TestCanExpectErrors(t *testing.T) {
+	chdir(t)
+
+	stenciltest.New(t, "templates/hello.txt.tpl").
+		Args(map[string]any{"name": "stencil"}).
+		ErrorContains("unknown extension 'test'")
+}
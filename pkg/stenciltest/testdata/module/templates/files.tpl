@@ -0,0 +1,5 @@
+{{- file.Skip "Only creates other files" }}
+{{- range $_, $name := list "a" "b" }}
+{{- file.Create (printf "files/%s.txt" $name) 0o644 now }}
+{{- file.SetContents (stencil.ApplyTemplate "shout" $name) }}
+{{- end }}
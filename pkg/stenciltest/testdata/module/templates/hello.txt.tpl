@@ -0,0 +1,2 @@
+Hello, {{ stencil.Arg "name" }}!
+{{ extensions.Call "test.Greeting" (stencil.Arg "name") }}
@@ -0,0 +1 @@
+{{- define "shout" }}{{ . | upper }}{{ end -}}
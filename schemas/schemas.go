@@ -0,0 +1,32 @@
+// Copyright (C) 2024 stencil contributors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package schemas contains the JSON schemas of the configuration files
+// used by stencil. The schemas are generated by tools/schemagen.
+package schemas
+
+import (
+	// We're using embed
+	_ "embed"
+)
+
+// Manifest is the JSON schema of a module's manifest.yaml
+//
+//go:embed manifest.jsonschema.json
+var Manifest []byte
+
+// Stencil is the JSON schema of a project's stencil.yaml
+//
+//go:embed stencil.jsonschema.json
+var Stencil []byte
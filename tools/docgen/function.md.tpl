@@ -13,7 +13,7 @@ order: {{ order }}
 {{- range . -}}
 	{{- if eq .Kind "text" -}}
     {{- if contains "**NOTE**:" .Text -}}
-      > {{ wrap 72 .Text | replace "**NOTE**:" "[!NOTE]\n>" }}
+      > {{ wrap 72 .Text | replace "\n" "\n> " | replace "**NOTE**:" "[!NOTE]\n>" }}
     {{- else }}
 		  {{- wrap 72 .Text -}}
     {{- end }}